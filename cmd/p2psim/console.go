@@ -0,0 +1,231 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of go-ethereum.
+//
+// go-ethereum is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// go-ethereum is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with go-ethereum. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/console"
+	"github.com/ethereum/go-ethereum/internal/jsre"
+	"github.com/ethereum/go-ethereum/p2p/simulations"
+	"github.com/ethereum/go-ethereum/p2p/simulations/adapters"
+	"github.com/peterh/liner"
+	"github.com/robertkrimen/otto"
+	"gopkg.in/urfave/cli.v1"
+)
+
+// runConsole starts a JavaScript console with the simulation bound to the
+// "sim" object, running any given scripts or the --exec statement instead
+// of the interactive prompt
+func runConsole(ctx *cli.Context) error {
+	out := ctx.App.Writer
+	re := jsre.New(".", out)
+	defer re.Stop(false)
+	if err := bindSimulation(re); err != nil {
+		return err
+	}
+
+	if code := ctx.String("exec"); code != "" {
+		return re.Evaluate(code, out)
+	}
+	if len(ctx.Args()) > 0 {
+		for _, file := range ctx.Args() {
+			if err := re.Exec(file); err != nil {
+				return fmt.Errorf("error running %s: %s", file, err)
+			}
+		}
+		return nil
+	}
+
+	fmt.Fprintln(out, "Welcome to the p2psim console, the simulation is controlled by the sim object.")
+	fmt.Fprintln(out, "To exit, press ctrl-d or type exit")
+	prompter := console.Stdin
+	for {
+		line, err := prompter.PromptInput("> ")
+		if err == liner.ErrPromptAborted {
+			continue
+		} else if err != nil {
+			return nil
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if line == "exit" {
+			return nil
+		}
+		prompter.AppendHistory(line)
+		re.Evaluate(line, out)
+	}
+}
+
+// bindSimulation sets the "sim" object in the JavaScript runtime, whose
+// methods call the simulation API
+func bindSimulation(re *jsre.JSRE) error {
+	methods := map[string]func(call otto.FunctionCall) (interface{}, error){
+		"network": func(call otto.FunctionCall) (interface{}, error) {
+			return client.GetNetwork()
+		},
+		"nodes": func(call otto.FunctionCall) (interface{}, error) {
+			return client.GetNodes()
+		},
+		"node": func(call otto.FunctionCall) (interface{}, error) {
+			return client.GetNode(call.Argument(0).String())
+		},
+		"createNode": func(call otto.FunctionCall) (interface{}, error) {
+			config := &adapters.NodeConfig{}
+			if name := call.Argument(0); name.IsDefined() {
+				config.Name = name.String()
+			}
+			return client.CreateNode(config)
+		},
+		"startNode": func(call otto.FunctionCall) (interface{}, error) {
+			return nil, client.StartNode(call.Argument(0).String())
+		},
+		"stopNode": func(call otto.FunctionCall) (interface{}, error) {
+			return nil, client.StopNode(call.Argument(0).String())
+		},
+		"crashNode": func(call otto.FunctionCall) (interface{}, error) {
+			return nil, client.CrashNode(call.Argument(0).String())
+		},
+		"upgradeNode": func(call otto.FunctionCall) (interface{}, error) {
+			return nil, client.UpgradeNode(call.Argument(0).String(), call.Argument(1).String())
+		},
+		"connect": func(call otto.FunctionCall) (interface{}, error) {
+			return nil, client.ConnectNode(call.Argument(0).String(), call.Argument(1).String())
+		},
+		"disconnect": func(call otto.FunctionCall) (interface{}, error) {
+			return nil, client.DisconnectNode(call.Argument(0).String(), call.Argument(1).String())
+		},
+		"partition": func(call otto.FunctionCall) (interface{}, error) {
+			groupA, err := stringList(call.Argument(0))
+			if err != nil {
+				return nil, err
+			}
+			groupB, err := stringList(call.Argument(1))
+			if err != nil {
+				return nil, err
+			}
+			return nil, client.Partition(groupA, groupB)
+		},
+		"heal": func(call otto.FunctionCall) (interface{}, error) {
+			return nil, client.Heal()
+		},
+		"snapshot": func(call otto.FunctionCall) (interface{}, error) {
+			return client.CreateSnapshot()
+		},
+		"stats": func(call otto.FunctionCall) (interface{}, error) {
+			return client.GetStats()
+		},
+		"mockerTypes": func(call otto.FunctionCall) (interface{}, error) {
+			return client.GetMockerList()
+		},
+		"mockers": func(call otto.FunctionCall) (interface{}, error) {
+			return client.GetRunningMockers()
+		},
+		"startMocker": func(call otto.FunctionCall) (interface{}, error) {
+			config := simulations.DefaultMockerConfig()
+			if typ := call.Argument(0); typ.IsDefined() {
+				config.Type = typ.String()
+			}
+			if count := call.Argument(1); count.IsDefined() {
+				n, err := count.ToInteger()
+				if err != nil {
+					return nil, err
+				}
+				config.NodeCount = int(n)
+			}
+			return client.CreateMocker(config)
+		},
+		"stopMocker": func(call otto.FunctionCall) (interface{}, error) {
+			id, err := call.Argument(0).ToInteger()
+			if err != nil {
+				return nil, err
+			}
+			return nil, client.DeleteMocker(uint64(id))
+		},
+	}
+
+	var err error
+	re.Do(func(vm *otto.Otto) {
+		var sim *otto.Object
+		if sim, err = vm.Object("({})"); err != nil {
+			return
+		}
+		for name, method := range methods {
+			if err = sim.Set(name, jsMethod(method)); err != nil {
+				return
+			}
+		}
+		err = vm.Set("sim", sim)
+	})
+	return err
+}
+
+// jsMethod wraps a simulation method so that its result is returned as a
+// plain JavaScript value and its error is thrown as an exception
+func jsMethod(method func(call otto.FunctionCall) (interface{}, error)) func(call otto.FunctionCall) otto.Value {
+	return func(call otto.FunctionCall) otto.Value {
+		result, err := method(call)
+		if err != nil {
+			throwJSException(err.Error())
+		}
+		if result == nil {
+			return otto.UndefinedValue()
+		}
+		data, err := json.Marshal(result)
+		if err != nil {
+			throwJSException(err.Error())
+		}
+		val, err := call.Otto.Call("JSON.parse", nil, string(data))
+		if err != nil {
+			throwJSException(err.Error())
+		}
+		return val
+	}
+}
+
+// stringList converts a JavaScript array to a list of strings
+func stringList(val otto.Value) ([]string, error) {
+	if !val.IsObject() || val.Class() != "Array" {
+		return nil, fmt.Errorf("expected an array, got %s", val)
+	}
+	exported, err := val.Export()
+	if err != nil {
+		return nil, err
+	}
+	var list []string
+	switch v := exported.(type) {
+	case []string:
+		list = v
+	case []interface{}:
+		for _, item := range v {
+			list = append(list, fmt.Sprint(item))
+		}
+	}
+	return list, nil
+}
+
+func throwJSException(msg interface{}) otto.Value {
+	val, err := otto.ToValue(msg)
+	if err != nil {
+		val = otto.UndefinedValue()
+	}
+	panic(val)
+}
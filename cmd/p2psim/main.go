@@ -42,6 +42,7 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"strconv"
 	"strings"
 	"text/tabwriter"
 
@@ -97,12 +98,88 @@ func main() {
 			Name:   "snapshot",
 			Usage:  "create a network snapshot to stdout",
 			Action: createSnapshot,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "format",
+					Value: "json",
+					Usage: fmt.Sprintf("snapshot format (%s)", strings.Join(simulations.SnapshotFormats, ", ")),
+				},
+			},
 		},
 		{
 			Name:   "load",
 			Usage:  "load a network snapshot from stdin",
 			Action: loadSnapshot,
 		},
+		{
+			Name:      "console",
+			ArgsUsage: "[<script>...]",
+			Usage:     "start a JavaScript console controlling the simulation",
+			Action:    runConsole,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "exec",
+					Value: "",
+					Usage: "execute a JavaScript statement instead of starting the console",
+				},
+			},
+		},
+		{
+			Name:   "mocker",
+			Usage:  "manage simulation mockers",
+			Action: listMockers,
+			Subcommands: []cli.Command{
+				{
+					Name:   "list",
+					Usage:  "list running mockers",
+					Action: listMockers,
+				},
+				{
+					Name:   "types",
+					Usage:  "list available mocker types",
+					Action: listMockerTypes,
+				},
+				{
+					Name:   "start",
+					Usage:  "start a mocker",
+					Action: startMocker,
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:  "type",
+							Value: simulations.DefaultMockerConfig().Type,
+							Usage: "mocker type",
+						},
+						cli.IntFlag{
+							Name:  "node-count",
+							Value: simulations.DefaultMockerConfig().NodeCount,
+							Usage: "number of nodes the mocker creates",
+						},
+						cli.Int64Flag{
+							Name:  "seed",
+							Usage: "seed for the mocker's node keys and random choices (random if 0)",
+						},
+					},
+				},
+				{
+					Name:      "stop",
+					ArgsUsage: "<id>",
+					Usage:     "stop a mocker",
+					Action:    stopMocker,
+				},
+				{
+					Name:      "pause",
+					ArgsUsage: "<id>",
+					Usage:     "pause a mocker, leaving the network as it is",
+					Action:    pauseMocker,
+				},
+				{
+					Name:      "resume",
+					ArgsUsage: "<id>",
+					Usage:     "resume a paused mocker",
+					Action:    resumeMocker,
+				},
+			},
+		},
 		{
 			Name:   "node",
 			Usage:  "manage simulation nodes",
@@ -133,6 +210,15 @@ func main() {
 							Value: "",
 							Usage: "node private key (hex encoded)",
 						},
+						cli.BoolFlag{
+							Name:  "nat",
+							Usage: "node is behind a NAT and can't accept inbound connections",
+						},
+						cli.StringFlag{
+							Name:  "zone",
+							Value: "",
+							Usage: "zone the node is in, determining the latency of its connections",
+						},
 					},
 				},
 				{
@@ -153,6 +239,18 @@ func main() {
 					Usage:     "stop a node",
 					Action:    stopNode,
 				},
+				{
+					Name:      "crash",
+					ArgsUsage: "<node>",
+					Usage:     "stop a node without disconnecting from its peers",
+					Action:    crashNode,
+				},
+				{
+					Name:      "upgrade",
+					ArgsUsage: "<node> <version>",
+					Usage:     "change the version of a node, restarting it if it is up",
+					Action:    upgradeNode,
+				},
 				{
 					Name:      "connect",
 					ArgsUsage: "<node> <peer>",
@@ -232,7 +330,7 @@ func createSnapshot(ctx *cli.Context) error {
 	if err != nil {
 		return err
 	}
-	return json.NewEncoder(os.Stdout).Encode(snap)
+	return simulations.WriteSnapshot(os.Stdout, snap, ctx.String("format"))
 }
 
 func loadSnapshot(ctx *cli.Context) error {
@@ -246,6 +344,101 @@ func loadSnapshot(ctx *cli.Context) error {
 	return client.LoadSnapshot(snap)
 }
 
+func listMockers(ctx *cli.Context) error {
+	if len(ctx.Args()) != 0 {
+		return cli.ShowCommandHelp(ctx, ctx.Command.Name)
+	}
+	mockers, err := client.GetRunningMockers()
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(ctx.App.Writer, 1, 2, 2, ' ', 0)
+	defer w.Flush()
+	fmt.Fprintf(w, "ID\tTYPE\tNODES\tPAUSED\n")
+	for _, mocker := range mockers {
+		fmt.Fprintf(w, "%d\t%s\t%d\t%t\n", mocker.ID, mocker.Config.Type, mocker.Config.NodeCount, mocker.Paused)
+	}
+	return nil
+}
+
+func listMockerTypes(ctx *cli.Context) error {
+	if len(ctx.Args()) != 0 {
+		return cli.ShowCommandHelp(ctx, ctx.Command.Name)
+	}
+	types, err := client.GetMockerList()
+	if err != nil {
+		return err
+	}
+	for _, name := range types {
+		fmt.Fprintln(ctx.App.Writer, name)
+	}
+	return nil
+}
+
+func startMocker(ctx *cli.Context) error {
+	if len(ctx.Args()) != 0 {
+		return cli.ShowCommandHelp(ctx, ctx.Command.Name)
+	}
+	mocker, err := client.CreateMocker(&simulations.MockerConfig{
+		Type:      ctx.String("type"),
+		NodeCount: ctx.Int("node-count"),
+		Seed:      ctx.Int64("seed"),
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(ctx.App.Writer, "Started mocker", mocker.ID)
+	return nil
+}
+
+func stopMocker(ctx *cli.Context) error {
+	args := ctx.Args()
+	if len(args) != 1 {
+		return cli.ShowCommandHelp(ctx, ctx.Command.Name)
+	}
+	id, err := strconv.ParseUint(args[0], 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid mocker ID %q", args[0])
+	}
+	if err := client.DeleteMocker(id); err != nil {
+		return err
+	}
+	fmt.Fprintln(ctx.App.Writer, "Stopped mocker", id)
+	return nil
+}
+
+func pauseMocker(ctx *cli.Context) error {
+	args := ctx.Args()
+	if len(args) != 1 {
+		return cli.ShowCommandHelp(ctx, ctx.Command.Name)
+	}
+	id, err := strconv.ParseUint(args[0], 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid mocker ID %q", args[0])
+	}
+	if err := client.PauseRunningMocker(id); err != nil {
+		return err
+	}
+	fmt.Fprintln(ctx.App.Writer, "Paused mocker", id)
+	return nil
+}
+
+func resumeMocker(ctx *cli.Context) error {
+	args := ctx.Args()
+	if len(args) != 1 {
+		return cli.ShowCommandHelp(ctx, ctx.Command.Name)
+	}
+	id, err := strconv.ParseUint(args[0], 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid mocker ID %q", args[0])
+	}
+	if err := client.ResumeRunningMocker(id); err != nil {
+		return err
+	}
+	fmt.Fprintln(ctx.App.Writer, "Resumed mocker", id)
+	return nil
+}
+
 func listNodes(ctx *cli.Context) error {
 	if len(ctx.Args()) != 0 {
 		return cli.ShowCommandHelp(ctx, ctx.Command.Name)
@@ -277,6 +470,8 @@ func createNode(ctx *cli.Context) error {
 	}
 	config := &adapters.NodeConfig{
 		Name: ctx.String("name"),
+		NAT:  ctx.Bool("nat"),
+		Zone: ctx.String("zone"),
 	}
 	if key := ctx.String("key"); key != "" {
 		privKey, err := crypto.HexToECDSA(key)
@@ -348,6 +543,32 @@ func stopNode(ctx *cli.Context) error {
 	return nil
 }
 
+func crashNode(ctx *cli.Context) error {
+	args := ctx.Args()
+	if len(args) != 1 {
+		return cli.ShowCommandHelp(ctx, ctx.Command.Name)
+	}
+	nodeName := args[0]
+	if err := client.CrashNode(nodeName); err != nil {
+		return err
+	}
+	fmt.Fprintln(ctx.App.Writer, "Crashed", nodeName)
+	return nil
+}
+
+func upgradeNode(ctx *cli.Context) error {
+	args := ctx.Args()
+	if len(args) != 2 {
+		return cli.ShowCommandHelp(ctx, ctx.Command.Name)
+	}
+	nodeName, version := args[0], args[1]
+	if err := client.UpgradeNode(nodeName, version); err != nil {
+		return err
+	}
+	fmt.Fprintln(ctx.App.Writer, "Upgraded", nodeName, "to", version)
+	return nil
+}
+
 func connectNode(ctx *cli.Context) error {
 	args := ctx.Args()
 	if len(args) != 2 {
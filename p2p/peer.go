@@ -109,6 +109,9 @@ type Peer struct {
 
 	// events receives message send / receive events if set
 	events *event.Feed
+
+	// wrapRW wraps the MsgReadWriter of each protocol if set
+	wrapRW func(peer *Peer, protocol string, rw MsgReadWriter) MsgReadWriter
 }
 
 // NewPeer returns a peer for testing purposes.
@@ -344,6 +347,9 @@ func (p *Peer) startProtocols(writeStart <-chan struct{}, writeErr chan<- error)
 		if p.events != nil {
 			rw = newMsgEventer(rw, p.events, p.ID(), proto.Name)
 		}
+		if p.wrapRW != nil {
+			rw = p.wrapRW(p, proto.Name, rw)
+		}
 		p.log.Trace(fmt.Sprintf("Starting protocol %s/%d", proto.Name, proto.Version))
 		go func() {
 			err := proto.Run(p, rw)
@@ -139,6 +139,11 @@ type Config struct {
 	// whenever a message is sent to or received from a peer
 	EnableMsgEvents bool
 
+	// If WrapProtocolRW is set, it is called with the MsgReadWriter of each
+	// protocol run with a peer and the protocol is given the result
+	// instead. Simulations use it to instrument or tamper with messages.
+	WrapProtocolRW func(peer *Peer, protocol string, rw MsgReadWriter) MsgReadWriter `toml:"-"`
+
 	// Logger is a custom logger to use with the p2p.Server.
 	Logger log.Logger `toml:",omitempty"`
 }
@@ -340,8 +345,8 @@ func (srv *Server) makeSelf(listener net.Listener, ntab discoverTable) *discover
 // It blocks until all active connections have been closed.
 func (srv *Server) Stop() {
 	srv.lock.Lock()
-	defer srv.lock.Unlock()
 	if !srv.running {
+		srv.lock.Unlock()
 		return
 	}
 	srv.running = false
@@ -350,6 +355,10 @@ func (srv *Server) Stop() {
 		srv.listener.Close()
 	}
 	close(srv.quit)
+	srv.lock.Unlock()
+
+	// wait without the lock held since the run loop calls Self, which
+	// takes the lock, when checking an inbound connection
 	srv.loopWG.Wait()
 }
 
@@ -641,6 +650,7 @@ running:
 				if srv.EnableMsgEvents {
 					p.events = &srv.peerFeed
 				}
+				p.wrapRW = srv.WrapProtocolRW
 				name := truncateName(c.name)
 				srv.log.Debug("Adding p2p peer", "name", name, "addr", c.fd.RemoteAddr(), "peers", len(peers)+1)
 				go srv.runPeer(p)
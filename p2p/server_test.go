@@ -453,6 +453,57 @@ func TestServerSetupConn(t *testing.T) {
 	}
 }
 
+// This test checks that Stop returns while connections are being set up.
+// The run loop takes the server lock when checking a connection, so Stop
+// must not hold it while waiting for the loop to exit.
+func TestServerStopDuringSetupConn(t *testing.T) {
+	for i := 0; i < 20; i++ {
+		srv := &Server{
+			Config: Config{
+				PrivateKey: newkey(),
+				MaxPeers:   10,
+				NoDial:     true,
+			},
+			newTransport: func(fd net.Conn) transport {
+				return &setupTransport{id: randomID(), protoHandshakeErr: errors.New("foo")}
+			},
+			log: log.New(),
+		}
+		if err := srv.Start(); err != nil {
+			t.Fatalf("could not start: %v", err)
+		}
+		quit := make(chan struct{})
+		for j := 0; j < 4; j++ {
+			go func() {
+				for {
+					select {
+					case <-quit:
+						return
+					default:
+					}
+					fd, remote := net.Pipe()
+					srv.SetupConn(fd, inboundConn, nil)
+					fd.Close()
+					remote.Close()
+				}
+			}()
+		}
+		time.Sleep(time.Millisecond)
+
+		stopped := make(chan struct{})
+		go func() {
+			srv.Stop()
+			close(stopped)
+		}()
+		select {
+		case <-stopped:
+		case <-time.After(5 * time.Second):
+			t.Fatal("Stop did not return while connections were being set up")
+		}
+		close(quit)
+	}
+}
+
 type setupTransport struct {
 	id              discover.NodeID
 	encHandshakeErr error
@@ -0,0 +1,81 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package adapters
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+// crashConn is one end of a SimAdapter connection which can be made to go
+// dead when its node crashes, rather than being closed
+type crashConn struct {
+	net.Conn
+	node *SimNode
+
+	mtx     sync.Mutex
+	crashed bool
+}
+
+var errCrashed = errors.New("node crashed")
+
+// crash makes the connection go dead: data written by the crashed node is
+// discarded and nothing the peer writes is read any more, so the peer only
+// notices the node has gone once its own reads or writes time out
+func (c *crashConn) crash() {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.crashed = true
+	// unblock the crashed node's pending reads without touching the
+	// peer's end of the pipe
+	c.Conn.SetReadDeadline(time.Now())
+}
+
+func (c *crashConn) isCrashed() bool {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return c.crashed
+}
+
+// Read reads from the underlying connection unless the node has crashed
+func (c *crashConn) Read(b []byte) (int, error) {
+	if c.isCrashed() {
+		return 0, errCrashed
+	}
+	return c.Conn.Read(b)
+}
+
+// Write writes to the underlying connection unless the node has crashed, in
+// which case the data is silently discarded
+func (c *crashConn) Write(b []byte) (int, error) {
+	if c.isCrashed() {
+		return len(b), nil
+	}
+	return c.Conn.Write(b)
+}
+
+// Close closes the underlying connection unless the node has crashed, in
+// which case it is left for the peer to close when it times out
+func (c *crashConn) Close() error {
+	c.node.untrackConn(c)
+	if c.isCrashed() {
+		return nil
+	}
+	return c.Conn.Close()
+}
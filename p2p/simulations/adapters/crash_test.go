@@ -0,0 +1,68 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package adapters
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestCrashConn(t *testing.T) {
+	node := &SimNode{conns: make(map[*crashConn]struct{})}
+	one, other := net.Pipe()
+	defer other.Close()
+	conn := node.trackConn(one)
+
+	// a read blocked when the node crashes should return
+	errc := make(chan error, 1)
+	go func() {
+		_, err := conn.Read(make([]byte, 1))
+		errc <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	node.lock.Lock()
+	for c := range node.conns {
+		c.crash()
+	}
+	node.lock.Unlock()
+	select {
+	case err := <-errc:
+		if err == nil {
+			t.Fatal("expected the read to fail")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for the read to return")
+	}
+
+	// writes by the crashed node are discarded and closing its end
+	// doesn't tell the other end
+	if n, err := conn.Write([]byte{1}); n != 1 || err != nil {
+		t.Fatalf("expected the write to be discarded, got %d, %v", n, err)
+	}
+	if err := conn.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if len(node.conns) != 0 {
+		t.Fatalf("expected the closed connection to be untracked")
+	}
+	other.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	_, err := other.Read(make([]byte, 1))
+	if nerr, ok := err.(net.Error); !ok || !nerr.Timeout() {
+		t.Fatalf("expected the other end to time out, got %v", err)
+	}
+}
@@ -0,0 +1,182 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package adapters
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/event"
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/p2p/discover"
+)
+
+// FaultConfig configures the faults the SimAdapter injects into the protocol
+// messages a node sends to its peers, each field being the probability that
+// a message suffers the given fault
+type FaultConfig struct {
+	// Duplicate is the probability that a message is sent twice
+	Duplicate float64 `json:"duplicate,omitempty"`
+
+	// Reorder is the probability that a message is held back and sent
+	// after the next message
+	Reorder float64 `json:"reorder,omitempty"`
+
+	// Truncate is the probability that a message's payload is cut short
+	Truncate float64 `json:"truncate,omitempty"`
+
+	// BitFlip is the probability that a bit of a message's payload is
+	// flipped
+	BitFlip float64 `json:"bitflip,omitempty"`
+}
+
+// FaultType is the type of fault injected into a message
+type FaultType string
+
+const (
+	// FaultTypeDuplicate is the fault of a message being sent twice
+	FaultTypeDuplicate FaultType = "duplicate"
+
+	// FaultTypeReorder is the fault of a message being sent after the
+	// message which followed it
+	FaultTypeReorder FaultType = "reorder"
+
+	// FaultTypeTruncate is the fault of a message's payload being cut short
+	FaultTypeTruncate FaultType = "truncate"
+
+	// FaultTypeBitFlip is the fault of a bit of a message's payload being
+	// flipped
+	FaultTypeBitFlip FaultType = "bitflip"
+)
+
+// MsgFault is reported when a fault is injected into a message a node sends
+type MsgFault struct {
+	Type     FaultType       `json:"type"`
+	Peer     discover.NodeID `json:"peer"`
+	Protocol string          `json:"protocol"`
+	Code     uint64          `json:"code"`
+}
+
+// FaultReporter is implemented by nodes which inject faults into the
+// messages they send
+type FaultReporter interface {
+	// SubscribeFaults subscribes the given channel to the faults injected
+	// into the node's messages
+	SubscribeFaults(ch chan *MsgFault) event.Subscription
+}
+
+// reorderTimeout is how long a message held back to be reordered waits for
+// a following message before it is sent anyway
+var reorderTimeout = 100 * time.Millisecond
+
+// faultRW is a p2p.MsgReadWriter which injects faults into the messages
+// written to it according to a FaultConfig
+type faultRW struct {
+	p2p.MsgReadWriter
+	config *FaultConfig
+	feed   *event.Feed
+	peer   discover.NodeID
+	proto  string
+
+	mtx   sync.Mutex
+	held  *heldMsg // message held back to be sent after the next one
+	timer *time.Timer
+}
+
+// heldMsg is a message held back by a faultRW
+type heldMsg struct {
+	code uint64
+	data []byte
+}
+
+// WriteMsg writes the message to the underlying MsgReadWriter, possibly
+// duplicating, holding back, truncating or corrupting it first
+func (rw *faultRW) WriteMsg(msg p2p.Msg) error {
+	data, err := ioutil.ReadAll(msg.Payload)
+	if err != nil {
+		return err
+	}
+	var faults []FaultType
+	if len(data) > 0 && rand.Float64() < rw.config.Truncate {
+		data = data[:rand.Intn(len(data))]
+		faults = append(faults, FaultTypeTruncate)
+	}
+	if len(data) > 0 && rand.Float64() < rw.config.BitFlip {
+		i := rand.Intn(len(data) * 8)
+		data[i/8] ^= 1 << uint(i%8)
+		faults = append(faults, FaultTypeBitFlip)
+	}
+	sends := 1
+	if rand.Float64() < rw.config.Duplicate {
+		sends = 2
+		faults = append(faults, FaultTypeDuplicate)
+	}
+
+	rw.mtx.Lock()
+	if rw.held == nil && rand.Float64() < rw.config.Reorder {
+		faults = append(faults, FaultTypeReorder)
+		rw.held = &heldMsg{code: msg.Code, data: data}
+		rw.timer = time.AfterFunc(reorderTimeout, rw.flush)
+		rw.mtx.Unlock()
+		rw.report(msg.Code, faults)
+		return nil
+	}
+	for i := 0; i < sends && err == nil; i++ {
+		err = rw.write(msg.Code, data)
+	}
+	if err == nil && rw.held != nil {
+		rw.timer.Stop()
+		err = rw.write(rw.held.code, rw.held.data)
+		rw.held = nil
+	}
+	rw.mtx.Unlock()
+	rw.report(msg.Code, faults)
+	return err
+}
+
+// flush sends the message held back if no other message followed it in time
+func (rw *faultRW) flush() {
+	rw.mtx.Lock()
+	defer rw.mtx.Unlock()
+	if rw.held != nil {
+		rw.write(rw.held.code, rw.held.data)
+		rw.held = nil
+	}
+}
+
+func (rw *faultRW) write(code uint64, data []byte) error {
+	return rw.MsgReadWriter.WriteMsg(p2p.Msg{
+		Code:    code,
+		Size:    uint32(len(data)),
+		Payload: bytes.NewReader(data),
+	})
+}
+
+// report sends the faults injected into a message to the fault feed
+func (rw *faultRW) report(code uint64, faults []FaultType) {
+	for _, fault := range faults {
+		rw.feed.Send(&MsgFault{
+			Type:     fault,
+			Peer:     rw.peer,
+			Protocol: rw.proto,
+			Code:     code,
+		})
+	}
+}
@@ -0,0 +1,226 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package adapters
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/event"
+	"github.com/ethereum/go-ethereum/node"
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+func TestFaultRW(t *testing.T) {
+	payload := []byte{1, 2, 3, 4}
+	for _, test := range []struct {
+		config FaultConfig
+		fault  FaultType
+		check  func(msgs []p2p.Msg, data [][]byte) bool
+	}{
+		{
+			config: FaultConfig{Duplicate: 1},
+			fault:  FaultTypeDuplicate,
+			check: func(msgs []p2p.Msg, data [][]byte) bool {
+				return len(msgs) == 3 && msgs[0].Code == 0 && msgs[1].Code == 0 && msgs[2].Code == 1
+			},
+		},
+		{
+			config: FaultConfig{Reorder: 1},
+			fault:  FaultTypeReorder,
+			check: func(msgs []p2p.Msg, data [][]byte) bool {
+				return len(msgs) == 2 && msgs[0].Code == 1 && msgs[1].Code == 0
+			},
+		},
+		{
+			config: FaultConfig{Truncate: 1},
+			fault:  FaultTypeTruncate,
+			check: func(msgs []p2p.Msg, data [][]byte) bool {
+				return len(msgs) == 2 && len(data[0]) < len(payload) && bytes.HasPrefix(payload, data[0])
+			},
+		},
+		{
+			config: FaultConfig{BitFlip: 1},
+			fault:  FaultTypeBitFlip,
+			check: func(msgs []p2p.Msg, data [][]byte) bool {
+				return len(msgs) == 2 && len(data[0]) == len(payload) && !bytes.Equal(data[0], payload)
+			},
+		},
+	} {
+		one, other := p2p.MsgPipe()
+		var feed event.Feed
+		faults := make(chan *MsgFault, 10)
+		sub := feed.Subscribe(faults)
+		rw := &faultRW{MsgReadWriter: one, config: &test.config, feed: &feed, proto: "test"}
+
+		// write two messages, only faulting the first
+		go func() {
+			rw.WriteMsg(p2p.Msg{Code: 0, Size: uint32(len(payload)), Payload: bytes.NewReader(payload)})
+			rw.config = &FaultConfig{}
+			rw.WriteMsg(p2p.Msg{Code: 1, Size: uint32(len(payload)), Payload: bytes.NewReader(payload)})
+			one.Close()
+		}()
+		var msgs []p2p.Msg
+		var data [][]byte
+		for {
+			msg, err := other.ReadMsg()
+			if err != nil {
+				break
+			}
+			b, err := ioutil.ReadAll(msg.Payload)
+			if err != nil {
+				t.Fatal(err)
+			}
+			msgs = append(msgs, msg)
+			data = append(data, b)
+		}
+		if !test.check(msgs, data) {
+			t.Fatalf("%s: unexpected messages: %v %x", test.fault, msgs, data)
+		}
+		select {
+		case fault := <-faults:
+			if fault.Type != test.fault || fault.Code != 0 || fault.Protocol != "test" {
+				t.Fatalf("%s: unexpected fault: %v", test.fault, fault)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("%s: timed out waiting for the fault", test.fault)
+		}
+		sub.Unsubscribe()
+	}
+}
+
+// TestSimNodeFaults checks that faults are injected into the messages of
+// every service a SimNode runs
+func TestSimNodeFaults(t *testing.T) {
+	received := make(chan string, 10)
+	adapter := NewSimAdapter(Services{
+		"one": func(ctx *ServiceContext) (node.Service, error) {
+			return &faultTestService{name: "one", received: received}, nil
+		},
+		"two": func(ctx *ServiceContext) (node.Service, error) {
+			return &otherFaultTestService{faultTestService{name: "two", received: received}}, nil
+		},
+	})
+	newNode := func(faults *FaultConfig) *SimNode {
+		config := RandomNodeConfig()
+		config.Services = []string{"one", "two"}
+		config.Faults = faults
+		n, err := adapter.NewNode(config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := n.Start(nil); err != nil {
+			t.Fatal(err)
+		}
+		return n.(*SimNode)
+	}
+	faulty := newNode(&FaultConfig{Duplicate: 1})
+	defer faulty.Stop()
+	other := newNode(nil)
+	defer other.Stop()
+
+	faults := make(chan *MsgFault, 10)
+	sub := faulty.SubscribeFaults(faults)
+	defer sub.Unsubscribe()
+	client, err := faulty.Client()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Call(nil, "admin_addPeer", other.Node().String()); err != nil {
+		t.Fatal(err)
+	}
+
+	// both services of each node send one message, the faulty node's being
+	// duplicated
+	counts := make(map[string]int)
+	timeout := time.After(5 * time.Second)
+	for i := 0; i < 6; i++ {
+		select {
+		case name := <-received:
+			counts[name]++
+		case <-timeout:
+			t.Fatalf("timed out waiting for messages, got %v", counts)
+		}
+	}
+	if counts["one"] != 3 || counts["two"] != 3 {
+		t.Fatalf("expected 3 messages per service, got %v", counts)
+	}
+	reported := make(map[string]bool)
+	for i := 0; i < 2; i++ {
+		select {
+		case fault := <-faults:
+			if fault.Type != FaultTypeDuplicate || fault.Peer != other.ID {
+				t.Fatalf("unexpected fault: %v", fault)
+			}
+			reported[fault.Protocol] = true
+		case <-timeout:
+			t.Fatal("timed out waiting for faults")
+		}
+	}
+	if !reported["one"] || !reported["two"] {
+		t.Fatalf("expected faults from both services, got %v", reported)
+	}
+}
+
+// faultTestService runs a protocol which sends one message to each peer and
+// reports the messages it receives
+type faultTestService struct {
+	name     string
+	received chan string
+}
+
+// otherFaultTestService is a second service type so that a node can run two
+// of them
+type otherFaultTestService struct {
+	faultTestService
+}
+
+func (s *faultTestService) Protocols() []p2p.Protocol {
+	return []p2p.Protocol{{
+		Name:    s.name,
+		Version: 1,
+		Length:  1,
+		Run: func(peer *p2p.Peer, rw p2p.MsgReadWriter) error {
+			// send while reading since the SimAdapter's pipes block writes
+			// until the peer reads them
+			go p2p.Send(rw, 0, []byte{1})
+			for {
+				msg, err := rw.ReadMsg()
+				if err != nil {
+					return err
+				}
+				msg.Discard()
+				s.received <- s.name
+			}
+		},
+	}}
+}
+
+func (s *faultTestService) APIs() []rpc.API {
+	return nil
+}
+
+func (s *faultTestService) Start(*p2p.Server) error {
+	return nil
+}
+
+func (s *faultTestService) Stop() error {
+	return nil
+}
@@ -22,6 +22,8 @@ import (
 	"math"
 	"net"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/ethereum/go-ethereum/event"
 	"github.com/ethereum/go-ethereum/log"
@@ -37,6 +39,7 @@ type SimAdapter struct {
 	mtx      sync.RWMutex
 	nodes    map[discover.NodeID]*SimNode
 	services map[string]ServiceFunc
+	zones    ZoneLatencies // latencies between node zones
 }
 
 // NewSimAdapter creates a SimAdapter which is capable of running in-memory
@@ -75,28 +78,31 @@ func (s *SimAdapter) NewNode(config *NodeConfig) (Node, error) {
 		}
 	}
 
+	simNode := &SimNode{
+		ID:        id,
+		config:    config,
+		adapter:   s,
+		running:   make(map[string]node.Service),
+		conns:     make(map[*crashConn]struct{}),
+		resources: &resourceCounters{},
+	}
+	p2pConfig := p2p.Config{
+		PrivateKey:      config.PrivateKey,
+		MaxPeers:        math.MaxInt32,
+		NoDiscovery:     true,
+		Dialer:          &simDialer{adapter: s, id: id},
+		EnableMsgEvents: true,
+		WrapProtocolRW:  simNode.wrapProtocolRW,
+	}
 	n, err := node.New(&node.Config{
-		P2P: p2p.Config{
-			PrivateKey:      config.PrivateKey,
-			MaxPeers:        math.MaxInt32,
-			NoDiscovery:     true,
-			Dialer:          s,
-			EnableMsgEvents: true,
-		},
+		P2P:    p2pConfig,
 		NoUSB:  true,
 		Logger: log.New("node.id", id.String()),
 	})
 	if err != nil {
 		return nil, err
 	}
-
-	simNode := &SimNode{
-		ID:      id,
-		config:  config,
-		node:    n,
-		adapter: s,
-		running: make(map[string]node.Service),
-	}
+	simNode.node = n
 	s.nodes[id] = simNode
 	return simNode, nil
 }
@@ -104,6 +110,14 @@ func (s *SimAdapter) NewNode(config *NodeConfig) (Node, error) {
 // Dial implements the p2p.NodeDialer interface by connecting to the node using
 // an in-memory net.Pipe connection
 func (s *SimAdapter) Dial(dest *discover.Node) (conn net.Conn, err error) {
+	return s.dial(nil, dest)
+}
+
+// dial connects to the destination node using an in-memory net.Pipe
+// connection, delaying the data each end writes according to its node's
+// LinkConfig and the latency between the nodes' zones (src being nil if the
+// dialing node is unknown)
+func (s *SimAdapter) dial(src *SimNode, dest *discover.Node) (net.Conn, error) {
 	node, ok := s.GetNode(dest.ID)
 	if !ok {
 		return nil, fmt.Errorf("unknown node: %s", dest.ID)
@@ -112,9 +126,28 @@ func (s *SimAdapter) Dial(dest *discover.Node) (conn net.Conn, err error) {
 	if srv == nil {
 		return nil, fmt.Errorf("node not running: %s", dest.ID)
 	}
+	if node.config.NAT {
+		return nil, fmt.Errorf("node behind NAT: %s", dest.ID)
+	}
 	pipe1, pipe2 := net.Pipe()
-	go srv.SetupConn(pipe1, 0, nil)
-	return pipe2, nil
+	go srv.SetupConn(newLinkConn(node.trackConn(pipe1), s.linkBetween(node, src), &node.resources.buffered), 0, nil)
+	if src == nil {
+		return pipe2, nil
+	}
+	return newLinkConn(src.trackConn(pipe2), s.linkBetween(src, node), &src.resources.buffered), nil
+}
+
+// simDialer implements the p2p.NodeDialer interface for a particular node
+// so that the SimAdapter knows both ends of the connections it dials
+type simDialer struct {
+	adapter *SimAdapter
+	id      discover.NodeID
+}
+
+// Dial connects to the given node (see SimAdapter.dial)
+func (d *simDialer) Dial(dest *discover.Node) (net.Conn, error) {
+	src, _ := d.adapter.GetNode(d.id)
+	return d.adapter.dial(src, dest)
 }
 
 // DialRPC implements the RPCDialer interface by creating an in-memory RPC
@@ -151,6 +184,10 @@ type SimNode struct {
 	running      map[string]node.Service
 	client       *rpc.Client
 	registerOnce sync.Once
+	conns        map[*crashConn]struct{} // the node's ends of its connections
+	crashed      bool                    // set by Crash until the node is restarted
+	faults       event.Feed              // faults injected into the node's messages
+	resources    *resourceCounters       // resources used by the node
 }
 
 // Addr returns the node's discovery address
@@ -261,6 +298,7 @@ func (self *SimNode) Start(snapshots map[string][]byte) error {
 
 	self.lock.Lock()
 	self.client = rpc.DialInProc(handler)
+	self.crashed = false
 	self.lock.Unlock()
 
 	return nil
@@ -277,6 +315,39 @@ func (self *SimNode) Stop() error {
 	return self.node.Stop()
 }
 
+// Crash stops the node abruptly: its connections go dead without the node
+// sending its peers a disconnect message, so they only notice it has gone
+// once their reads or writes time out. Services are started from scratch
+// when the node is started again, losing any state they hold in memory.
+func (self *SimNode) Crash() error {
+	self.lock.Lock()
+	self.crashed = true
+	for conn := range self.conns {
+		conn.crash()
+	}
+	self.lock.Unlock()
+	return self.Stop()
+}
+
+// trackConn returns the node's end of a connection so that it can go dead
+// if the node crashes
+func (self *SimNode) trackConn(conn net.Conn) net.Conn {
+	c := &crashConn{Conn: conn, node: self}
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if self.crashed {
+		c.crash()
+	}
+	self.conns[c] = struct{}{}
+	return c
+}
+
+func (self *SimNode) untrackConn(conn *crashConn) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	delete(self.conns, conn)
+}
+
 // Services returns a copy of the underlying services
 func (self *SimNode) Services() []node.Service {
 	self.lock.RLock()
@@ -303,6 +374,43 @@ func (self *SimNode) SubscribeEvents(ch chan *p2p.PeerEvent) event.Subscription
 	return srv.SubscribeEvents(ch)
 }
 
+// SubscribeFaults subscribes the given channel to the faults injected into
+// the messages the node sends (see NodeConfig.Faults)
+func (self *SimNode) SubscribeFaults(ch chan *MsgFault) event.Subscription {
+	return self.faults.Subscribe(ch)
+}
+
+// wrapProtocolRW wraps the MsgReadWriter of each protocol the node runs
+// with a peer so that the protocol handler's resource usage is accounted
+// for and, if the node has a FaultConfig, faults are injected into the
+// messages it sends (see p2p.Config.WrapProtocolRW)
+func (self *SimNode) wrapProtocolRW(peer *p2p.Peer, protocol string, rw p2p.MsgReadWriter) p2p.MsgReadWriter {
+	if self.config.Faults != nil {
+		rw = &faultRW{
+			MsgReadWriter: rw,
+			config:        self.config.Faults,
+			feed:          &self.faults,
+			peer:          peer.ID(),
+			proto:         protocol,
+		}
+	}
+	return self.resources.wrap(peer, rw)
+}
+
+// Resources returns the resources the node is currently using
+func (self *SimNode) Resources() *NodeResources {
+	self.lock.RLock()
+	conns := len(self.conns)
+	self.lock.RUnlock()
+	return &NodeResources{
+		Goroutines:    self.resources.goroutines(self.Server()),
+		Conns:         conns,
+		BufferedBytes: atomic.LoadInt64(&self.resources.buffered),
+		HandlerTime:   time.Duration(atomic.LoadInt64(&self.resources.handlerTime)),
+		Messages:      atomic.LoadInt64(&self.resources.messages),
+	}
+}
+
 // NodeInfo returns information about the node
 func (self *SimNode) NodeInfo() *p2p.NodeInfo {
 	server := self.Server()
@@ -0,0 +1,217 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package adapters
+
+import (
+	"errors"
+	"math/rand"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// LinkConfig models the network link a simulation node sends data over,
+// used by the SimAdapter to delay the data each node writes to its peers
+// (the zero value being an instant link with unlimited bandwidth)
+type LinkConfig struct {
+	// Latency is the time it takes for written data to be delivered
+	Latency time.Duration `json:"latency,omitempty"`
+
+	// Jitter is the maximum random delay added to Latency, data is always
+	// delivered in the order it was written regardless of jitter
+	Jitter time.Duration `json:"jitter,omitempty"`
+
+	// Bandwidth is the number of bytes per second which can be written,
+	// with writes blocking until the link has capacity (0 means unlimited)
+	Bandwidth int `json:"bandwidth,omitempty"`
+
+	// Loss is the probability that written data is lost and has to be
+	// resent. Nodes talk over a reliable stream like TCP, so lost data is
+	// delivered late rather than not at all, delaying it and the data
+	// written after it by a retransmission timeout (see lossDelay).
+	Loss float64 `json:"loss,omitempty"`
+}
+
+// minRetransmitTimeout is the shortest time lost data takes to be resent,
+// which is the minimum retransmission timeout used by TCP on Linux
+const minRetransmitTimeout = 200 * time.Millisecond
+
+// lossDelay returns how long the link delays data which is lost, which is
+// the time it takes to notice the data wasn't acknowledged (twice the
+// latency, but at least minRetransmitTimeout)
+func (l *LinkConfig) lossDelay() time.Duration {
+	if delay := 2 * l.Latency; delay > minRetransmitTimeout {
+		return delay
+	}
+	return minRetransmitTimeout
+}
+
+// linkConn is a net.Conn which delays writes to the underlying connection
+// according to a LinkConfig
+type linkConn struct {
+	net.Conn
+	link LinkConfig
+
+	// writeMtx serialises writes, which block while the link is busy, and
+	// is taken by Close to wait for a blocked write to return before
+	// closing the queue. It is never held while waiting for mtx.
+	writeMtx sync.Mutex
+
+	buffered *int64 // bytes written but not yet delivered
+
+	mtx       sync.Mutex
+	busyUntil time.Time // when the link finishes sending written data
+	lastAt    time.Time // when the last written data is delivered
+	closed    bool
+
+	queue   chan linkPacket
+	closing chan struct{}
+	done    chan struct{}
+}
+
+// linkPacket is data waiting to be delivered by a linkConn
+type linkPacket struct {
+	data []byte
+	at   time.Time
+}
+
+var errLinkClosed = errors.New("link closed")
+
+// newLinkConn returns conn if the link doesn't delay data, otherwise a
+// linkConn which delays data written to conn, counting the data waiting to
+// be delivered in buffered
+func newLinkConn(conn net.Conn, link *LinkConfig, buffered *int64) net.Conn {
+	if link == nil || *link == (LinkConfig{}) {
+		return conn
+	}
+	c := &linkConn{
+		Conn:     conn,
+		link:     *link,
+		buffered: buffered,
+		queue:    make(chan linkPacket, 1024),
+		closing:  make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go c.deliver()
+	return c
+}
+
+// Write queues the data to be delivered once it has been sent over the link
+// and the link's latency has passed, blocking while the link is busy
+// sending previously written data
+func (c *linkConn) Write(b []byte) (int, error) {
+	c.writeMtx.Lock()
+	defer c.writeMtx.Unlock()
+
+	at, busyUntil, err := c.schedule(len(b))
+	if err != nil {
+		return 0, err
+	}
+	data := make([]byte, len(b))
+	copy(data, b)
+	atomic.AddInt64(c.buffered, int64(len(data)))
+	select {
+	case c.queue <- linkPacket{data: data, at: at}:
+	case <-c.closing:
+		atomic.AddInt64(c.buffered, -int64(len(data)))
+		return 0, errLinkClosed
+	}
+
+	// wait until the link has capacity for more data
+	select {
+	case <-time.After(time.Until(busyUntil)):
+	case <-c.closing:
+	}
+	return len(b), nil
+}
+
+// schedule returns when data of the given size written now is delivered
+// and when the link has capacity for more data
+func (c *linkConn) schedule(size int) (at, busyUntil time.Time, err error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if c.closed {
+		return at, busyUntil, errLinkClosed
+	}
+	now := time.Now()
+	start := c.busyUntil
+	if start.Before(now) {
+		start = now
+	}
+	c.busyUntil = start
+	if c.link.Bandwidth > 0 {
+		c.busyUntil = start.Add(time.Duration(size) * time.Second / time.Duration(c.link.Bandwidth))
+	}
+	at = c.busyUntil.Add(c.link.Latency)
+	if c.link.Jitter > 0 {
+		at = at.Add(time.Duration(rand.Int63n(int64(c.link.Jitter))))
+	}
+	if c.link.Loss > 0 && rand.Float64() < c.link.Loss {
+		at = at.Add(c.link.lossDelay())
+	}
+	if at.Before(c.lastAt) {
+		at = c.lastAt
+	}
+	c.lastAt = at
+	return at, c.busyUntil, nil
+}
+
+// Close closes the underlying connection once all queued data has been
+// delivered, or a second after it was due if the other end stops reading
+func (c *linkConn) Close() error {
+	c.mtx.Lock()
+	if c.closed {
+		c.mtx.Unlock()
+		return errLinkClosed
+	}
+	c.closed = true
+	timeout := time.Until(c.lastAt) + time.Second
+	c.mtx.Unlock()
+
+	// unblock a write waiting for the link before closing the queue
+	close(c.closing)
+	c.writeMtx.Lock()
+	close(c.queue)
+	c.writeMtx.Unlock()
+	go func() {
+		select {
+		case <-c.done:
+		case <-time.After(timeout):
+			c.Conn.Close()
+		}
+	}()
+	return nil
+}
+
+// deliver writes queued data to the underlying connection when it is due
+func (c *linkConn) deliver() {
+	defer close(c.done)
+	defer c.Conn.Close()
+	for packet := range c.queue {
+		time.Sleep(time.Until(packet.at))
+		_, err := c.Conn.Write(packet.data)
+		atomic.AddInt64(c.buffered, -int64(len(packet.data)))
+		if err != nil {
+			// drain the queue so writers don't block
+			for packet := range c.queue {
+				atomic.AddInt64(c.buffered, -int64(len(packet.data)))
+			}
+			return
+		}
+	}
+}
@@ -0,0 +1,183 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package adapters
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestLinkLatency(t *testing.T) {
+	one, other := net.Pipe()
+	var buffered int64
+	conn := newLinkConn(one, &LinkConfig{Latency: 50 * time.Millisecond, Jitter: 20 * time.Millisecond}, &buffered)
+	defer other.Close()
+
+	// writes shouldn't wait for the latency, and data should be delivered
+	// in order regardless of jitter
+	start := time.Now()
+	var sent []byte
+	for i := 0; i < 50; i++ {
+		sent = append(sent, byte(i))
+		if _, err := conn.Write([]byte{byte(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed > 40*time.Millisecond {
+		t.Fatalf("expected writes to return immediately, took %s", elapsed)
+	}
+	if n := atomic.LoadInt64(&buffered); n != int64(len(sent)) {
+		t.Fatalf("expected %d bytes to be buffered, got %d", len(sent), n)
+	}
+	received := make([]byte, len(sent))
+	if _, err := io.ReadFull(other, received); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Fatalf("expected data to be delayed by at least 50ms, took %s", elapsed)
+	}
+	if !bytes.Equal(received, sent) {
+		t.Fatalf("expected to receive %x, got %x", sent, received)
+	}
+
+	// closing delivers the remaining data before closing the connection
+	if _, err := conn.Write([]byte("last")); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Write([]byte("closed")); err == nil {
+		t.Fatal("expected an error writing to a closed link")
+	}
+	rest, err := ioutil.ReadAll(other)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(rest) != "last" {
+		t.Fatalf("expected to receive %q before closing, got %q", "last", rest)
+	}
+	if n := atomic.LoadInt64(&buffered); n != 0 {
+		t.Fatalf("expected no bytes to be buffered, got %d", n)
+	}
+}
+
+func TestLinkBandwidth(t *testing.T) {
+	one, other := net.Pipe()
+	conn := newLinkConn(one, &LinkConfig{Bandwidth: 1000}, new(int64))
+	defer conn.Close()
+	go io.Copy(ioutil.Discard, other)
+
+	// writing 200 bytes at 1000 bytes per second should take 200ms
+	start := time.Now()
+	for i := 0; i < 2; i++ {
+		if _, err := conn.Write(make([]byte, 100)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond || elapsed > time.Second {
+		t.Fatalf("expected writes to take about 200ms, took %s", elapsed)
+	}
+}
+
+func TestLinkDisabled(t *testing.T) {
+	one, _ := net.Pipe()
+	if conn := newLinkConn(one, nil, nil); conn != one {
+		t.Fatal("expected a nil link to return the connection")
+	}
+	if conn := newLinkConn(one, &LinkConfig{}, nil); conn != one {
+		t.Fatal("expected an empty link to return the connection")
+	}
+}
+
+func TestLinkCloseBlockedWrite(t *testing.T) {
+	one, other := net.Pipe()
+	conn := newLinkConn(one, &LinkConfig{Latency: time.Millisecond}, new(int64))
+	defer other.Close()
+
+	// nothing reads from the other end, so writes eventually block once
+	// the queue is full
+	errc := make(chan error, 1)
+	go func() {
+		for {
+			if _, err := conn.Write([]byte{0}); err != nil {
+				errc <- err
+				return
+			}
+		}
+	}()
+	time.Sleep(100 * time.Millisecond)
+	select {
+	case err := <-errc:
+		t.Fatalf("expected writes to block, got %v", err)
+	default:
+	}
+
+	// closing shouldn't wait for the blocked write, which should fail
+	closed := make(chan error, 1)
+	go func() { closed <- conn.Close() }()
+	select {
+	case err := <-closed:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for the link to close")
+	}
+	select {
+	case err := <-errc:
+		if err != errLinkClosed {
+			t.Fatalf("expected %q, got %v", errLinkClosed, err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for the blocked write to return")
+	}
+
+	// the undelivered data is dropped once the close times out
+	other.Close()
+	<-conn.(*linkConn).done
+}
+
+func TestLinkLoss(t *testing.T) {
+	one, other := net.Pipe()
+	link := &LinkConfig{Latency: 10 * time.Millisecond, Loss: 1}
+	conn := newLinkConn(one, link, new(int64))
+	defer conn.Close()
+	defer other.Close()
+
+	// lost data is resent, so it arrives intact but late
+	start := time.Now()
+	sent := []byte("lost and resent")
+	if _, err := conn.Write(sent); err != nil {
+		t.Fatal(err)
+	}
+	received := make([]byte, len(sent))
+	if _, err := io.ReadFull(other, received); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed, delay := time.Since(start), link.Latency+link.lossDelay(); elapsed < delay {
+		t.Fatalf("expected data to be delayed by at least %s, took %s", delay, elapsed)
+	}
+	if !bytes.Equal(received, sent) {
+		t.Fatalf("expected to receive %q, got %q", sent, received)
+	}
+}
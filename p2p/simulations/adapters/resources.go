@@ -0,0 +1,126 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package adapters
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ethereum/go-ethereum/p2p"
+)
+
+// NodeResources is a snapshot of the resources a simulation node is using
+type NodeResources struct {
+	// Goroutines is the number of protocol handler goroutines the node
+	// is running, one per protocol per connected peer
+	Goroutines int64 `json:"goroutines"`
+
+	// Conns is the number of connections the node has open
+	Conns int `json:"conns"`
+
+	// BufferedBytes is the number of bytes the node has written which
+	// are waiting to be delivered over its link (see LinkConfig)
+	BufferedBytes int64 `json:"buffered_bytes"`
+
+	// HandlerTime is the total time the node's protocol handlers have
+	// spent handling the messages they read
+	HandlerTime time.Duration `json:"handler_time"`
+
+	// Messages is the number of messages the node's protocol handlers
+	// have read
+	Messages int64 `json:"messages"`
+}
+
+// ResourceReporter is implemented by nodes which account for the resources
+// they use
+type ResourceReporter interface {
+	// Resources returns the resources the node is currently using
+	Resources() *NodeResources
+}
+
+// resourceCounters are updated atomically as a node uses resources
+type resourceCounters struct {
+	buffered    int64
+	handlerTime int64
+	messages    int64
+
+	// handlers counts the protocol handlers run with each peer, which
+	// all return before the peer is removed from the p2p.Server
+	mtx      sync.Mutex
+	handlers map[*p2p.Peer]int64
+}
+
+// wrap wraps the MsgReadWriter a protocol handler is run with so that the
+// handler is accounted for (see p2p.Config.WrapProtocolRW)
+func (c *resourceCounters) wrap(peer *p2p.Peer, rw p2p.MsgReadWriter) p2p.MsgReadWriter {
+	c.mtx.Lock()
+	if c.handlers == nil {
+		c.handlers = make(map[*p2p.Peer]int64)
+	}
+	c.handlers[peer]++
+	c.mtx.Unlock()
+	return &accountingRW{MsgReadWriter: rw, counters: c}
+}
+
+// goroutines returns the number of protocol handlers running with the
+// server's peers, forgetting the handlers of peers which have been removed
+func (c *resourceCounters) goroutines(server *p2p.Server) int64 {
+	// get the peers with the lock held so that the handlers of a peer
+	// added after the call are not forgotten
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	connected := make(map[*p2p.Peer]bool)
+	if server != nil {
+		for _, peer := range server.Peers() {
+			connected[peer] = true
+		}
+	}
+	var n int64
+	for peer, handlers := range c.handlers {
+		if !connected[peer] {
+			delete(c.handlers, peer)
+			continue
+		}
+		n += handlers
+	}
+	return n
+}
+
+// accountingRW is a p2p.MsgReadWriter which times how long a protocol
+// handler spends handling each message it reads, that being the time until
+// it reads the next message
+type accountingRW struct {
+	p2p.MsgReadWriter
+	counters *resourceCounters
+	read     time.Time // when the last message was read
+}
+
+// ReadMsg reads a message from the underlying MsgReadWriter, accounting for
+// the time spent handling the previous message
+func (rw *accountingRW) ReadMsg() (p2p.Msg, error) {
+	if !rw.read.IsZero() {
+		atomic.AddInt64(&rw.counters.handlerTime, int64(time.Since(rw.read)))
+		rw.read = time.Time{}
+	}
+	msg, err := rw.MsgReadWriter.ReadMsg()
+	if err == nil {
+		atomic.AddInt64(&rw.counters.messages, 1)
+		rw.read = time.Now()
+	}
+	return msg, err
+}
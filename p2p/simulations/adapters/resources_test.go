@@ -0,0 +1,143 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package adapters
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/node"
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/p2p/discover"
+)
+
+func TestAccountingRW(t *testing.T) {
+	counters := &resourceCounters{}
+	one, other := p2p.MsgPipe()
+	defer other.Close()
+	rw := counters.wrap(p2p.NewPeer(discover.NodeID{}, "test", nil), one)
+
+	// read two messages, spending 20ms handling the first
+	go func() {
+		for i := 0; i < 2; i++ {
+			p2p.Send(other, 0, struct{}{})
+		}
+	}()
+	for i := 0; i < 2; i++ {
+		msg, err := rw.ReadMsg()
+		if err != nil {
+			t.Fatal(err)
+		}
+		msg.Discard()
+		if i == 0 {
+			time.Sleep(20 * time.Millisecond)
+		}
+	}
+	other.Close()
+	if _, err := rw.ReadMsg(); err == nil {
+		t.Fatal("expected an error reading from a closed pipe")
+	}
+	if n := atomic.LoadInt64(&counters.messages); n != 2 {
+		t.Fatalf("expected 2 messages to be read, got %d", n)
+	}
+	if d := time.Duration(atomic.LoadInt64(&counters.handlerTime)); d < 20*time.Millisecond {
+		t.Fatalf("expected at least 20ms of handler time, got %s", d)
+	}
+
+	// the handler isn't counted once its peer isn't connected
+	if n := counters.goroutines(nil); n != 0 {
+		t.Fatalf("expected no handler goroutines, got %d", n)
+	}
+}
+
+// TestSimNodeResources checks that the protocol handlers of every service a
+// SimNode runs are accounted for without changing the services' types
+func TestSimNodeResources(t *testing.T) {
+	received := make(chan string, 10)
+	adapter := NewSimAdapter(Services{
+		"one": func(ctx *ServiceContext) (node.Service, error) {
+			return &faultTestService{name: "one", received: received}, nil
+		},
+		"two": func(ctx *ServiceContext) (node.Service, error) {
+			return &otherFaultTestService{faultTestService{name: "two", received: received}}, nil
+		},
+	})
+	newNode := func() *SimNode {
+		config := RandomNodeConfig()
+		config.Services = []string{"one", "two"}
+		n, err := adapter.NewNode(config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := n.Start(nil); err != nil {
+			t.Fatal(err)
+		}
+		return n.(*SimNode)
+	}
+	one := newNode()
+	defer one.Stop()
+	two := newNode()
+	defer two.Stop()
+
+	var service *faultTestService
+	if err := one.node.Service(&service); err != nil {
+		t.Fatalf("error looking up the node's service: %s", err)
+	}
+
+	client, err := one.Client()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Call(nil, "admin_addPeer", two.Node().String()); err != nil {
+		t.Fatal(err)
+	}
+
+	// wait for both services of each node to receive the other's message
+	timeout := time.After(5 * time.Second)
+	for i := 0; i < 4; i++ {
+		select {
+		case <-received:
+		case <-timeout:
+			t.Fatal("timed out waiting for messages")
+		}
+	}
+	waitResources := func(check func(*NodeResources) bool) *NodeResources {
+		for {
+			res := one.Resources()
+			if check(res) {
+				return res
+			}
+			select {
+			case <-time.After(10 * time.Millisecond):
+			case <-timeout:
+				t.Fatalf("timed out waiting for resources, got %+v", res)
+			}
+		}
+	}
+	waitResources(func(res *NodeResources) bool {
+		return res.Goroutines == 2 && res.Conns == 1 && res.Messages == 2
+	})
+
+	// the handlers return once the peer stops
+	if err := two.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	waitResources(func(res *NodeResources) bool {
+		return res.Goroutines == 0
+	})
+}
@@ -21,6 +21,7 @@ import (
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net"
 	"os"
 
@@ -63,6 +64,13 @@ type Node interface {
 	Snapshots() (map[string][]byte, error)
 }
 
+// Crasher is implemented by nodes which can be stopped abruptly, without
+// gracefully disconnecting from their peers
+type Crasher interface {
+	// Crash stops the node without notifying its peers
+	Crash() error
+}
+
 // NodeAdapter is used to create Nodes in a simulation network
 type NodeAdapter interface {
 	// Name returns the name of the adapter for logging purposes
@@ -97,24 +105,62 @@ type NodeConfig struct {
 
 	// function to sanction or prevent suggesting a peer
 	Reachable func(id discover.NodeID) bool
+
+	// Malicious marks the node as adversarial so that simulations can
+	// measure how far an adversary reaches into the honest network
+	Malicious bool
+
+	// Link models the network link the node sends data over (only
+	// supported by SimNodes)
+	Link *LinkConfig
+
+	// NAT marks the node as being behind a NAT, so that it can dial out
+	// but can't accept inbound connections (only supported by SimNodes)
+	NAT bool
+
+	// Faults configures the faults injected into the protocol messages
+	// the node sends (only supported by SimNodes)
+	Faults *FaultConfig
+
+	// Zone labels the geographic zone or region the node is in, which
+	// determines the latency added to its connections (only supported by
+	// SimNodes, see SimAdapter.SetZoneLatencies)
+	Zone string
+
+	// Version is the version of the node's services, which services can
+	// read from their ServiceContext to run a particular protocol version
+	// (see Network.Upgrade)
+	Version string
 }
 
 // nodeConfigJSON is used to encode and decode NodeConfig as JSON by encoding
 // all fields as strings
 type nodeConfigJSON struct {
-	ID         string   `json:"id"`
-	PrivateKey string   `json:"private_key"`
-	Name       string   `json:"name"`
-	Services   []string `json:"services"`
+	ID         string       `json:"id"`
+	PrivateKey string       `json:"private_key"`
+	Name       string       `json:"name"`
+	Services   []string     `json:"services"`
+	Malicious  bool         `json:"malicious,omitempty"`
+	Link       *LinkConfig  `json:"link,omitempty"`
+	NAT        bool         `json:"nat,omitempty"`
+	Faults     *FaultConfig `json:"faults,omitempty"`
+	Zone       string       `json:"zone,omitempty"`
+	Version    string       `json:"version,omitempty"`
 }
 
 // MarshalJSON implements the json.Marshaler interface by encoding the config
 // fields as strings
 func (n *NodeConfig) MarshalJSON() ([]byte, error) {
 	confJSON := nodeConfigJSON{
-		ID:       n.ID.String(),
-		Name:     n.Name,
-		Services: n.Services,
+		ID:        n.ID.String(),
+		Name:      n.Name,
+		Services:  n.Services,
+		Malicious: n.Malicious,
+		Link:      n.Link,
+		NAT:       n.NAT,
+		Faults:    n.Faults,
+		Zone:      n.Zone,
+		Version:   n.Version,
 	}
 	if n.PrivateKey != nil {
 		confJSON.PrivateKey = hex.EncodeToString(crypto.FromECDSA(n.PrivateKey))
@@ -152,17 +198,80 @@ func (n *NodeConfig) UnmarshalJSON(data []byte) error {
 
 	n.Name = confJSON.Name
 	n.Services = confJSON.Services
+	n.Malicious = confJSON.Malicious
+	n.Link = confJSON.Link
+	n.NAT = confJSON.NAT
+	n.Faults = confJSON.Faults
+	n.Zone = confJSON.Zone
+	n.Version = confJSON.Version
 
 	return nil
 }
 
 // RandomNodeConfig returns node configuration with a randomly generated ID and
-// PrivateKey
+// PrivateKey, panicking if the key cannot be generated
 func RandomNodeConfig() *NodeConfig {
-	key, err := crypto.GenerateKey()
+	conf, err := GenerateNodeConfig()
 	if err != nil {
 		panic("unable to generate key")
 	}
+	return conf
+}
+
+// GenerateNodeConfig returns node configuration with a randomly generated ID
+// and PrivateKey, or an error if the key cannot be generated
+func GenerateNodeConfig() (*NodeConfig, error) {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		return nil, err
+	}
+	return nodeConfigWithKey(key), nil
+}
+
+// SequentialNodeConfigs returns configuration for n nodes whose private keys
+// are derived from their index, so that the same n always returns the same
+// IDs in the same order
+func SequentialNodeConfigs(n int) []*NodeConfig {
+	confs := make([]*NodeConfig, n)
+	for i := range confs {
+		key, err := crypto.ToECDSA(crypto.Keccak256([]byte(fmt.Sprintf("node%d", i))))
+		if err != nil {
+			panic("unable to generate key")
+		}
+		confs[i] = nodeConfigWithKey(key)
+	}
+	return confs
+}
+
+// SeededNodeConfigs returns configuration for n nodes whose private keys are
+// read from r, so that a deterministic r (e.g. a seeded math/rand source)
+// always returns the same IDs in the same order
+func SeededNodeConfigs(n int, r io.Reader) ([]*NodeConfig, error) {
+	confs := make([]*NodeConfig, n)
+	for i := range confs {
+		key, err := readKey(r)
+		if err != nil {
+			return nil, err
+		}
+		confs[i] = nodeConfigWithKey(key)
+	}
+	return confs, nil
+}
+
+// readKey reads private keys from r until it finds one which is valid
+func readKey(r io.Reader) (*ecdsa.PrivateKey, error) {
+	buf := make([]byte, 32)
+	for {
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		if key, err := crypto.ToECDSA(buf); err == nil {
+			return key, nil
+		}
+	}
+}
+
+func nodeConfigWithKey(key *ecdsa.PrivateKey) *NodeConfig {
 	var id discover.NodeID
 	pubkey := crypto.FromECDSAPub(&key.PublicKey)
 	copy(id[:], pubkey[1:])
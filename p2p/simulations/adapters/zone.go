@@ -0,0 +1,76 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package adapters
+
+import "time"
+
+// ZoneLatencies is a matrix of the latency added to data sent between nodes
+// in different zones (see NodeConfig.Zone), keyed by the sending node's zone
+// then the receiving node's zone
+type ZoneLatencies map[string]map[string]time.Duration
+
+// Latency returns the latency between the two zones, falling back to the
+// latency in the other direction if only that is set
+func (z ZoneLatencies) Latency(from, to string) time.Duration {
+	if latency, ok := z[from][to]; ok {
+		return latency
+	}
+	return z[to][from]
+}
+
+// ZoneLatencyReporter is implemented by node adapters which add latency to
+// the connections between nodes in different zones
+type ZoneLatencyReporter interface {
+	// ZoneLatency returns the latency added to data sent from a node in
+	// one zone to a node in the other
+	ZoneLatency(from, to string) time.Duration
+}
+
+// SetZoneLatencies sets the latencies the SimAdapter adds to the links of
+// connections dialed between nodes in different zones
+func (s *SimAdapter) SetZoneLatencies(zones ZoneLatencies) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.zones = zones
+}
+
+// ZoneLatency returns the latency the SimAdapter adds to data sent from a
+// node in one zone to a node in the other
+func (s *SimAdapter) ZoneLatency(from, to string) time.Duration {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+	return s.zones.Latency(from, to)
+}
+
+// linkBetween returns the link a node sends data to a peer over, being the
+// node's own link with the latency between their zones added (peer being
+// nil if unknown)
+func (s *SimAdapter) linkBetween(node, peer *SimNode) *LinkConfig {
+	if peer == nil {
+		return node.config.Link
+	}
+	latency := s.ZoneLatency(node.config.Zone, peer.config.Zone)
+	if latency == 0 {
+		return node.config.Link
+	}
+	var link LinkConfig
+	if node.config.Link != nil {
+		link = *node.config.Link
+	}
+	link.Latency += latency
+	return &link
+}
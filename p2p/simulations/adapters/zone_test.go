@@ -0,0 +1,61 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package adapters
+
+import (
+	"testing"
+	"time"
+)
+
+func TestZoneLatencies(t *testing.T) {
+	zones := ZoneLatencies{
+		"eu": {"us": 40 * time.Millisecond, "asia": 80 * time.Millisecond},
+		"us": {"eu": 50 * time.Millisecond},
+	}
+	for _, test := range []struct {
+		from, to string
+		latency  time.Duration
+	}{
+		{"eu", "us", 40 * time.Millisecond},
+		{"us", "eu", 50 * time.Millisecond},
+		{"asia", "eu", 80 * time.Millisecond},
+		{"eu", "eu", 0},
+		{"us", "asia", 0},
+	} {
+		if latency := zones.Latency(test.from, test.to); latency != test.latency {
+			t.Fatalf("expected latency from %s to %s to be %s, got %s", test.from, test.to, test.latency, latency)
+		}
+	}
+
+	// the zone latency is added to the sending node's link
+	adapter := NewSimAdapter(Services{})
+	adapter.SetZoneLatencies(zones)
+	eu := &SimNode{config: &NodeConfig{Zone: "eu", Link: &LinkConfig{Latency: 10 * time.Millisecond, Bandwidth: 1000}}}
+	us := &SimNode{config: &NodeConfig{Zone: "us"}}
+	if link := adapter.linkBetween(eu, us); link.Latency != 50*time.Millisecond || link.Bandwidth != 1000 {
+		t.Fatalf("unexpected link from eu to us: %+v", link)
+	}
+	if link := adapter.linkBetween(us, eu); link.Latency != 50*time.Millisecond {
+		t.Fatalf("unexpected link from us to eu: %+v", link)
+	}
+	if link := adapter.linkBetween(eu, nil); link != eu.config.Link {
+		t.Fatalf("expected the node's own link for an unknown peer, got %+v", link)
+	}
+	if eu.config.Link.Latency != 10*time.Millisecond {
+		t.Fatal("expected the node's own link to be unchanged")
+	}
+}
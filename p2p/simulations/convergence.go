@@ -0,0 +1,212 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package simulations
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/log"
+)
+
+// ConvergenceConfig configures when a ConvergenceMonitor considers a network
+// to have converged
+type ConvergenceConfig struct {
+	// NodesTarget is the number of nodes which should be up (0 means the
+	// number of nodes isn't checked)
+	NodesTarget int `json:"nodes_target,omitempty"`
+
+	// DegreeTarget is the mean number of peers the nodes which are up
+	// should have (0 means the degree isn't checked)
+	DegreeTarget float64 `json:"degree_target,omitempty"`
+
+	// Tolerance is how far from the targets the network may be, as a
+	// fraction of each target
+	Tolerance float64 `json:"tolerance,omitempty"`
+
+	// Ticks is the number of consecutive checks which must be within
+	// tolerance for the network to have converged (at least one)
+	Ticks int `json:"ticks,omitempty"`
+
+	// Interval is the time between checks (defaults to a second, and
+	// can't be less than MinConvergenceInterval). It is encoded as a
+	// duration string such as "500ms".
+	Interval time.Duration `json:"interval,omitempty"`
+
+	// OnConverged, if set, is called with the network's statistics once
+	// it has converged
+	OnConverged func(*Stats) `json:"-"`
+}
+
+// MinConvergenceInterval is the shortest time allowed between convergence
+// checks, which stops a tiny interval from turning the monitor into a busy
+// loop
+const MinConvergenceInterval = 10 * time.Millisecond
+
+// Validate checks the interval between checks isn't too short
+func (c *ConvergenceConfig) Validate() error {
+	if c.Interval != 0 && c.Interval < MinConvergenceInterval {
+		return fmt.Errorf("convergence interval must be at least %v, got %v", MinConvergenceInterval, c.Interval)
+	}
+	return nil
+}
+
+// convergenceConfigJSON is the JSON encoding of a ConvergenceConfig, with
+// the interval as a duration string
+type convergenceConfigJSON struct {
+	*convergenceConfig
+	Interval string `json:"interval,omitempty"`
+}
+
+// convergenceConfig has the fields of a ConvergenceConfig without its
+// JSON methods
+type convergenceConfig ConvergenceConfig
+
+// MarshalJSON implements the json.Marshaler interface so that the interval
+// is encoded as a duration string
+func (c *ConvergenceConfig) MarshalJSON() ([]byte, error) {
+	enc := convergenceConfigJSON{convergenceConfig: (*convergenceConfig)(c)}
+	if c.Interval != 0 {
+		enc.Interval = c.Interval.String()
+	}
+	return json.Marshal(enc)
+}
+
+// UnmarshalJSON implements the json.Unmarshaler interface, parsing the
+// interval as a duration string and checking it isn't too short
+func (c *ConvergenceConfig) UnmarshalJSON(data []byte) error {
+	dec := convergenceConfigJSON{convergenceConfig: (*convergenceConfig)(c)}
+	if err := json.Unmarshal(data, &dec); err != nil {
+		return err
+	}
+	if dec.Interval != "" {
+		interval, err := time.ParseDuration(dec.Interval)
+		if err != nil {
+			return fmt.Errorf("invalid convergence interval %q: %v", dec.Interval, err)
+		}
+		c.Interval = interval
+	}
+	return c.Validate()
+}
+
+// ConvergenceMonitor periodically checks whether a network has converged on
+// a number of nodes and mean degree, so that experiments can run until the
+// network has settled rather than for a guessed length of time
+type ConvergenceMonitor struct {
+	network *Network
+	config  *ConvergenceConfig
+
+	stats     *Stats
+	converged chan struct{}
+
+	quit     chan struct{}
+	quitOnce sync.Once
+	done     chan struct{}
+}
+
+// NewConvergenceMonitor starts monitoring the network for convergence. It
+// must be closed if the network may never converge.
+func NewConvergenceMonitor(network *Network, config *ConvergenceConfig) *ConvergenceMonitor {
+	m := &ConvergenceMonitor{
+		network:   network,
+		config:    config,
+		converged: make(chan struct{}),
+		quit:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	go m.loop()
+	return m
+}
+
+func (m *ConvergenceMonitor) loop() {
+	defer close(m.done)
+	interval := m.config.Interval
+	if interval <= 0 {
+		interval = time.Second
+	} else if interval < MinConvergenceInterval {
+		interval = MinConvergenceInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	ticks := 0
+	for {
+		select {
+		case <-ticker.C:
+		case <-m.quit:
+			return
+		}
+		stats := m.check()
+		if stats == nil {
+			ticks = 0
+			continue
+		}
+		if ticks++; ticks < m.config.Ticks {
+			continue
+		}
+		log.Info("network converged", "nodes", stats.UpNodes, "degree", stats.MeanDegree)
+		m.stats = stats
+		close(m.converged)
+		m.network.events.Send(NewEvent(stats))
+		if m.config.OnConverged != nil {
+			m.config.OnConverged(stats)
+		}
+		return
+	}
+}
+
+// check returns the network's statistics if they are within tolerance of
+// the targets, nil otherwise
+func (m *ConvergenceMonitor) check() *Stats {
+	stats := networkStats(m.network)
+	if !m.within(float64(stats.UpNodes), float64(m.config.NodesTarget)) ||
+		!m.within(stats.MeanDegree, m.config.DegreeTarget) {
+		return nil
+	}
+	return stats
+}
+
+// within returns whether the value is within tolerance of the target, which
+// it always is if the target is 0
+func (m *ConvergenceMonitor) within(value, target float64) bool {
+	return target == 0 || math.Abs(value-target) <= m.config.Tolerance*target
+}
+
+// Converged returns a channel which is closed once the network has converged
+func (m *ConvergenceMonitor) Converged() <-chan struct{} {
+	return m.converged
+}
+
+// Stats returns the network's statistics when it converged, or nil if it
+// hasn't converged yet
+func (m *ConvergenceMonitor) Stats() *Stats {
+	select {
+	case <-m.converged:
+		return m.stats
+	default:
+		return nil
+	}
+}
+
+// Close stops monitoring the network, and is safe to call more than once
+// and from several goroutines
+func (m *ConvergenceMonitor) Close() {
+	m.quitOnce.Do(func() { close(m.quit) })
+	<-m.done
+}
@@ -0,0 +1,172 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package simulations
+
+import (
+	"encoding/json"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/node"
+	"github.com/ethereum/go-ethereum/p2p/discover"
+	"github.com/ethereum/go-ethereum/p2p/simulations/adapters"
+)
+
+// TestConvergenceMonitor checks that a network only converges once it has
+// a ring of four nodes up
+func TestConvergenceMonitor(t *testing.T) {
+	adapter := adapters.NewSimAdapter(adapters.Services{
+		"noop": func(ctx *adapters.ServiceContext) (node.Service, error) {
+			return noopService{}, nil
+		},
+	})
+	network := NewNetwork(adapter, &NetworkConfig{
+		DefaultService: "noop",
+	})
+	defer network.Shutdown()
+
+	// the monitor should also report convergence as a network event
+	events := make(chan *Event)
+	sub := network.Events().Subscribe(events)
+	defer sub.Unsubscribe()
+	convergedEvents := make(chan *Event, 1)
+	go func() {
+		for {
+			select {
+			case event := <-events:
+				if event.Type == EventTypeConverged {
+					convergedEvents <- event
+				}
+			case <-sub.Err():
+				return
+			}
+		}
+	}()
+
+	called := make(chan *Stats, 1)
+	monitor := NewConvergenceMonitor(network, &ConvergenceConfig{
+		NodesTarget:  4,
+		DegreeTarget: 2,
+		Ticks:        3,
+		Interval:     10 * time.Millisecond,
+		OnConverged:  func(stats *Stats) { called <- stats },
+	})
+	defer monitor.Close()
+
+	ids := make([]discover.NodeID, 4)
+	for i := range ids {
+		node, err := network.NewNode()
+		if err != nil {
+			t.Fatalf("error creating node: %s", err)
+		}
+		if err := network.Start(node.ID()); err != nil {
+			t.Fatalf("error starting node: %s", err)
+		}
+		ids[i] = node.ID()
+	}
+
+	// the nodes are up but not connected so the network hasn't converged
+	time.Sleep(100 * time.Millisecond)
+	if stats := monitor.Stats(); stats != nil {
+		t.Fatalf("expected the network not to have converged, got %+v", stats)
+	}
+
+	for i := range ids {
+		if err := network.Connect(ids[i], ids[(i+1)%len(ids)]); err != nil {
+			t.Fatalf("error connecting nodes: %s", err)
+		}
+	}
+	select {
+	case <-monitor.Converged():
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for the network to converge")
+	}
+	stats := monitor.Stats()
+	if stats.UpNodes != 4 || stats.Conns != 4 || stats.MeanDegree != 2 {
+		t.Fatalf("unexpected stats when converged: %+v", stats)
+	}
+	if s := <-called; s != stats {
+		t.Fatalf("expected the callback to be called with %+v, got %+v", stats, s)
+	}
+	select {
+	case event := <-convergedEvents:
+		if !reflect.DeepEqual(event.Stats, stats) {
+			t.Fatalf("expected the converged event to have stats %+v, got %+v", stats, event.Stats)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for the converged event")
+	}
+}
+
+// TestConvergenceConfigJSON checks that the interval is encoded as a
+// duration string and that intervals which are too short are rejected
+func TestConvergenceConfigJSON(t *testing.T) {
+	config := &ConvergenceConfig{NodesTarget: 4, Interval: 1500 * time.Millisecond}
+	data, err := json.Marshal(config)
+	if err != nil {
+		t.Fatalf("error encoding config: %s", err)
+	}
+	if expected := `{"nodes_target":4,"interval":"1.5s"}`; string(data) != expected {
+		t.Fatalf("expected config to be encoded as %s, got %s", expected, data)
+	}
+	decoded := &ConvergenceConfig{}
+	if err := json.Unmarshal(data, decoded); err != nil {
+		t.Fatalf("error decoding config: %s", err)
+	}
+	if decoded.NodesTarget != 4 || decoded.Interval != 1500*time.Millisecond {
+		t.Fatalf("unexpected decoded config: %+v", decoded)
+	}
+
+	for _, invalid := range []string{
+		`{"interval":1}`,
+		`{"interval":"1"}`,
+		`{"interval":"1ns"}`,
+	} {
+		if err := json.Unmarshal([]byte(invalid), &ConvergenceConfig{}); err == nil {
+			t.Fatalf("expected decoding %s to fail", invalid)
+		}
+	}
+	mocker := &MockerConfig{
+		Type:      "boot",
+		NodeCount: 2,
+		Converge:  &ConvergenceConfig{Interval: time.Nanosecond},
+	}
+	if err := mocker.Validate(); err == nil {
+		t.Fatal("expected a mocker config with a 1ns convergence interval to be invalid")
+	}
+}
+
+// TestConvergenceMonitorClose checks that a monitor can be closed
+// concurrently
+func TestConvergenceMonitorClose(t *testing.T) {
+	adapter := adapters.NewSimAdapter(adapters.Services{})
+	network := NewNetwork(adapter, &NetworkConfig{})
+	defer network.Shutdown()
+
+	monitor := NewConvergenceMonitor(network, &ConvergenceConfig{NodesTarget: 1})
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			monitor.Close()
+		}()
+	}
+	wg.Wait()
+}
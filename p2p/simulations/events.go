@@ -18,6 +18,7 @@ package simulations
 
 import (
 	"fmt"
+	"sync/atomic"
 	"time"
 )
 
@@ -36,10 +37,23 @@ const (
 	// EventTypeMsg is the type of event emitted when a p2p message it
 	// sent between two nodes
 	EventTypeMsg EventType = "msg"
+
+	// EventTypeUpgrade is the type of event emitted when a node's version
+	// is changed (see Network.Upgrade)
+	EventTypeUpgrade EventType = "upgrade"
+
+	// EventTypeConverged is the type of event emitted when a
+	// ConvergenceMonitor finds the network has converged
+	EventTypeConverged EventType = "converged"
 )
 
 // Event is an event emitted by a simulation network
 type Event struct {
+	// ID is a sequence number which increases with every event created, so
+	// that consumers receiving events from several goroutines can order
+	// and deduplicate them
+	ID uint64 `json:"id"`
+
 	// Type is the type of the event
 	Type EventType `json:"type"`
 
@@ -50,7 +64,7 @@ type Event struct {
 	// action in the network
 	Control bool `json:"control"`
 
-	// Node is set if the type is EventTypeNode
+	// Node is set if the type is EventTypeNode or EventTypeUpgrade
 	Node *Node `json:"node,omitempty"`
 
 	// Conn is set if the type is EventTypeConn
@@ -58,15 +72,19 @@ type Event struct {
 
 	// Msg is set if the type is EventTypeMsg
 	Msg *Msg `json:"msg,omitempty"`
+
+	// Stats is set if the type is EventTypeConverged, giving the network's
+	// statistics when it converged
+	Stats *Stats `json:"stats,omitempty"`
 }
 
 // NewEvent creates a new event for the given object which should be either a
-// Node, Conn or Msg.
+// Node, Conn or Msg, or the Stats of a network which has converged.
 //
 // The object is copied so that the event represents the state of the object
 // when NewEvent is called.
 func NewEvent(v interface{}) *Event {
-	event := &Event{Time: time.Now()}
+	event := &Event{ID: nextEventID(), Time: time.Now()}
 	switch v := v.(type) {
 	case *Node:
 		event.Type = EventTypeNode
@@ -80,12 +98,24 @@ func NewEvent(v interface{}) *Event {
 		event.Type = EventTypeMsg
 		msg := *v
 		event.Msg = &msg
+	case *Stats:
+		event.Type = EventTypeConverged
+		stats := *v
+		event.Stats = &stats
 	default:
 		panic(fmt.Sprintf("invalid event type: %T", v))
 	}
 	return event
 }
 
+// lastEventID is the ID of the last event created
+var lastEventID uint64
+
+// nextEventID returns a new event ID, which is safe to call concurrently
+func nextEventID() uint64 {
+	return atomic.AddUint64(&lastEventID, 1)
+}
+
 // ControlEvent creates a new control event
 func ControlEvent(v interface{}) *Event {
 	event := NewEvent(v)
@@ -100,8 +130,12 @@ func (e *Event) String() string {
 		return fmt.Sprintf("<node-event> id: %s up: %t", e.Node.ID().TerminalString(), e.Node.Up)
 	case EventTypeConn:
 		return fmt.Sprintf("<conn-event> nodes: %s->%s up: %t", e.Conn.One.TerminalString(), e.Conn.Other.TerminalString(), e.Conn.Up)
+	case EventTypeUpgrade:
+		return fmt.Sprintf("<upgrade-event> id: %s version: %s", e.Node.ID().TerminalString(), e.Node.Config.Version)
 	case EventTypeMsg:
 		return fmt.Sprintf("<msg-event> nodes: %s->%s proto: %s, code: %d, received: %t", e.Msg.One.TerminalString(), e.Msg.Other.TerminalString(), e.Msg.Protocol, e.Msg.Code, e.Msg.Received)
+	case EventTypeConverged:
+		return fmt.Sprintf("<converged-event> nodes: %d degree: %f", e.Stats.UpNodes, e.Stats.MeanDegree)
 	default:
 		return ""
 	}
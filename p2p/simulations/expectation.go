@@ -0,0 +1,177 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package simulations
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// NetworkExpectation is a set of conditions on the nodes which are up and
+// the connections between them which a network is expected to reach, for
+// example:
+//
+//	err := NewNetworkExpectation().NodesUp(50).FullMesh().Within(net, 30*time.Second)
+//
+// Unlike an Expectation, which checks nodes individually as a Step is
+// triggered, a NetworkExpectation is checked against the whole network
+// each time a network event occurs.
+type NetworkExpectation struct {
+	conditions []networkCondition
+}
+
+// networkCondition checks the network's up nodes and active connections,
+// returning an error describing how the condition isn't met
+type networkCondition func(nodes []*Node, conns []*Conn) error
+
+// NewNetworkExpectation returns an expectation without any conditions
+func NewNetworkExpectation() *NetworkExpectation {
+	return &NetworkExpectation{}
+}
+
+// NodesUp expects exactly n nodes to be up
+func (e *NetworkExpectation) NodesUp(n int) *NetworkExpectation {
+	return e.add(func(nodes []*Node, conns []*Conn) error {
+		if len(nodes) != n {
+			return fmt.Errorf("expected %d nodes up, got %d", n, len(nodes))
+		}
+		return nil
+	})
+}
+
+// ConnsUp expects exactly n connections to be up
+func (e *NetworkExpectation) ConnsUp(n int) *NetworkExpectation {
+	return e.add(func(nodes []*Node, conns []*Conn) error {
+		if len(conns) != n {
+			return fmt.Errorf("expected %d connections up, got %d", n, len(conns))
+		}
+		return nil
+	})
+}
+
+// FullMesh expects every node which is up to be connected to every other
+// node which is up
+func (e *NetworkExpectation) FullMesh() *NetworkExpectation {
+	return e.add(func(nodes []*Node, conns []*Conn) error {
+		g := newGraph(nodes, conns)
+		var missing []string
+		for i, one := range nodes {
+			for _, other := range nodes[i+1:] {
+				if _, ok := g[one.ID()][other.ID()]; !ok {
+					missing = append(missing, fmt.Sprintf("%s-%s", one.ID().TerminalString(), other.ID().TerminalString()))
+				}
+			}
+		}
+		if len(missing) > 0 {
+			return fmt.Errorf("expected a full mesh, missing %d connections: %s", len(missing), truncateList(missing))
+		}
+		return nil
+	})
+}
+
+// Connected expects every node which is up to be reachable from every other
+// node which is up
+func (e *NetworkExpectation) Connected() *NetworkExpectation {
+	return e.add(func(nodes []*Node, conns []*Conn) error {
+		if components := Components(nodes, conns); len(components) > 1 {
+			return fmt.Errorf("expected a connected network, got %d components", len(components))
+		}
+		return nil
+	})
+}
+
+func (e *NetworkExpectation) add(condition networkCondition) *NetworkExpectation {
+	e.conditions = append(e.conditions, condition)
+	return e
+}
+
+// Check returns an error listing the conditions which the network doesn't
+// currently meet
+func (e *NetworkExpectation) Check(network *Network) error {
+	nodes, conns := network.upNodesAndConns()
+	var failed []string
+	for _, condition := range e.conditions {
+		if err := condition(nodes, conns); err != nil {
+			failed = append(failed, err.Error())
+		}
+	}
+	if len(failed) > 0 {
+		return errors.New(strings.Join(failed, "; "))
+	}
+	return nil
+}
+
+// Wait blocks until the network meets all the conditions, returning an
+// error listing the conditions which aren't met if the context is done
+// first
+func (e *NetworkExpectation) Wait(ctx context.Context, network *Network) error {
+	events := make(chan *Event)
+	sub := network.Events().Subscribe(events)
+	defer sub.Unsubscribe()
+	for {
+		err := e.Check(network)
+		if err == nil {
+			return nil
+		}
+		select {
+		case <-events:
+		case <-ctx.Done():
+			return fmt.Errorf("%v: %v", ctx.Err(), err)
+		}
+	}
+}
+
+// Within waits for the network to meet all the conditions for at most the
+// given duration (see Wait)
+func (e *NetworkExpectation) Within(network *Network, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return e.Wait(ctx, network)
+}
+
+// upNodesAndConns returns copies of the nodes which are up and the
+// connections which are up
+func (self *Network) upNodesAndConns() (nodes []*Node, conns []*Conn) {
+	self.lock.RLock()
+	defer self.lock.RUnlock()
+	for _, node := range self.Nodes {
+		if node.Up {
+			n := *node
+			nodes = append(nodes, &n)
+		}
+	}
+	for _, conn := range self.Conns {
+		if conn.Up {
+			c := *conn
+			conns = append(conns, &c)
+		}
+	}
+	return nodes, conns
+}
+
+// truncateList joins the first few items of the list, noting how many
+// were left out
+func truncateList(items []string) string {
+	const max = 5
+	if len(items) <= max {
+		return strings.Join(items, ", ")
+	}
+	return fmt.Sprintf("%s and %d more", strings.Join(items[:max], ", "), len(items)-max)
+}
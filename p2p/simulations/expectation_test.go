@@ -0,0 +1,80 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package simulations
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/node"
+	"github.com/ethereum/go-ethereum/p2p/discover"
+	"github.com/ethereum/go-ethereum/p2p/simulations/adapters"
+)
+
+// TestNetworkExpectation checks that a NetworkExpectation waits for the
+// network to meet its conditions and reports the ones which are not met
+func TestNetworkExpectation(t *testing.T) {
+	adapter := adapters.NewSimAdapter(adapters.Services{
+		"noop": func(ctx *adapters.ServiceContext) (node.Service, error) {
+			return noopService{}, nil
+		},
+	})
+	network := NewNetwork(adapter, &NetworkConfig{
+		DefaultService: "noop",
+	})
+	defer network.Shutdown()
+	ids := make([]discover.NodeID, 3)
+	for i := range ids {
+		node, err := network.NewNode()
+		if err != nil {
+			t.Fatalf("error creating node: %s", err)
+		}
+		if err := network.Start(node.ID()); err != nil {
+			t.Fatalf("error starting node: %s", err)
+		}
+		ids[i] = node.ID()
+	}
+
+	if err := NewNetworkExpectation().NodesUp(3).Within(network, time.Second); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	err := NewNetworkExpectation().NodesUp(3).FullMesh().Within(network, 100*time.Millisecond)
+	if err == nil {
+		t.Fatal("expected an error for a network without connections")
+	}
+	if !strings.Contains(err.Error(), "missing 3 connections") {
+		t.Fatalf("expected the error to describe the missing connections, got %q", err)
+	}
+	if strings.Contains(err.Error(), "nodes up") {
+		t.Fatalf("expected the error to only describe unmet conditions, got %q", err)
+	}
+
+	go func() {
+		for i, one := range ids {
+			for _, other := range ids[i+1:] {
+				if err := network.Connect(one, other); err != nil {
+					t.Errorf("error connecting nodes: %s", err)
+				}
+			}
+		}
+	}()
+	if err := NewNetworkExpectation().FullMesh().ConnsUp(3).Connected().Within(network, 10*time.Second); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+}
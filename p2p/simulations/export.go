@@ -0,0 +1,201 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package simulations
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+)
+
+// SnapshotFormats are the formats a snapshot can be written in by
+// WriteSnapshot
+var SnapshotFormats = []string{"json", "dot", "graphml", "d3"}
+
+// WriteSnapshot writes the snapshot to w in the given format, which is one
+// of:
+//
+//	json    - the JSON encoded snapshot, which can be loaded into a network
+//	dot     - the node and connection graph in Graphviz DOT
+//	graphml - the node and connection graph in GraphML
+//	d3      - the node and connection graph as d3-force nodes and links
+//
+// The graph formats include nodes and connections which are down, so that
+// tools can show them differently from those which are up.
+func WriteSnapshot(w io.Writer, snap *Snapshot, format string) error {
+	switch format {
+	case "json":
+		return json.NewEncoder(w).Encode(snap)
+	case "dot":
+		return snap.WriteDOT(w)
+	case "graphml":
+		return snap.WriteGraphML(w)
+	case "d3":
+		return snap.WriteD3(w)
+	default:
+		return fmt.Errorf("unknown snapshot format %q", format)
+	}
+}
+
+// WriteDOT writes the snapshot's graph in Graphviz DOT, drawing nodes and
+// connections which are down with dashed lines
+func (snap *Snapshot) WriteDOT(w io.Writer) error {
+	if _, err := fmt.Fprintln(w, "graph network {"); err != nil {
+		return err
+	}
+	for _, n := range snap.Nodes {
+		_, err := fmt.Fprintf(w, "\t%q [label=%q up=%t style=%s];\n", n.Node.ID().String(), n.Node.Config.Name, n.Node.Up, dotStyle(n.Node.Up))
+		if err != nil {
+			return err
+		}
+	}
+	for _, c := range snap.Conns {
+		_, err := fmt.Fprintf(w, "\t%q -- %q [up=%t style=%s];\n", c.One.String(), c.Other.String(), c.Up, dotStyle(c.Up))
+		if err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintln(w, "}")
+	return err
+}
+
+func dotStyle(up bool) string {
+	if up {
+		return "solid"
+	}
+	return "dashed"
+}
+
+// graphML is the GraphML document written by WriteGraphML
+type graphML struct {
+	XMLName xml.Name     `xml:"graphml"`
+	XMLNS   string       `xml:"xmlns,attr"`
+	Keys    []graphMLKey `xml:"key"`
+	Graph   graphMLGraph `xml:"graph"`
+}
+
+type graphMLKey struct {
+	ID   string `xml:"id,attr"`
+	For  string `xml:"for,attr"`
+	Name string `xml:"attr.name,attr"`
+	Type string `xml:"attr.type,attr"`
+}
+
+type graphMLGraph struct {
+	ID          string        `xml:"id,attr"`
+	EdgeDefault string        `xml:"edgedefault,attr"`
+	Nodes       []graphMLNode `xml:"node"`
+	Edges       []graphMLEdge `xml:"edge"`
+}
+
+type graphMLNode struct {
+	ID   string        `xml:"id,attr"`
+	Data []graphMLData `xml:"data"`
+}
+
+type graphMLEdge struct {
+	Source string        `xml:"source,attr"`
+	Target string        `xml:"target,attr"`
+	Data   []graphMLData `xml:"data"`
+}
+
+type graphMLData struct {
+	Key   string `xml:"key,attr"`
+	Value string `xml:",chardata"`
+}
+
+// WriteGraphML writes the snapshot's graph in GraphML, with the nodes'
+// names and whether nodes and connections are up as data
+func (snap *Snapshot) WriteGraphML(w io.Writer) error {
+	doc := &graphML{
+		XMLNS: "http://graphml.graphdrawing.org/xmlns",
+		Keys: []graphMLKey{
+			{ID: "name", For: "node", Name: "name", Type: "string"},
+			{ID: "node_up", For: "node", Name: "up", Type: "boolean"},
+			{ID: "conn_up", For: "edge", Name: "up", Type: "boolean"},
+		},
+		Graph: graphMLGraph{ID: "network", EdgeDefault: "undirected"},
+	}
+	for _, n := range snap.Nodes {
+		doc.Graph.Nodes = append(doc.Graph.Nodes, graphMLNode{
+			ID: n.Node.ID().String(),
+			Data: []graphMLData{
+				{Key: "name", Value: n.Node.Config.Name},
+				{Key: "node_up", Value: fmt.Sprint(n.Node.Up)},
+			},
+		})
+	}
+	for _, c := range snap.Conns {
+		doc.Graph.Edges = append(doc.Graph.Edges, graphMLEdge{
+			Source: c.One.String(),
+			Target: c.Other.String(),
+			Data:   []graphMLData{{Key: "conn_up", Value: fmt.Sprint(c.Up)}},
+		})
+	}
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// D3Graph is a network graph in the format used by d3-force layouts
+type D3Graph struct {
+	Nodes []D3Node `json:"nodes"`
+	Links []D3Link `json:"links"`
+}
+
+// D3Node is a node of a D3Graph
+type D3Node struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	Up   bool   `json:"up"`
+}
+
+// D3Link is a connection of a D3Graph, referencing nodes by their IDs
+type D3Link struct {
+	Source string `json:"source"`
+	Target string `json:"target"`
+	Up     bool   `json:"up"`
+}
+
+// D3 returns the snapshot's graph as d3-force nodes and links
+func (snap *Snapshot) D3() *D3Graph {
+	graph := &D3Graph{
+		Nodes: make([]D3Node, len(snap.Nodes)),
+		Links: make([]D3Link, len(snap.Conns)),
+	}
+	for i, n := range snap.Nodes {
+		graph.Nodes[i] = D3Node{ID: n.Node.ID().String(), Name: n.Node.Config.Name, Up: n.Node.Up}
+	}
+	for i, c := range snap.Conns {
+		graph.Links[i] = D3Link{Source: c.One.String(), Target: c.Other.String(), Up: c.Up}
+	}
+	return graph
+}
+
+// WriteD3 writes the snapshot's graph as JSON encoded d3-force nodes and
+// links
+func (snap *Snapshot) WriteD3(w io.Writer) error {
+	return json.NewEncoder(w).Encode(snap.D3())
+}
@@ -0,0 +1,151 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package simulations
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// testExportSnapshot returns a snapshot of two nodes which are up and one
+// which is down, with one connection up and one down
+func testExportSnapshot() *Snapshot {
+	nodes := testGraphNodes(3)
+	nodes[2].Up = false
+	snap := &Snapshot{}
+	for i, node := range nodes {
+		node.Config.Name = string('a' + byte(i))
+		snap.Nodes = append(snap.Nodes, NodeSnapshot{Node: *node})
+	}
+	for _, conn := range testGraphConns(nodes, [2]int{0, 1}, [2]int{1, 2}) {
+		snap.Conns = append(snap.Conns, *conn)
+	}
+	snap.Conns[1].Up = false
+	return snap
+}
+
+func TestWriteSnapshotDOT(t *testing.T) {
+	snap := testExportSnapshot()
+	var buf bytes.Buffer
+	if err := WriteSnapshot(&buf, snap, "dot"); err != nil {
+		t.Fatal(err)
+	}
+	dot := buf.String()
+	if !strings.HasPrefix(dot, "graph network {\n") || !strings.HasSuffix(dot, "}\n") {
+		t.Fatalf("expected an undirected DOT graph, got:\n%s", dot)
+	}
+	for _, want := range []string{
+		`"` + snap.Nodes[0].Node.ID().String() + `" [label="a" up=true style=solid];`,
+		`"` + snap.Nodes[2].Node.ID().String() + `" [label="c" up=false style=dashed];`,
+		`"` + snap.Conns[0].One.String() + `" -- "` + snap.Conns[0].Other.String() + `" [up=true style=solid];`,
+		`"` + snap.Conns[1].One.String() + `" -- "` + snap.Conns[1].Other.String() + `" [up=false style=dashed];`,
+	} {
+		if !strings.Contains(dot, want) {
+			t.Fatalf("expected DOT graph to contain %s, got:\n%s", want, dot)
+		}
+	}
+}
+
+func TestWriteSnapshotGraphML(t *testing.T) {
+	snap := testExportSnapshot()
+	var buf bytes.Buffer
+	if err := WriteSnapshot(&buf, snap, "graphml"); err != nil {
+		t.Fatal(err)
+	}
+	var doc graphML
+	if err := xml.Unmarshal(buf.Bytes(), &doc); err != nil {
+		t.Fatalf("error decoding GraphML: %s", err)
+	}
+	if len(doc.Graph.Nodes) != 3 || len(doc.Graph.Edges) != 2 {
+		t.Fatalf("expected 3 nodes and 2 edges, got %d and %d", len(doc.Graph.Nodes), len(doc.Graph.Edges))
+	}
+	node := doc.Graph.Nodes[2]
+	if node.ID != snap.Nodes[2].Node.ID().String() {
+		t.Fatalf("expected node ID %s, got %s", snap.Nodes[2].Node.ID(), node.ID)
+	}
+	if node.Data[0].Value != "c" || node.Data[1].Value != "false" {
+		t.Fatalf("expected node c to be down, got %v", node.Data)
+	}
+	if edge := doc.Graph.Edges[1]; edge.Data[0].Value != "false" {
+		t.Fatalf("expected the second edge to be down, got %v", edge.Data)
+	}
+}
+
+func TestWriteSnapshotD3(t *testing.T) {
+	snap := testExportSnapshot()
+	var buf bytes.Buffer
+	if err := WriteSnapshot(&buf, snap, "d3"); err != nil {
+		t.Fatal(err)
+	}
+	var graph D3Graph
+	if err := json.NewDecoder(&buf).Decode(&graph); err != nil {
+		t.Fatal(err)
+	}
+	if len(graph.Nodes) != 3 || len(graph.Links) != 2 {
+		t.Fatalf("expected 3 nodes and 2 links, got %d and %d", len(graph.Nodes), len(graph.Links))
+	}
+	link := graph.Links[0]
+	if link.Source != graph.Nodes[0].ID || link.Target != graph.Nodes[1].ID || !link.Up {
+		t.Fatalf("expected an active link from a to b, got %+v", link)
+	}
+
+	if err := WriteSnapshot(&buf, snap, "svg"); err == nil {
+		t.Fatal("expected an error for an unknown format")
+	}
+}
+
+func TestHTTPSnapshotFormat(t *testing.T) {
+	network, s := testHTTPServer(t)
+	defer s.Close()
+	defer network.Shutdown()
+	if _, err := network.NewNode(); err != nil {
+		t.Fatalf("error creating node: %s", err)
+	}
+
+	res, err := http.Get(s.URL + "/snapshot?format=dot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("expected HTTP status 200, got %s", res.Status)
+	}
+	if ct := res.Header.Get("Content-Type"); ct != "text/vnd.graphviz" {
+		t.Fatalf("expected DOT content type, got %q", ct)
+	}
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(body), "graph network {") {
+		t.Fatalf("expected a DOT graph, got:\n%s", body)
+	}
+
+	res, err = http.Get(s.URL + "/snapshot?format=svg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected HTTP status 400, got %s", res.Status)
+	}
+}
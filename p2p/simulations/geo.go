@@ -0,0 +1,41 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package simulations
+
+import (
+	"encoding/binary"
+	"math"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/p2p/discover"
+)
+
+// GeoFromNodeID deterministically places a node on the globe by hashing its
+// ID, so that a node is always at the same coordinates across simulation
+// runs. The coordinates are uniformly distributed over the surface of the
+// globe, with the latitude in [-90, 90] and the longitude in [-180, 180).
+func GeoFromNodeID(id discover.NodeID) (lat, lon float64) {
+	hash := crypto.Keccak256(id[:])
+	u := float64(binary.BigEndian.Uint64(hash[:8])) / math.MaxUint64
+	v := float64(binary.BigEndian.Uint64(hash[8:16])) / math.MaxUint64
+	lat = math.Asin(2*u-1) * 180 / math.Pi
+	lon = v*360 - 180
+	if lon >= 180 {
+		lon = -180
+	}
+	return lat, lon
+}
@@ -0,0 +1,39 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package simulations
+
+import (
+	"testing"
+
+	"github.com/ethereum/go-ethereum/p2p/simulations/adapters"
+)
+
+func TestGeoFromNodeID(t *testing.T) {
+	for i := 0; i < 100; i++ {
+		id := adapters.RandomNodeConfig().ID
+		lat, lon := GeoFromNodeID(id)
+		if lat < -90 || lat > 90 {
+			t.Fatalf("expected latitude in [-90, 90], got %f", lat)
+		}
+		if lon < -180 || lon >= 180 {
+			t.Fatalf("expected longitude in [-180, 180), got %f", lon)
+		}
+		if lat2, lon2 := GeoFromNodeID(id); lat2 != lat || lon2 != lon {
+			t.Fatalf("expected the same coordinates for node %s, got (%f, %f) and (%f, %f)", id.TerminalString(), lat, lon, lat2, lon2)
+		}
+	}
+}
@@ -0,0 +1,639 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package simulations
+
+import (
+	"bytes"
+	"math/rand"
+	"sort"
+	"time"
+
+	"github.com/ethereum/go-ethereum/p2p/discover"
+)
+
+// graph is an undirected adjacency set of the active connections in a
+// network, used to compute topology metrics
+type graph map[discover.NodeID]map[discover.NodeID]struct{}
+
+// newGraph builds a graph containing the given nodes and the connections
+// between them which are up. Connections referencing nodes which are not
+// in the list are ignored.
+func newGraph(nodes []*Node, conns []*Conn) graph {
+	g := make(graph, len(nodes))
+	for _, node := range nodes {
+		g[node.ID()] = make(map[discover.NodeID]struct{})
+	}
+	for _, conn := range conns {
+		if !conn.Up || conn.One == conn.Other {
+			continue
+		}
+		if _, ok := g[conn.One]; !ok {
+			continue
+		}
+		if _, ok := g[conn.Other]; !ok {
+			continue
+		}
+		g[conn.One][conn.Other] = struct{}{}
+		g[conn.Other][conn.One] = struct{}{}
+	}
+	return g
+}
+
+// newConnGraph builds a graph containing the nodes referenced by the given
+// connections and the connections between them which are up
+func newConnGraph(conns []*Conn) graph {
+	g := make(graph)
+	for _, conn := range conns {
+		for _, id := range []discover.NodeID{conn.One, conn.Other} {
+			if _, ok := g[id]; !ok {
+				g[id] = make(map[discover.NodeID]struct{})
+			}
+		}
+		if !conn.Up || conn.One == conn.Other {
+			continue
+		}
+		g[conn.One][conn.Other] = struct{}{}
+		g[conn.Other][conn.One] = struct{}{}
+	}
+	return g
+}
+
+// ExpectedPropagationTime returns the time it takes a message sent by src to
+// reach the last node it can reach when forwarded over the active
+// connections, using the connections' latencies as the cost of each hop.
+//
+// Nodes in other components of the graph can never be reached, so the
+// returned time only covers the component of src and the number of nodes
+// which cannot be reached is returned separately.
+func ExpectedPropagationTime(src discover.NodeID, conns []*Conn) (time.Duration, int) {
+	latency := make(map[discover.NodeID]map[discover.NodeID]time.Duration)
+	for id, peers := range newConnGraph(conns) {
+		latency[id] = make(map[discover.NodeID]time.Duration, len(peers))
+	}
+	for _, conn := range conns {
+		if !conn.Up || conn.One == conn.Other {
+			continue
+		}
+		// use the fastest of any duplicate connections
+		if d, ok := latency[conn.One][conn.Other]; ok && d <= conn.Latency {
+			continue
+		}
+		latency[conn.One][conn.Other] = conn.Latency
+		latency[conn.Other][conn.One] = conn.Latency
+	}
+	if _, ok := latency[src]; !ok {
+		return 0, 0
+	}
+
+	// run Dijkstra's algorithm from src
+	arrival := map[discover.NodeID]time.Duration{src: 0}
+	done := make(map[discover.NodeID]bool)
+	var last time.Duration
+	for {
+		var (
+			next  discover.NodeID
+			found bool
+		)
+		for id, t := range arrival {
+			if !done[id] && (!found || t < arrival[next]) {
+				next, found = id, true
+			}
+		}
+		if !found {
+			break
+		}
+		done[next] = true
+		last = arrival[next]
+		for peer, d := range latency[next] {
+			if t, ok := arrival[peer]; !ok || arrival[next]+d < t {
+				arrival[peer] = arrival[next] + d
+			}
+		}
+	}
+	return last, len(latency) - len(arrival)
+}
+
+// HonestMaliciousMix counts the active connections between the given nodes
+// by whether each end is a malicious node (see adapters.NodeConfig), which
+// quantifies how far an adversary reaches into the honest network
+func HonestMaliciousMix(nodes []*Node, conns []*Conn) (honestHonest, honestMalicious, maliciousMalicious int) {
+	malicious := make(map[discover.NodeID]bool, len(nodes))
+	for _, node := range nodes {
+		malicious[node.ID()] = node.Config.Malicious
+	}
+	for one, peers := range newGraph(nodes, conns) {
+		for other := range peers {
+			// each connection appears in the graph twice
+			if bytes.Compare(one[:], other[:]) > 0 {
+				continue
+			}
+			switch {
+			case malicious[one] && malicious[other]:
+				maliciousMalicious++
+			case malicious[one] || malicious[other]:
+				honestMalicious++
+			default:
+				honestHonest++
+			}
+		}
+	}
+	return
+}
+
+// KCoreDecomposition returns the coreness of each of the given nodes, which
+// is the largest k such that the node belongs to the k-core of the graph
+// formed by the active connections (the k-core being the maximal subgraph in
+// which every node has at least k peers).
+//
+// Nodes are repeatedly pruned in order of their remaining degree, so the
+// nodes with the highest coreness form the resilient backbone of the network.
+func KCoreDecomposition(nodes []*Node, conns []*Conn) map[discover.NodeID]int {
+	g := newGraph(nodes, conns)
+	degree := make(map[discover.NodeID]int, len(g))
+	for id, peers := range g {
+		degree[id] = len(peers)
+	}
+	core := make(map[discover.NodeID]int, len(g))
+	k := 0
+	for len(degree) > 0 {
+		// pick the remaining node with the lowest degree
+		var (
+			min   discover.NodeID
+			found bool
+		)
+		for id, d := range degree {
+			if !found || d < degree[min] {
+				min, found = id, true
+			}
+		}
+		if degree[min] > k {
+			k = degree[min]
+		}
+		core[min] = k
+		delete(degree, min)
+		for peer := range g[min] {
+			if _, ok := degree[peer]; ok {
+				degree[peer]--
+			}
+		}
+	}
+	return core
+}
+
+// PropagateLossy simulates flooding a message from src over the active
+// connections, with every node forwarding the message once to each of its
+// peers and each forwarded copy being dropped with probability loss.
+//
+// It returns the fraction of the nodes referenced by conns which received
+// the message (including src), so redundant paths compensate for loss.
+func PropagateLossy(src discover.NodeID, conns []*Conn, loss float64, r *rand.Rand) (coverage float64) {
+	g := newConnGraph(conns)
+	if _, ok := g[src]; !ok {
+		return 0
+	}
+	received := map[discover.NodeID]struct{}{src: {}}
+	queue := []discover.NodeID{src}
+	for len(queue) > 0 {
+		id := queue[0]
+		queue = queue[1:]
+		for peer := range g[id] {
+			if r.Float64() < loss {
+				continue
+			}
+			if _, ok := received[peer]; ok {
+				continue
+			}
+			received[peer] = struct{}{}
+			queue = append(queue, peer)
+		}
+	}
+	return float64(len(received)) / float64(len(g))
+}
+
+// distances returns the number of hops from src to every node reachable from
+// it in the graph
+func (g graph) distances(src discover.NodeID) map[discover.NodeID]int {
+	dist := map[discover.NodeID]int{src: 0}
+	queue := []discover.NodeID{src}
+	for len(queue) > 0 {
+		id := queue[0]
+		queue = queue[1:]
+		for peer := range g[id] {
+			if _, ok := dist[peer]; ok {
+				continue
+			}
+			dist[peer] = dist[id] + 1
+			queue = append(queue, peer)
+		}
+	}
+	return dist
+}
+
+// SampledAveragePathLength estimates the average shortest path length between
+// pairs of nodes by measuring the distance between the given number of
+// randomly sampled source and target nodes, which unlike an all-pairs
+// computation scales to large networks.
+//
+// Pairs which are not connected by any path are not included in the
+// average, and 0 is returned if none of the sampled pairs are connected.
+func SampledAveragePathLength(nodes []*Node, conns []*Conn, samples int, r *rand.Rand) float64 {
+	if len(nodes) < 2 {
+		return 0
+	}
+	g := newGraph(nodes, conns)
+	var total, count int
+	for i := 0; i < samples; i++ {
+		src := nodes[r.Intn(len(nodes))].ID()
+		dst := nodes[r.Intn(len(nodes)-1)].ID()
+		if dst == src {
+			dst = nodes[len(nodes)-1].ID()
+		}
+		d, ok := g.distances(src)[dst]
+		if !ok {
+			continue
+		}
+		total += d
+		count++
+	}
+	if count == 0 {
+		return 0
+	}
+	return float64(total) / float64(count)
+}
+
+// CliqueBreakingConns returns a set of active connections which, when
+// dropped, leave no clique of more than maxSize mutually connected nodes.
+//
+// Finding cliques exactly is expensive, so cliques are found greedily by
+// growing one from each node through its best connected peers, which may
+// miss some cliques but never reports one which does not exist.
+func CliqueBreakingConns(nodes []*Node, conns []*Conn, maxSize int) []*Conn {
+	g := newGraph(nodes, conns)
+	labels := make(map[string]*Conn, len(conns))
+	for _, conn := range conns {
+		labels[ConnLabel(conn.One, conn.Other)] = conn
+	}
+	var drop []*Conn
+	for _, node := range nodes {
+		for {
+			clique := g.greedyClique(node.ID())
+			if len(clique) <= maxSize {
+				break
+			}
+			// dropping a single connection splits the clique in two
+			// cliques which are one node smaller
+			one, other := clique[0], clique[len(clique)-1]
+			delete(g[one], other)
+			delete(g[other], one)
+			drop = append(drop, labels[ConnLabel(one, other)])
+		}
+	}
+	return drop
+}
+
+// greedyClique returns a clique containing id, built by adding the peers of
+// id in order of decreasing degree whenever they are connected to all the
+// nodes already in the clique
+func (g graph) greedyClique(id discover.NodeID) []discover.NodeID {
+	peers := make([]discover.NodeID, 0, len(g[id]))
+	for peer := range g[id] {
+		peers = append(peers, peer)
+	}
+	sort.Slice(peers, func(i, j int) bool {
+		if len(g[peers[i]]) != len(g[peers[j]]) {
+			return len(g[peers[i]]) > len(g[peers[j]])
+		}
+		return bytes.Compare(peers[i][:], peers[j][:]) < 0
+	})
+	clique := []discover.NodeID{id}
+	for _, peer := range peers {
+		connected := true
+		for _, member := range clique {
+			if _, ok := g[peer][member]; !ok {
+				connected = false
+				break
+			}
+		}
+		if connected {
+			clique = append(clique, peer)
+		}
+	}
+	return clique
+}
+
+// VertexConnectivity returns the minimum number of nodes whose removal
+// disconnects the graph formed by the active connections, computed from the
+// maximum number of node-disjoint paths between every pair of nodes which
+// are not directly connected. A complete graph of n nodes has a vertex
+// connectivity of n-1.
+//
+// The computation requires a maximum flow per pair of nodes, so for large
+// networks use SampledVertexConnectivity instead.
+func VertexConnectivity(nodes []*Node, conns []*Conn) int {
+	g := newGraph(nodes, conns)
+	ids := nodeIDs(nodes)
+	min := len(ids) - 1
+	for i := range ids {
+		for j := i + 1; j < len(ids); j++ {
+			if _, ok := g[ids[i]][ids[j]]; ok {
+				continue
+			}
+			if k := g.vertexDisjointPaths(ids, i, j); k < min {
+				min = k
+			}
+		}
+	}
+	if min < 0 {
+		return 0
+	}
+	return min
+}
+
+// EdgeConnectivity returns the minimum number of active connections whose
+// removal disconnects the graph, computed from the maximum number of
+// edge-disjoint paths between one node and every other node. A cycle has an
+// edge connectivity of 2.
+func EdgeConnectivity(nodes []*Node, conns []*Conn) int {
+	if len(nodes) < 2 {
+		return 0
+	}
+	g := newGraph(nodes, conns)
+	ids := nodeIDs(nodes)
+	min := len(ids) - 1
+	for j := 1; j < len(ids); j++ {
+		if k := g.edgeDisjointPaths(ids, 0, j); k < min {
+			min = k
+		}
+	}
+	return min
+}
+
+// SampledVertexConnectivity estimates VertexConnectivity from the given
+// number of randomly sampled pairs of nodes which are not directly
+// connected. Since only a subset of the pairs is considered the estimate
+// never underestimates the connectivity and converges on it as the number
+// of samples increases.
+func SampledVertexConnectivity(nodes []*Node, conns []*Conn, samples int, r *rand.Rand) int {
+	if len(nodes) < 2 {
+		return 0
+	}
+	g := newGraph(nodes, conns)
+	ids := nodeIDs(nodes)
+	min := len(ids) - 1
+	for n := 0; n < samples; n++ {
+		i, j := r.Intn(len(ids)), r.Intn(len(ids))
+		if i == j {
+			continue
+		}
+		if _, ok := g[ids[i]][ids[j]]; ok {
+			continue
+		}
+		if k := g.vertexDisjointPaths(ids, i, j); k < min {
+			min = k
+		}
+	}
+	return min
+}
+
+// SampledEdgeConnectivity estimates EdgeConnectivity from the given number
+// of randomly sampled pairs of nodes, never underestimating it.
+func SampledEdgeConnectivity(nodes []*Node, conns []*Conn, samples int, r *rand.Rand) int {
+	if len(nodes) < 2 {
+		return 0
+	}
+	g := newGraph(nodes, conns)
+	ids := nodeIDs(nodes)
+	min := len(ids) - 1
+	for n := 0; n < samples; n++ {
+		i, j := r.Intn(len(ids)), r.Intn(len(ids))
+		if i == j {
+			continue
+		}
+		if k := g.edgeDisjointPaths(ids, i, j); k < min {
+			min = k
+		}
+	}
+	return min
+}
+
+// nodeIDs returns the IDs of the given nodes
+func nodeIDs(nodes []*Node) []discover.NodeID {
+	ids := make([]discover.NodeID, len(nodes))
+	for i, node := range nodes {
+		ids[i] = node.ID()
+	}
+	return ids
+}
+
+// edgeDisjointPaths returns the maximum number of edge-disjoint paths
+// between ids[s] and ids[t]
+func (g graph) edgeDisjointPaths(ids []discover.NodeID, s, t int) int {
+	index := make(map[discover.NodeID]int, len(ids))
+	for i, id := range ids {
+		index[id] = i
+	}
+	f := make(flowNetwork)
+	for i, id := range ids {
+		for peer := range g[id] {
+			f.add(i, index[peer], 1)
+		}
+	}
+	return f.maxFlow(s, t)
+}
+
+// vertexDisjointPaths returns the maximum number of node-disjoint paths
+// between ids[s] and ids[t], which is computed by splitting every node i
+// into an inbound (2i) and an outbound (2i+1) vertex joined by a unit
+// capacity edge
+func (g graph) vertexDisjointPaths(ids []discover.NodeID, s, t int) int {
+	index := make(map[discover.NodeID]int, len(ids))
+	for i, id := range ids {
+		index[id] = i
+	}
+	inf := len(ids)
+	f := make(flowNetwork)
+	for i, id := range ids {
+		f.add(2*i, 2*i+1, 1)
+		for peer := range g[id] {
+			f.add(2*i+1, 2*index[peer], inf)
+		}
+	}
+	return f.maxFlow(2*s+1, 2*t)
+}
+
+// flowNetwork is a residual graph of integer edge capacities used to compute
+// maximum flows
+type flowNetwork map[int]map[int]int
+
+// add adds the given capacity to the edge from -> to
+func (f flowNetwork) add(from, to, capacity int) {
+	for _, v := range []int{from, to} {
+		if _, ok := f[v]; !ok {
+			f[v] = make(map[int]int)
+		}
+	}
+	f[from][to] += capacity
+}
+
+// maxFlow returns the maximum flow from s to t using the Edmonds-Karp
+// algorithm, leaving the residual capacities in f
+func (f flowNetwork) maxFlow(s, t int) int {
+	flow := 0
+	for {
+		// find the shortest augmenting path
+		prev := map[int]int{s: s}
+		queue := []int{s}
+		for len(queue) > 0 && !containsKey(prev, t) {
+			v := queue[0]
+			queue = queue[1:]
+			for w, c := range f[v] {
+				if c <= 0 || containsKey(prev, w) {
+					continue
+				}
+				prev[w] = v
+				queue = append(queue, w)
+			}
+		}
+		if !containsKey(prev, t) {
+			return flow
+		}
+
+		// push the bottleneck capacity along the path
+		bottleneck := -1
+		for v := t; v != s; v = prev[v] {
+			if c := f[prev[v]][v]; bottleneck < 0 || c < bottleneck {
+				bottleneck = c
+			}
+		}
+		for v := t; v != s; v = prev[v] {
+			f[prev[v]][v] -= bottleneck
+			f[v][prev[v]] += bottleneck
+		}
+		flow += bottleneck
+	}
+}
+
+func containsKey(m map[int]int, k int) bool {
+	_, ok := m[k]
+	return ok
+}
+
+// LocalRedundancy returns the number of peers of the given node which it
+// could still reach within two hops if its direct connection to them failed,
+// i.e. the peers which are also connected to at least one of its other
+// peers.
+//
+// Nodes whose peers are all interconnected have a redundancy equal to their
+// degree, whereas the centre of a star has no redundancy at all since every
+// peer is only reachable through a single path.
+func LocalRedundancy(node discover.NodeID, conns []*Conn) int {
+	g := newConnGraph(conns)
+	redundancy := 0
+	for peer := range g[node] {
+		for other := range g[peer] {
+			if other == node {
+				continue
+			}
+			if _, ok := g[node][other]; ok {
+				redundancy++
+				break
+			}
+		}
+	}
+	return redundancy
+}
+
+// Components returns the connected components of the graph formed by the
+// active connections between the given nodes, with the nodes of each
+// component in the order they appear in nodes
+func Components(nodes []*Node, conns []*Conn) [][]discover.NodeID {
+	g := newGraph(nodes, conns)
+	component := make(map[discover.NodeID]int, len(nodes))
+	var components [][]discover.NodeID
+	for _, node := range nodes {
+		if _, ok := component[node.ID()]; ok {
+			continue
+		}
+		for id := range g.distances(node.ID()) {
+			component[id] = len(components)
+		}
+		components = append(components, nil)
+	}
+	for _, node := range nodes {
+		i := component[node.ID()]
+		components[i] = append(components[i], node.ID())
+	}
+	return components
+}
+
+// ReachabilityMatrix returns whether each of the given nodes can reach each
+// other node over the active connections, so that reachable[a][b] is true if
+// a and b are in the same connected component.
+//
+// The matrix takes O(V²) memory, so for large networks it is cheaper to
+// compute the Components and check whether two nodes share one.
+func ReachabilityMatrix(nodes []*Node, conns []*Conn) map[discover.NodeID]map[discover.NodeID]bool {
+	reachable := make(map[discover.NodeID]map[discover.NodeID]bool, len(nodes))
+	for _, component := range Components(nodes, conns) {
+		for _, one := range component {
+			reachable[one] = make(map[discover.NodeID]bool, len(component))
+			for _, other := range component {
+				reachable[one][other] = true
+			}
+		}
+	}
+	return reachable
+}
+
+// ClusteringCoefficient returns the average local clustering coefficient of
+// the given nodes, where a node's coefficient is the fraction of pairs of its
+// peers which are also connected to each other.
+//
+// Nodes with fewer than two peers have a coefficient of zero, so a tree has
+// a clustering coefficient of zero and a full mesh one of one.
+func ClusteringCoefficient(nodes []*Node, conns []*Conn) float64 {
+	if len(nodes) == 0 {
+		return 0
+	}
+	g := newGraph(nodes, conns)
+	var total float64
+	for _, node := range nodes {
+		peers := nodeIDsOf(g[node.ID()])
+		if len(peers) < 2 {
+			continue
+		}
+		links := 0
+		for i, one := range peers {
+			for _, other := range peers[i+1:] {
+				if _, ok := g[one][other]; ok {
+					links++
+				}
+			}
+		}
+		total += float64(2*links) / float64(len(peers)*(len(peers)-1))
+	}
+	return total / float64(len(nodes))
+}
+
+// nodeIDsOf returns the IDs in the given adjacency set
+func nodeIDsOf(set map[discover.NodeID]struct{}) []discover.NodeID {
+	ids := make([]discover.NodeID, 0, len(set))
+	for id := range set {
+		ids = append(ids, id)
+	}
+	return ids
+}
@@ -0,0 +1,356 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package simulations
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/p2p/discover"
+	"github.com/ethereum/go-ethereum/p2p/simulations/adapters"
+)
+
+// testGraphNodes returns n nodes with distinct IDs which can be used to
+// build graphs without running a network
+func testGraphNodes(n int) []*Node {
+	nodes := make([]*Node, n)
+	for i := range nodes {
+		var id discover.NodeID
+		id[0] = byte(i >> 8)
+		id[1] = byte(i)
+		nodes[i] = &Node{Config: &adapters.NodeConfig{ID: id}, Up: true}
+	}
+	return nodes
+}
+
+// testGraphConns returns active connections between the given pairs of node
+// indexes
+func testGraphConns(nodes []*Node, pairs ...[2]int) []*Conn {
+	conns := make([]*Conn, len(pairs))
+	for i, pair := range pairs {
+		conns[i] = &Conn{
+			One:   nodes[pair[0]].ID(),
+			Other: nodes[pair[1]].ID(),
+			Up:    true,
+		}
+	}
+	return conns
+}
+
+// testRingConns returns active connections forming a ring of the given nodes
+func testRingConns(nodes []*Node) []*Conn {
+	pairs := make([][2]int, len(nodes))
+	for i := range nodes {
+		pairs[i] = [2]int{i, (i + 1) % len(nodes)}
+	}
+	return testGraphConns(nodes, pairs...)
+}
+
+// testMeshConns returns active connections between every pair of the given
+// nodes
+func testMeshConns(nodes []*Node) []*Conn {
+	var pairs [][2]int
+	for i := range nodes {
+		for j := i + 1; j < len(nodes); j++ {
+			pairs = append(pairs, [2]int{i, j})
+		}
+	}
+	return testGraphConns(nodes, pairs...)
+}
+
+func TestKCoreDecomposition(t *testing.T) {
+	// nodes 0-3 form a 4-clique, node 4 is attached to two clique members
+	// and to the leaf node 5, and node 6 is isolated
+	nodes := testGraphNodes(7)
+	conns := testGraphConns(nodes,
+		[2]int{0, 1}, [2]int{0, 2}, [2]int{0, 3},
+		[2]int{1, 2}, [2]int{1, 3}, [2]int{2, 3},
+		[2]int{4, 0}, [2]int{4, 1}, [2]int{4, 5},
+	)
+	// a connection which is down should not count
+	conns = append(conns, &Conn{One: nodes[5].ID(), Other: nodes[6].ID()})
+
+	expected := []int{3, 3, 3, 3, 2, 1, 0}
+	core := KCoreDecomposition(nodes, conns)
+	if len(core) != len(nodes) {
+		t.Fatalf("expected %d coreness values, got %d", len(nodes), len(core))
+	}
+	for i, node := range nodes {
+		if core[node.ID()] != expected[i] {
+			t.Fatalf("expected node %d to have coreness %d, got %d", i, expected[i], core[node.ID()])
+		}
+	}
+}
+
+func TestPropagateLossy(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+
+	// average the coverage of a ring over several runs
+	ring := testGraphNodes(50)
+	ringConns := testRingConns(ring)
+	coverage := func(loss float64) float64 {
+		var total float64
+		for i := 0; i < 100; i++ {
+			total += PropagateLossy(ring[0].ID(), ringConns, loss, r)
+		}
+		return total / 100
+	}
+	if c := coverage(0); c != 1 {
+		t.Fatalf("expected full coverage without loss, got %f", c)
+	}
+	prev := 1.0
+	for _, loss := range []float64{0.1, 0.3, 0.6, 0.9} {
+		c := coverage(loss)
+		if c >= prev {
+			t.Fatalf("expected coverage to decrease with loss %f, got %f >= %f", loss, c, prev)
+		}
+		prev = c
+	}
+
+	// a full mesh has enough redundant paths to overcome heavy loss
+	mesh := testGraphNodes(30)
+	if c := PropagateLossy(mesh[0].ID(), testMeshConns(mesh), 0.5, r); c < 0.99 {
+		t.Fatalf("expected near full coverage of a full mesh, got %f", c)
+	}
+}
+
+func TestSampledAveragePathLength(t *testing.T) {
+	// the distances from any node in a ring of 10 nodes to the other 9 are
+	// 1, 1, 2, 2, 3, 3, 4, 4 and 5
+	nodes := testGraphNodes(10)
+	conns := testRingConns(nodes)
+	exact := 25.0 / 9.0
+
+	r := rand.New(rand.NewSource(1))
+	for _, test := range []struct {
+		samples   int
+		tolerance float64
+	}{
+		{100, 0.5},
+		{1000, 0.2},
+		{10000, 0.05},
+	} {
+		avg := SampledAveragePathLength(nodes, conns, test.samples, r)
+		if avg < exact-test.tolerance || avg > exact+test.tolerance {
+			t.Fatalf("expected average path length of %d samples to be within %f of %f, got %f", test.samples, test.tolerance, exact, avg)
+		}
+	}
+}
+
+func TestCliqueBreakingConns(t *testing.T) {
+	// a clique which doesn't exceed the maximum size should be kept
+	nodes := testGraphNodes(4)
+	if drop := CliqueBreakingConns(nodes, testMeshConns(nodes), 4); len(drop) != 0 {
+		t.Fatalf("expected no connections to drop, got %d", len(drop))
+	}
+
+	// a 6-clique should be broken until no 5 nodes are fully connected
+	nodes = testGraphNodes(6)
+	conns := testMeshConns(nodes)
+	drop := CliqueBreakingConns(nodes, conns, 4)
+	if len(drop) == 0 {
+		t.Fatal("expected connections to drop")
+	}
+	for _, conn := range drop {
+		conn.Up = false
+	}
+	g := newGraph(nodes, conns)
+	for skip := range nodes {
+		// the 5 nodes other than nodes[skip]
+		var members []discover.NodeID
+		for i, node := range nodes {
+			if i != skip {
+				members = append(members, node.ID())
+			}
+		}
+		complete := true
+		for i, one := range members {
+			for _, other := range members[i+1:] {
+				if _, ok := g[one][other]; !ok {
+					complete = false
+				}
+			}
+		}
+		if complete {
+			t.Fatalf("expected clique without node %d to be broken", skip)
+		}
+	}
+}
+
+func TestConnectivity(t *testing.T) {
+	cycle := testGraphNodes(6)
+	path := testGraphNodes(4)
+	mesh := testGraphNodes(5)
+	bowtie := testGraphNodes(5)
+	split := testGraphNodes(4)
+	for _, test := range []struct {
+		name   string
+		nodes  []*Node
+		conns  []*Conn
+		vertex int
+		edge   int
+	}{
+		{"cycle", cycle, testRingConns(cycle), 2, 2},
+		{"path", path, testGraphConns(path, [2]int{0, 1}, [2]int{1, 2}, [2]int{2, 3}), 1, 1},
+		{"mesh", mesh, testMeshConns(mesh), 4, 4},
+		// two triangles sharing node 2
+		{"bowtie", bowtie, testGraphConns(bowtie,
+			[2]int{0, 1}, [2]int{1, 2}, [2]int{2, 0},
+			[2]int{2, 3}, [2]int{3, 4}, [2]int{4, 2},
+		), 1, 2},
+		{"split", split, testGraphConns(split, [2]int{0, 1}, [2]int{2, 3}), 0, 0},
+	} {
+		if k := VertexConnectivity(test.nodes, test.conns); k != test.vertex {
+			t.Fatalf("%s: expected vertex connectivity %d, got %d", test.name, test.vertex, k)
+		}
+		if k := EdgeConnectivity(test.nodes, test.conns); k != test.edge {
+			t.Fatalf("%s: expected edge connectivity %d, got %d", test.name, test.edge, k)
+		}
+
+		// with enough samples the estimates should find the exact values
+		r := rand.New(rand.NewSource(1))
+		if k := SampledVertexConnectivity(test.nodes, test.conns, 100, r); k != test.vertex {
+			t.Fatalf("%s: expected sampled vertex connectivity %d, got %d", test.name, test.vertex, k)
+		}
+		if k := SampledEdgeConnectivity(test.nodes, test.conns, 100, r); k != test.edge {
+			t.Fatalf("%s: expected sampled edge connectivity %d, got %d", test.name, test.edge, k)
+		}
+	}
+}
+
+func TestExpectedPropagationTime(t *testing.T) {
+	nodes := testGraphNodes(6)
+	conns := testGraphConns(nodes,
+		[2]int{0, 1}, [2]int{1, 2}, [2]int{0, 2}, [2]int{2, 3},
+	)
+	conns[0].Latency = 10 * time.Millisecond
+	conns[1].Latency = 10 * time.Millisecond
+	conns[2].Latency = 50 * time.Millisecond
+	conns[3].Latency = 5 * time.Millisecond
+	// nodes 4 and 5 are only connected by a connection which is down
+	conns = append(conns, &Conn{One: nodes[4].ID(), Other: nodes[5].ID(), Latency: time.Millisecond})
+
+	// the message should reach node 2 via node 1 rather than over the slow
+	// direct connection, and then node 3 after 25ms
+	d, unreachable := ExpectedPropagationTime(nodes[0].ID(), conns)
+	if d != 25*time.Millisecond {
+		t.Fatalf("expected propagation time of 25ms, got %s", d)
+	}
+	if unreachable != 2 {
+		t.Fatalf("expected 2 unreachable nodes, got %d", unreachable)
+	}
+
+	d, unreachable = ExpectedPropagationTime(nodes[3].ID(), conns)
+	if d != 25*time.Millisecond {
+		t.Fatalf("expected propagation time of 25ms, got %s", d)
+	}
+	if unreachable != 2 {
+		t.Fatalf("expected 2 unreachable nodes, got %d", unreachable)
+	}
+}
+
+func TestHonestMaliciousMix(t *testing.T) {
+	nodes := testGraphNodes(10)
+	for _, node := range nodes[:3] {
+		node.Config.Malicious = true
+	}
+	conns := testMeshConns(nodes)
+	conns[len(conns)-1].Up = false
+
+	hh, hm, mm := HonestMaliciousMix(nodes, conns)
+	if hh+hm+mm != len(conns)-1 {
+		t.Fatalf("expected counts to sum to %d active connections, got %d", len(conns)-1, hh+hm+mm)
+	}
+	// the connection which is down is between two honest nodes
+	if hh != 7*6/2-1 {
+		t.Fatalf("expected %d honest-honest connections, got %d", 7*6/2-1, hh)
+	}
+	if hm != 3*7 {
+		t.Fatalf("expected %d honest-malicious connections, got %d", 3*7, hm)
+	}
+	if mm != 3 {
+		t.Fatalf("expected 3 malicious-malicious connections, got %d", mm)
+	}
+}
+
+func TestLocalRedundancy(t *testing.T) {
+	// every peer of a node in a full mesh is reachable via the others
+	mesh := testGraphNodes(5)
+	if r := LocalRedundancy(mesh[0].ID(), testMeshConns(mesh)); r != 4 {
+		t.Fatalf("expected full mesh redundancy of 4, got %d", r)
+	}
+
+	// the peers of the centre of a star are only reachable directly
+	star := testGraphNodes(5)
+	conns := testGraphConns(star, [2]int{0, 1}, [2]int{0, 2}, [2]int{0, 3}, [2]int{0, 4})
+	if r := LocalRedundancy(star[0].ID(), conns); r != 0 {
+		t.Fatalf("expected star redundancy of 0, got %d", r)
+	}
+
+	// connecting two of the leaves makes both of them redundant
+	conns = append(conns, testGraphConns(star, [2]int{1, 2})...)
+	if r := LocalRedundancy(star[0].ID(), conns); r != 2 {
+		t.Fatalf("expected star redundancy of 2, got %d", r)
+	}
+}
+
+func TestReachabilityMatrix(t *testing.T) {
+	// nodes 0-2 and nodes 3-4 form two separate components
+	nodes := testGraphNodes(5)
+	conns := testGraphConns(nodes, [2]int{0, 1}, [2]int{1, 2}, [2]int{3, 4})
+
+	components := Components(nodes, conns)
+	if len(components) != 2 {
+		t.Fatalf("expected 2 components, got %d", len(components))
+	}
+	if len(components[0]) != 3 || len(components[1]) != 2 {
+		t.Fatalf("expected components of size 3 and 2, got %d and %d", len(components[0]), len(components[1]))
+	}
+
+	reachable := ReachabilityMatrix(nodes, conns)
+	component := []int{0, 0, 0, 1, 1}
+	for i, one := range nodes {
+		for j, other := range nodes {
+			if expected := component[i] == component[j]; reachable[one.ID()][other.ID()] != expected {
+				t.Fatalf("expected reachability of node %d from node %d to be %t", j, i, expected)
+			}
+		}
+	}
+}
+
+func TestClusteringCoefficient(t *testing.T) {
+	// every pair of peers in a full mesh is connected
+	mesh := testGraphNodes(5)
+	if c := ClusteringCoefficient(mesh, testMeshConns(mesh)); c != 1 {
+		t.Fatalf("expected full mesh clustering of 1, got %f", c)
+	}
+
+	// no leaves of a star are connected, and leaves only have one peer
+	star := testGraphNodes(5)
+	conns := testGraphConns(star, [2]int{0, 1}, [2]int{0, 2}, [2]int{0, 3}, [2]int{0, 4})
+	if c := ClusteringCoefficient(star, conns); c != 0 {
+		t.Fatalf("expected star clustering of 0, got %f", c)
+	}
+
+	// a triangle and an isolated node average to 3/4
+	nodes := testGraphNodes(4)
+	conns = testGraphConns(nodes, [2]int{0, 1}, [2]int{1, 2}, [2]int{2, 0})
+	if c := ClusteringCoefficient(nodes, conns); c != 0.75 {
+		t.Fatalf("expected clustering of 0.75, got %f", c)
+	}
+}
@@ -21,13 +21,16 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/ethereum/go-ethereum/event"
 	"github.com/ethereum/go-ethereum/p2p"
@@ -85,6 +88,90 @@ func (c *Client) LoadSnapshot(snap *Snapshot) error {
 	return c.Post("/snapshot", snap, nil)
 }
 
+// GetState returns which nodes are up and down and which connections are
+// active
+func (c *Client) GetState() (*NetworkState, error) {
+	state := &NetworkState{}
+	return state, c.Get("/state", state)
+}
+
+// GetStats returns statistics about the network's topology and churn
+func (c *Client) GetStats() (*Stats, error) {
+	stats := &Stats{}
+	return stats, c.Get("/stats", stats)
+}
+
+// StartMocker starts a mocker with the given config
+func (c *Client) StartMocker(conf *MockerConfig) error {
+	return c.Post("/mocker/start", conf, nil)
+}
+
+// StopMocker stops the running mocker
+func (c *Client) StopMocker() error {
+	return c.Post("/mocker/stop", nil, nil)
+}
+
+// PauseMocker pauses the running mocker
+func (c *Client) PauseMocker() error {
+	return c.Post("/mocker/pause", nil, nil)
+}
+
+// ResumeMocker resumes the paused mocker
+func (c *Client) ResumeMocker() error {
+	return c.Post("/mocker/resume", nil, nil)
+}
+
+// Partition splits the network into two groups of nodes, given by ID or
+// name, which can't connect to each other until the partition is healed
+func (c *Client) Partition(groupA, groupB []string) error {
+	return c.Post("/partition", &partitionRequest{GroupA: groupA, GroupB: groupB}, nil)
+}
+
+// Heal removes the network partition
+func (c *Client) Heal() error {
+	return c.Delete("/partition")
+}
+
+// partitionRequest is the request body of POST /partition
+type partitionRequest struct {
+	GroupA []string `json:"group_a"`
+	GroupB []string `json:"group_b"`
+}
+
+// GetMockerList returns the types of mocker which can be started
+func (c *Client) GetMockerList() ([]string, error) {
+	var list []string
+	return list, c.Get("/mocker", &list)
+}
+
+// CreateMocker starts a mocker with the given config alongside any other
+// running mockers, returning its ID
+func (c *Client) CreateMocker(conf *MockerConfig) (*MockerInfo, error) {
+	info := &MockerInfo{}
+	return info, c.Post("/mockers", conf, info)
+}
+
+// GetRunningMockers returns the mockers which are currently running
+func (c *Client) GetRunningMockers() ([]*MockerInfo, error) {
+	var mockers []*MockerInfo
+	return mockers, c.Get("/mockers", &mockers)
+}
+
+// DeleteMocker stops the running mocker with the given ID
+func (c *Client) DeleteMocker(id uint64) error {
+	return c.Delete(fmt.Sprintf("/mockers/%d", id))
+}
+
+// PauseRunningMocker pauses the running mocker with the given ID
+func (c *Client) PauseRunningMocker(id uint64) error {
+	return c.Post(fmt.Sprintf("/mockers/%d/pause", id), nil, nil)
+}
+
+// ResumeRunningMocker resumes the paused mocker with the given ID
+func (c *Client) ResumeRunningMocker(id uint64) error {
+	return c.Post(fmt.Sprintf("/mockers/%d/resume", id), nil, nil)
+}
+
 // SubscribeOpts is a collection of options to use when subscribing to network
 // events
 type SubscribeOpts struct {
@@ -167,6 +254,56 @@ func (c *Client) SubscribeNetwork(events chan *Event, opts SubscribeOpts) (event
 	return event.NewSubscription(producer), nil
 }
 
+// SubscribeNetworkWS subscribes to network events which are sent from the
+// server over a WebSocket connection, optionally only receiving events of
+// the given types
+func (c *Client) SubscribeNetworkWS(events chan *Event, types ...EventType) (event.Subscription, error) {
+	url := strings.Replace(c.URL, "http", "ws", 1) + "/events/ws"
+	if len(types) > 0 {
+		params := make([]string, len(types))
+		for i, typ := range types {
+			params[i] = string(typ)
+		}
+		url += "?types=" + strings.Join(params, ",")
+	}
+	conn, err := websocket.Dial(url, "", c.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	// define a producer function to pass to event.Subscription
+	// which reads events from the connection and sends them to the
+	// events channel
+	producer := func(stop <-chan struct{}) error {
+		defer conn.Close()
+
+		errC := make(chan error, 1)
+		go func() {
+			for {
+				event := &Event{}
+				if err := websocket.JSON.Receive(conn, event); err != nil {
+					errC <- err
+					return
+				}
+				select {
+				case events <- event:
+				case <-stop:
+					return
+				}
+			}
+		}()
+
+		select {
+		case err := <-errC:
+			return err
+		case <-stop:
+			return nil
+		}
+	}
+
+	return event.NewSubscription(producer), nil
+}
+
 // GetNodes returns all nodes which exist in the network
 func (c *Client) GetNodes() ([]*p2p.NodeInfo, error) {
 	var nodes []*p2p.NodeInfo
@@ -195,6 +332,21 @@ func (c *Client) StopNode(nodeID string) error {
 	return c.Post(fmt.Sprintf("/nodes/%s/stop", nodeID), nil, nil)
 }
 
+// CrashNode stops a node abruptly, without it disconnecting from its peers
+func (c *Client) CrashNode(nodeID string) error {
+	return c.Post(fmt.Sprintf("/nodes/%s/crash", nodeID), nil, nil)
+}
+
+// UpgradeNode changes the version of a node, restarting it if it is up
+func (c *Client) UpgradeNode(nodeID, version string) error {
+	return c.Post(fmt.Sprintf("/nodes/%s/upgrade", nodeID), &upgradeRequest{Version: version}, nil)
+}
+
+// upgradeRequest is the request body of POST /nodes/:nodeid/upgrade
+type upgradeRequest struct {
+	Version string `json:"version"`
+}
+
 // ConnectNode connects a node to a peer node
 func (c *Client) ConnectNode(nodeID, peerID string) error {
 	return c.Post(fmt.Sprintf("/nodes/%s/conn/%s", nodeID, peerID), nil, nil)
@@ -266,8 +418,28 @@ func (c *Client) Send(method, path string, in, out interface{}) error {
 type Server struct {
 	router     *httprouter.Router
 	network    *Network
-	mockerStop chan struct{} // when set, stops the current mocker
-	mockerMtx  sync.Mutex    // synchronises access to the mockerStop field
+	mockerStop chan struct{}             // when set, stops the current mocker
+	mockers    map[uint64]*runningMocker // mockers started with POST /mockers
+	mockerID   uint64                    // ID of the last mocker started with POST /mockers
+	mockerMtx  sync.Mutex                // synchronises access to the mocker fields
+	stats      *StatsCollector           // started by the first GET /stats
+	statsMtx   sync.Mutex                // synchronises access to stats
+}
+
+// statsWindow is the window the stats served by GET /stats average the
+// connection churn over
+const statsWindow = 10 * time.Second
+
+// MockerInfo describes a mocker started with POST /mockers
+type MockerInfo struct {
+	ID     uint64        `json:"id"`
+	Config *MockerConfig `json:"config"`
+	Paused bool          `json:"paused,omitempty"`
+}
+
+type runningMocker struct {
+	info MockerInfo
+	quit chan struct{}
 }
 
 // NewServer returns a new simulation API server
@@ -275,6 +447,7 @@ func NewServer(network *Network) *Server {
 	s := &Server{
 		router:  httprouter.New(),
 		network: network,
+		mockers: make(map[uint64]*runningMocker),
 	}
 
 	s.OPTIONS("/", s.Options)
@@ -283,16 +456,30 @@ func NewServer(network *Network) *Server {
 	s.POST("/stop", s.StopNetwork)
 	s.POST("/mocker/start", s.StartMocker)
 	s.POST("/mocker/stop", s.StopMocker)
+	s.POST("/mocker/pause", s.PauseMocker)
+	s.POST("/mocker/resume", s.ResumeMocker)
 	s.GET("/mocker", s.GetMockers)
+	s.POST("/mockers", s.CreateMocker)
+	s.GET("/mockers", s.GetRunningMockers)
+	s.DELETE("/mockers/:mockerid", s.DeleteMocker)
+	s.POST("/mockers/:mockerid/pause", s.PauseRunningMocker)
+	s.POST("/mockers/:mockerid/resume", s.ResumeRunningMocker)
 	s.POST("/reset", s.ResetNetwork)
+	s.POST("/partition", s.PartitionNetwork)
+	s.DELETE("/partition", s.HealNetwork)
 	s.GET("/events", s.StreamNetworkEvents)
+	s.GET("/events/ws", s.StreamNetworkEventsWS)
 	s.GET("/snapshot", s.CreateSnapshot)
+	s.GET("/state", s.GetState)
+	s.GET("/stats", s.GetStats)
 	s.POST("/snapshot", s.LoadSnapshot)
 	s.POST("/nodes", s.CreateNode)
 	s.GET("/nodes", s.GetNodes)
 	s.GET("/nodes/:nodeid", s.GetNode)
 	s.POST("/nodes/:nodeid/start", s.StartNode)
 	s.POST("/nodes/:nodeid/stop", s.StopNode)
+	s.POST("/nodes/:nodeid/crash", s.CrashNode)
+	s.POST("/nodes/:nodeid/upgrade", s.UpgradeNode)
 	s.POST("/nodes/:nodeid/conn/:peerid", s.ConnectNode)
 	s.DELETE("/nodes/:nodeid/conn/:peerid", s.DisconnectNode)
 	s.GET("/nodes/:nodeid/rpc", s.NodeRPC)
@@ -333,23 +520,50 @@ func (s *Server) StartMocker(w http.ResponseWriter, req *http.Request) {
 		http.Error(w, "mocker already running", http.StatusInternalServerError)
 		return
 	}
-	mockerType := req.FormValue("mocker-type")
-	mockerFn := LookupMocker(mockerType)
-	if mockerFn == nil {
-		http.Error(w, fmt.Sprintf("unknown mocker type %q", mockerType), http.StatusBadRequest)
-		return
-	}
-	nodeCount, err := strconv.Atoi(req.FormValue("node-count"))
+	conf, err := mockerConfigFromRequest(req)
 	if err != nil {
-		http.Error(w, "invalid node-count provided", http.StatusBadRequest)
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	s.mockerStop = make(chan struct{})
-	go mockerFn(s.network, s.mockerStop, nodeCount)
+	stop := make(chan struct{})
+	s.mockerStop = stop
+	go LookupMocker(conf.Type)(s.network, stop, conf)
+	if conf.Converge != nil {
+		go s.stopMockerOnConvergence(conf.Converge, stop, func() {
+			if s.mockerStop == stop {
+				close(stop)
+				s.mockerStop = nil
+			}
+		})
+	}
 
 	w.WriteHeader(http.StatusOK)
 }
 
+// mockerConfigFromRequest reads a mocker config either from a JSON request
+// body or from the "mocker-type", "node-count" and "seed" form values
+func mockerConfigFromRequest(req *http.Request) (*MockerConfig, error) {
+	if strings.HasPrefix(req.Header.Get("Content-Type"), "application/json") {
+		return ParseMockerConfig(req.Body)
+	}
+	conf := DefaultMockerConfig()
+	conf.Type = req.FormValue("mocker-type")
+	nodeCount, err := strconv.Atoi(req.FormValue("node-count"))
+	if err != nil {
+		return nil, errors.New("invalid node-count provided")
+	}
+	conf.NodeCount = nodeCount
+	if seed := req.FormValue("seed"); seed != "" {
+		if conf.Seed, err = strconv.ParseInt(seed, 10, 64); err != nil {
+			return nil, errors.New("invalid seed provided")
+		}
+	}
+	if err := conf.Validate(); err != nil {
+		return nil, err
+	}
+	return conf, nil
+}
+
 // StopMocker stops the mocker node simulation
 func (s *Server) StopMocker(w http.ResponseWriter, req *http.Request) {
 	s.mockerMtx.Lock()
@@ -364,6 +578,33 @@ func (s *Server) StopMocker(w http.ResponseWriter, req *http.Request) {
 	w.WriteHeader(http.StatusOK)
 }
 
+// PauseMocker pauses the mocker node simulation, leaving the network as it
+// is until the mocker is resumed
+func (s *Server) PauseMocker(w http.ResponseWriter, req *http.Request) {
+	s.mockerMtx.Lock()
+	defer s.mockerMtx.Unlock()
+	if s.mockerStop == nil {
+		http.Error(w, "mocker not running", http.StatusBadRequest)
+		return
+	}
+	PauseMocker(s.mockerStop)
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// ResumeMocker resumes the paused mocker node simulation
+func (s *Server) ResumeMocker(w http.ResponseWriter, req *http.Request) {
+	s.mockerMtx.Lock()
+	defer s.mockerMtx.Unlock()
+	if s.mockerStop == nil {
+		http.Error(w, "mocker not running", http.StatusBadRequest)
+		return
+	}
+	ResumeMocker(s.mockerStop)
+
+	w.WriteHeader(http.StatusOK)
+}
+
 // GetMockerList returns a list of available mockers
 func (s *Server) GetMockers(w http.ResponseWriter, req *http.Request) {
 
@@ -371,6 +612,171 @@ func (s *Server) GetMockers(w http.ResponseWriter, req *http.Request) {
 	s.JSON(w, http.StatusOK, list)
 }
 
+// CreateMocker starts a mocker using the config in the request body, which
+// runs alongside any other mockers until it is deleted
+func (s *Server) CreateMocker(w http.ResponseWriter, req *http.Request) {
+	conf, err := ParseMockerConfig(req.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.mockerMtx.Lock()
+	defer s.mockerMtx.Unlock()
+	s.mockerID++
+	mocker := &runningMocker{
+		info: MockerInfo{ID: s.mockerID, Config: conf},
+		quit: make(chan struct{}),
+	}
+	s.mockers[mocker.info.ID] = mocker
+	go func() {
+		// mockers which just build a topology return without being
+		// stopped, after which they are no longer running
+		LookupMocker(conf.Type)(s.network, mocker.quit, conf)
+		s.mockerMtx.Lock()
+		s.removeMocker(mocker)
+		s.mockerMtx.Unlock()
+	}()
+	if conf.Converge != nil {
+		go s.stopMockerOnConvergence(conf.Converge, mocker.quit, func() {
+			s.removeMocker(mocker)
+		})
+	}
+
+	s.JSON(w, http.StatusCreated, &mocker.info)
+}
+
+// removeMocker stops the given mocker and removes it from the running
+// mockers unless it has already been removed, and must be called with the
+// mocker lock held
+func (s *Server) removeMocker(mocker *runningMocker) {
+	if s.mockers[mocker.info.ID] != mocker {
+		return
+	}
+	close(mocker.quit)
+	delete(s.mockers, mocker.info.ID)
+}
+
+// stopMockerOnConvergence calls stop with the mocker lock held once the
+// network converges, unless the mocker is stopped first
+func (s *Server) stopMockerOnConvergence(conf *ConvergenceConfig, quit chan struct{}, stop func()) {
+	monitor := NewConvergenceMonitor(s.network, conf)
+	defer monitor.Close()
+	select {
+	case <-monitor.Converged():
+		s.mockerMtx.Lock()
+		stop()
+		s.mockerMtx.Unlock()
+	case <-quit:
+	}
+}
+
+// GetRunningMockers returns the mockers started with CreateMocker which have
+// not been deleted, in the order they were started
+func (s *Server) GetRunningMockers(w http.ResponseWriter, req *http.Request) {
+	s.mockerMtx.Lock()
+	defer s.mockerMtx.Unlock()
+	mockers := make([]*MockerInfo, 0, len(s.mockers))
+	for _, mocker := range s.mockers {
+		info := mocker.info
+		mockers = append(mockers, &info)
+	}
+	sort.Slice(mockers, func(i, j int) bool { return mockers[i].ID < mockers[j].ID })
+
+	s.JSON(w, http.StatusOK, mockers)
+}
+
+// DeleteMocker stops a mocker started with CreateMocker
+func (s *Server) DeleteMocker(w http.ResponseWriter, req *http.Request) {
+	id := req.Context().Value("mockerid").(uint64)
+	s.mockerMtx.Lock()
+	defer s.mockerMtx.Unlock()
+	mocker, ok := s.mockers[id]
+	if !ok {
+		http.NotFound(w, req)
+		return
+	}
+	s.removeMocker(mocker)
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// PauseRunningMocker pauses a mocker started with CreateMocker, leaving the
+// network as it is until the mocker is resumed
+func (s *Server) PauseRunningMocker(w http.ResponseWriter, req *http.Request) {
+	id := req.Context().Value("mockerid").(uint64)
+	s.mockerMtx.Lock()
+	defer s.mockerMtx.Unlock()
+	mocker, ok := s.mockers[id]
+	if !ok {
+		http.NotFound(w, req)
+		return
+	}
+	PauseMocker(mocker.quit)
+	mocker.info.Paused = true
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// ResumeRunningMocker resumes a mocker paused with PauseRunningMocker
+func (s *Server) ResumeRunningMocker(w http.ResponseWriter, req *http.Request) {
+	id := req.Context().Value("mockerid").(uint64)
+	s.mockerMtx.Lock()
+	defer s.mockerMtx.Unlock()
+	mocker, ok := s.mockers[id]
+	if !ok {
+		http.NotFound(w, req)
+		return
+	}
+	ResumeMocker(mocker.quit)
+	mocker.info.Paused = false
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// PartitionNetwork splits the network into the two groups of nodes in the
+// request body (see Network.Partition)
+func (s *Server) PartitionNetwork(w http.ResponseWriter, req *http.Request) {
+	var partition partitionRequest
+	if err := json.NewDecoder(req.Body).Decode(&partition); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	lookup := func(names []string) ([]discover.NodeID, error) {
+		ids := make([]discover.NodeID, len(names))
+		for i, name := range names {
+			node := s.lookupNode(name)
+			if node == nil {
+				return nil, fmt.Errorf("unknown node %q", name)
+			}
+			ids[i] = node.ID()
+		}
+		return ids, nil
+	}
+	groupA, err := lookup(partition.GroupA)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	groupB, err := lookup(partition.GroupB)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := s.network.Partition(groupA, groupB); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// HealNetwork removes the network partition
+func (s *Server) HealNetwork(w http.ResponseWriter, req *http.Request) {
+	s.network.Heal()
+
+	w.WriteHeader(http.StatusOK)
+}
+
 // ResetNetwork resets all properties of a network to its initial (empty) state
 func (s *Server) ResetNetwork(w http.ResponseWriter, req *http.Request) {
 	s.network.Reset()
@@ -472,6 +878,89 @@ func (s *Server) StreamNetworkEvents(w http.ResponseWriter, req *http.Request) {
 	}
 }
 
+// StreamNetworkEventsWS streams network events as JSON WebSocket messages.
+//
+// The "types" query parameter is an optional comma-separated list of event
+// types (node, conn, msg, upgrade or converged) to send, all other events
+// being dropped.
+// Message events are very frequent so, like StreamNetworkEvents, they are
+// only sent if they match the "filter" query parameter or, if no filter is
+// given, if the msg type is explicitly requested.
+func (s *Server) StreamNetworkEventsWS(w http.ResponseWriter, req *http.Request) {
+	types, err := parseEventTypes(req.URL.Query().Get("types"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var filters MsgFilters
+	if filterParam := req.URL.Query().Get("filter"); filterParam != "" {
+		filters, err = NewMsgFilters(filterParam)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	send := func(event *Event) bool {
+		if len(types) > 0 && !types[event.Type] {
+			return false
+		}
+		if event.Msg != nil {
+			if filters != nil {
+				return filters.Match(event.Msg)
+			}
+			return types[EventTypeMsg]
+		}
+		return true
+	}
+
+	// subscribe before the handshake so no events are missed once the
+	// client is connected
+	events := make(chan *Event)
+	sub := s.network.events.Subscribe(events)
+	defer sub.Unsubscribe()
+
+	handler := func(conn *websocket.Conn) {
+		// stop the stream if the client goes away
+		clientGone := make(chan struct{})
+		go func() {
+			io.Copy(ioutil.Discard, conn)
+			close(clientGone)
+		}()
+		for {
+			select {
+			case event := <-events:
+				if !send(event) {
+					continue
+				}
+				if err := websocket.JSON.Send(conn, event); err != nil {
+					return
+				}
+			case <-clientGone:
+				return
+			}
+		}
+	}
+
+	websocket.Server{Handler: handler}.ServeHTTP(w, req)
+}
+
+// parseEventTypes parses a comma-separated list of event types
+func parseEventTypes(param string) (map[EventType]bool, error) {
+	types := make(map[EventType]bool)
+	if param == "" {
+		return types, nil
+	}
+	for _, typ := range strings.Split(param, ",") {
+		switch t := EventType(typ); t {
+		case EventTypeNode, EventTypeConn, EventTypeMsg, EventTypeUpgrade, EventTypeConverged:
+			types[t] = true
+		default:
+			return nil, fmt.Errorf("invalid event type %q", typ)
+		}
+	}
+	return types, nil
+}
+
 // NewMsgFilters constructs a collection of message filters from a URL query
 // parameter.
 //
@@ -532,15 +1021,66 @@ type MsgFilter struct {
 	Code int64
 }
 
-// CreateSnapshot creates a network snapshot
+// snapshotContentTypes are the content types of the formats supported by
+// GET /snapshot?format=
+var snapshotContentTypes = map[string]string{
+	"json":    "application/json",
+	"dot":     "text/vnd.graphviz",
+	"graphml": "application/graphml+xml",
+	"d3":      "application/json",
+}
+
+// CreateSnapshot creates a network snapshot, written in the format given by
+// the optional "format" query parameter (see WriteSnapshot)
 func (s *Server) CreateSnapshot(w http.ResponseWriter, req *http.Request) {
+	format := req.URL.Query().Get("format")
+	if format == "" {
+		format = "json"
+	}
+	contentType, ok := snapshotContentTypes[format]
+	if !ok {
+		http.Error(w, fmt.Sprintf("unknown snapshot format %q", format), http.StatusBadRequest)
+		return
+	}
+
 	snap, err := s.network.Snapshot()
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	s.JSON(w, http.StatusOK, snap)
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(http.StatusOK)
+	WriteSnapshot(w, snap, format)
+}
+
+// GetState returns which nodes are up and down and which connections are
+// active
+func (s *Server) GetState(w http.ResponseWriter, req *http.Request) {
+	s.JSON(w, http.StatusOK, s.network.State())
+}
+
+// GetStats returns statistics about the network's topology and churn, the
+// churn being measured from the first call
+func (s *Server) GetStats(w http.ResponseWriter, req *http.Request) {
+	s.statsMtx.Lock()
+	if s.stats == nil {
+		s.stats = NewStatsCollector(s.network, statsWindow)
+	}
+	stats := s.stats
+	s.statsMtx.Unlock()
+	s.JSON(w, http.StatusOK, stats.Stats())
+}
+
+// Close stops the stats collector started by GET /stats, a later GET /stats
+// starts a new one
+func (s *Server) Close() {
+	s.statsMtx.Lock()
+	defer s.statsMtx.Unlock()
+	if s.stats != nil {
+		s.stats.Close()
+		s.stats = nil
+	}
 }
 
 // LoadSnapshot loads a snapshot into the network
@@ -561,8 +1101,12 @@ func (s *Server) LoadSnapshot(w http.ResponseWriter, req *http.Request) {
 
 // CreateNode creates a node in the network using the given configuration
 func (s *Server) CreateNode(w http.ResponseWriter, req *http.Request) {
-	config := adapters.RandomNodeConfig()
-	err := json.NewDecoder(req.Body).Decode(config)
+	config, err := adapters.GenerateNodeConfig()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	err = json.NewDecoder(req.Body).Decode(config)
 	if err != nil && err != io.EOF {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
@@ -620,6 +1164,35 @@ func (s *Server) StopNode(w http.ResponseWriter, req *http.Request) {
 	s.JSON(w, http.StatusOK, node.NodeInfo())
 }
 
+// CrashNode stops a node abruptly, without it disconnecting from its peers
+func (s *Server) CrashNode(w http.ResponseWriter, req *http.Request) {
+	node := req.Context().Value("node").(*Node)
+
+	if err := s.network.Crash(node.ID()); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	s.JSON(w, http.StatusOK, node.NodeInfo())
+}
+
+// UpgradeNode changes the version of a node, restarting it if it is up
+func (s *Server) UpgradeNode(w http.ResponseWriter, req *http.Request) {
+	node := req.Context().Value("node").(*Node)
+
+	var upgrade upgradeRequest
+	if err := json.NewDecoder(req.Body).Decode(&upgrade); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := s.network.Upgrade(node.ID(), upgrade.Version); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	s.JSON(w, http.StatusOK, node.NodeInfo())
+}
+
 // ConnectNode connects a node to a peer node
 func (s *Server) ConnectNode(w http.ResponseWriter, req *http.Request) {
 	node := req.Context().Value("node").(*Node)
@@ -698,6 +1271,15 @@ func (s *Server) JSON(w http.ResponseWriter, status int, data interface{}) {
 	json.NewEncoder(w).Encode(data)
 }
 
+// lookupNode returns the node with the given ID or name, or nil if there is
+// no such node
+func (s *Server) lookupNode(id string) *Node {
+	if nodeID, err := discover.HexID(id); err == nil {
+		return s.network.GetNode(nodeID)
+	}
+	return s.network.GetNodeByName(id)
+}
+
 // wrapHandler returns a httprouter.Handle which wraps a http.HandlerFunc by
 // populating request.Context with any objects from the URL params
 func (s *Server) wrapHandler(handler http.HandlerFunc) httprouter.Handle {
@@ -708,12 +1290,7 @@ func (s *Server) wrapHandler(handler http.HandlerFunc) httprouter.Handle {
 		ctx := context.Background()
 
 		if id := params.ByName("nodeid"); id != "" {
-			var node *Node
-			if nodeID, err := discover.HexID(id); err == nil {
-				node = s.network.GetNode(nodeID)
-			} else {
-				node = s.network.GetNodeByName(id)
-			}
+			node := s.lookupNode(id)
 			if node == nil {
 				http.NotFound(w, req)
 				return
@@ -722,12 +1299,7 @@ func (s *Server) wrapHandler(handler http.HandlerFunc) httprouter.Handle {
 		}
 
 		if id := params.ByName("peerid"); id != "" {
-			var peer *Node
-			if peerID, err := discover.HexID(id); err == nil {
-				peer = s.network.GetNode(peerID)
-			} else {
-				peer = s.network.GetNodeByName(id)
-			}
+			peer := s.lookupNode(id)
 			if peer == nil {
 				http.NotFound(w, req)
 				return
@@ -735,6 +1307,15 @@ func (s *Server) wrapHandler(handler http.HandlerFunc) httprouter.Handle {
 			ctx = context.WithValue(ctx, "peer", peer)
 		}
 
+		if id := params.ByName("mockerid"); id != "" {
+			mockerID, err := strconv.ParseUint(id, 10, 64)
+			if err != nil {
+				http.NotFound(w, req)
+				return
+			}
+			ctx = context.WithValue(ctx, "mockerid", mockerID)
+		}
+
 		handler(w, req.WithContext(ctx))
 	}
 }
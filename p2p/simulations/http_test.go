@@ -140,18 +140,20 @@ func (t *testService) RunTest(p *p2p.Peer, rw p2p.MsgReadWriter) error {
 
 	// perform three handshakes with three different message codes,
 	// used to test message sending and filtering
-	if err := t.handshake(rw, 2); err != nil {
-		return err
-	}
-	if err := t.handshake(rw, 1); err != nil {
-		return err
+	err := t.handshake(rw, 2)
+	if err == nil {
+		err = t.handshake(rw, 1)
 	}
-	if err := t.handshake(rw, 0); err != nil {
-		return err
+	if err == nil {
+		err = t.handshake(rw, 0)
 	}
 
-	// close the testReady channel so that other protocols can run
+	// close the testReady channel so that other protocols can run, even
+	// if the handshakes failed so that they don't wait for ever
 	close(peer.testReady)
+	if err != nil {
+		return err
+	}
 
 	// track the peer
 	atomic.AddInt64(&t.peerCount, 1)
@@ -173,12 +175,13 @@ func (t *testService) RunDum(p *p2p.Peer, rw p2p.MsgReadWriter) error {
 	<-peer.testReady
 
 	// perform a handshake
-	if err := t.handshake(rw, 0); err != nil {
-		return err
-	}
+	err := t.handshake(rw, 0)
 
 	// close the dumReady channel so that other protocols can run
 	close(peer.dumReady)
+	if err != nil {
+		return err
+	}
 
 	// block until the peer is dropped
 	for {
@@ -341,6 +344,26 @@ func TestHTTPNetwork(t *testing.T) {
 		x.nodeEvent(nodeIDs[1], true),
 		x.connEvent(nodeIDs[0], nodeIDs[1], true),
 	)
+
+	// check the network state contains the up nodes and active conn
+	state, err := client.GetState()
+	if err != nil {
+		t.Fatalf("error getting network state: %s", err)
+	}
+	if len(state.UpNodes) != 2 || len(state.DownNodes) != 0 {
+		t.Fatalf("expected 2 up nodes and 0 down nodes, got %d and %d", len(state.UpNodes), len(state.DownNodes))
+	}
+	for i, id := range state.UpNodes {
+		if id.String() != nodeIDs[i] {
+			t.Fatalf("expected up node %d to have ID %q, got %q", i, nodeIDs[i], id)
+		}
+	}
+	if len(state.ActiveConns) != 1 {
+		t.Fatalf("expected 1 active conn, got %d", len(state.ActiveConns))
+	}
+	if conn := state.ActiveConns[0]; conn.One.String() != nodeIDs[0] || conn.Other.String() != nodeIDs[1] {
+		t.Fatalf("expected active conn between %q and %q, got %s", nodeIDs[0], nodeIDs[1], conn)
+	}
 }
 
 func startTestNetwork(t *testing.T, client *Client) []string {
@@ -442,6 +465,10 @@ loop:
 			if event.Msg == nil {
 				t.Fatal("expected event.Msg to be set")
 			}
+			// the test protocols send RLP encoded empty structs
+			if event.Msg.Size != 1 {
+				t.Fatalf("expected event.Msg.Size to be 1, got %d", event.Msg.Size)
+			}
 			filter := MsgFilter{
 				Proto: event.Msg.Protocol,
 				Code:  int64(event.Msg.Code),
@@ -821,3 +848,293 @@ func TestMsgFilterFailBadParams(t *testing.T) {
 		t.Fatalf("expected event subscription to fail but succeeded!")
 	}
 }
+
+// TestHTTPPartition tests partitioning and healing the network using the
+// HTTP API
+func TestHTTPPartition(t *testing.T) {
+	network, s := testHTTPServer(t)
+	defer s.Close()
+	client := NewClient(s.URL)
+
+	nodes := make([]*Node, 3)
+	for i := range nodes {
+		node, err := network.NewNode()
+		if err != nil {
+			t.Fatalf("error creating node: %s", err)
+		}
+		nodes[i] = node
+	}
+
+	partitioned := func(one, other discover.NodeID) bool {
+		network.lock.RLock()
+		defer network.lock.RUnlock()
+		return network.partitioned(one, other)
+	}
+
+	// nodes can be given by name or ID
+	if err := client.Partition([]string{nodes[0].Config.Name}, []string{nodes[1].ID().String()}); err != nil {
+		t.Fatalf("error partitioning network: %s", err)
+	}
+	if !partitioned(nodes[0].ID(), nodes[1].ID()) {
+		t.Fatal("expected nodes 0 and 1 to be partitioned")
+	}
+	if partitioned(nodes[0].ID(), nodes[2].ID()) {
+		t.Fatal("expected nodes 0 and 2 not to be partitioned")
+	}
+	if err := client.Partition([]string{nodes[0].Config.Name}, []string{"unknown"}); err == nil {
+		t.Fatal("expected an error partitioning an unknown node")
+	}
+
+	if err := client.Heal(); err != nil {
+		t.Fatalf("error healing network: %s", err)
+	}
+	if partitioned(nodes[0].ID(), nodes[1].ID()) {
+		t.Fatal("expected the partition to be healed")
+	}
+}
+
+// TestHTTPMockers tests starting, listing and stopping mockers using the
+// HTTP API
+func TestHTTPMockers(t *testing.T) {
+	started := make(chan int, 2)
+	stopped := make(chan int, 2)
+	err := RegisterMocker("testHTTPMockers", func(net *Network, quit chan struct{}, conf *MockerConfig) {
+		started <- conf.NodeCount
+		<-quit
+		stopped <- conf.NodeCount
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unregisterMocker("testHTTPMockers")
+
+	_, s := testHTTPServer(t)
+	defer s.Close()
+	client := NewClient(s.URL)
+
+	types, err := client.GetMockerList()
+	if err != nil {
+		t.Fatalf("error getting mocker types: %s", err)
+	}
+	var found bool
+	for _, name := range types {
+		found = found || name == "testHTTPMockers"
+	}
+	if !found {
+		t.Fatalf("expected mocker types to include testHTTPMockers, got %v", types)
+	}
+
+	// start two mockers and check they are both running
+	var ids []uint64
+	for _, nodeCount := range []int{2, 3} {
+		info, err := client.CreateMocker(&MockerConfig{Type: "testHTTPMockers", NodeCount: nodeCount})
+		if err != nil {
+			t.Fatalf("error creating mocker: %s", err)
+		}
+		select {
+		case n := <-started:
+			if n != nodeCount {
+				t.Fatalf("expected mocker to be started with %d nodes, got %d", nodeCount, n)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for mocker to start")
+		}
+		ids = append(ids, info.ID)
+	}
+	mockers, err := client.GetRunningMockers()
+	if err != nil {
+		t.Fatalf("error getting mockers: %s", err)
+	}
+	if len(mockers) != 2 || mockers[0].ID != ids[0] || mockers[1].ID != ids[1] {
+		t.Fatalf("expected running mockers %v, got %v", ids, mockers)
+	}
+	if mockers[1].Config.NodeCount != 3 {
+		t.Fatalf("expected mocker to have node count 3, got %d", mockers[1].Config.NodeCount)
+	}
+
+	// pause and resume the second mocker
+	if err := client.PauseRunningMocker(ids[1]); err != nil {
+		t.Fatalf("error pausing mocker: %s", err)
+	}
+	mockers, err = client.GetRunningMockers()
+	if err != nil {
+		t.Fatalf("error getting mockers: %s", err)
+	}
+	if mockers[0].Paused || !mockers[1].Paused {
+		t.Fatalf("expected only the second mocker to be paused, got %v and %v", mockers[0].Paused, mockers[1].Paused)
+	}
+	if err := client.ResumeRunningMocker(ids[1]); err != nil {
+		t.Fatalf("error resuming mocker: %s", err)
+	}
+	if err := client.PauseRunningMocker(ids[1] + 1); err == nil {
+		t.Fatal("expected an error pausing an unknown mocker")
+	}
+
+	// stop the first mocker and check only the second is still running
+	if err := client.DeleteMocker(ids[0]); err != nil {
+		t.Fatalf("error deleting mocker: %s", err)
+	}
+	select {
+	case n := <-stopped:
+		if n != 2 {
+			t.Fatalf("expected the mocker with 2 nodes to be stopped, got %d", n)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for mocker to stop")
+	}
+	mockers, err = client.GetRunningMockers()
+	if err != nil {
+		t.Fatalf("error getting mockers: %s", err)
+	}
+	if len(mockers) != 1 || mockers[0].ID != ids[1] {
+		t.Fatalf("expected running mockers [%d], got %v", ids[1], mockers)
+	}
+	if err := client.DeleteMocker(ids[0]); err == nil {
+		t.Fatal("expected an error deleting a stopped mocker")
+	}
+	if err := client.DeleteMocker(ids[1]); err != nil {
+		t.Fatalf("error deleting mocker: %s", err)
+	}
+	<-stopped
+
+	// a mocker which returns by itself is no longer running
+	err = RegisterMocker("testHTTPMockersOneShot", func(net *Network, quit chan struct{}, conf *MockerConfig) {
+		started <- conf.NodeCount
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unregisterMocker("testHTTPMockersOneShot")
+	if _, err := client.CreateMocker(&MockerConfig{Type: "testHTTPMockersOneShot", NodeCount: 2}); err != nil {
+		t.Fatalf("error creating mocker: %s", err)
+	}
+	<-started
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		mockers, err = client.GetRunningMockers()
+		if err != nil {
+			t.Fatalf("error getting mockers: %s", err)
+		}
+		if len(mockers) == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the mocker to be removed once it returned, got %v", mockers)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// a mocker with no convergence targets is stopped on the first check
+	conf := &MockerConfig{
+		Type:      "testHTTPMockers",
+		NodeCount: 4,
+		Converge:  &ConvergenceConfig{Interval: 10 * time.Millisecond},
+	}
+	if _, err := client.CreateMocker(conf); err != nil {
+		t.Fatalf("error creating mocker: %s", err)
+	}
+	<-started
+	select {
+	case n := <-stopped:
+		if n != 4 {
+			t.Fatalf("expected the mocker with 4 nodes to be stopped, got %d", n)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for mocker to converge")
+	}
+	mockers, err = client.GetRunningMockers()
+	if err != nil {
+		t.Fatalf("error getting mockers: %s", err)
+	}
+	if len(mockers) != 0 {
+		t.Fatalf("expected no running mockers, got %v", mockers)
+	}
+
+	// invalid configs should be rejected
+	if _, err := client.CreateMocker(&MockerConfig{Type: "unknown", NodeCount: 2}); err == nil {
+		t.Fatal("expected an error creating a mocker with an unknown type")
+	}
+}
+
+// TestHTTPWebSocketEvents tests streaming network events over a WebSocket
+// connection, filtered by event type
+func TestHTTPWebSocketEvents(t *testing.T) {
+	network, s := testHTTPServer(t)
+	defer s.Close()
+	client := NewClient(s.URL)
+
+	events := make(chan *Event, 10)
+	sub, err := client.SubscribeNetworkWS(events, EventTypeConn, EventTypeMsg)
+	if err != nil {
+		t.Fatalf("error subscribing to network events: %s", err)
+	}
+	defer sub.Unsubscribe()
+
+	one := &Node{Config: adapters.RandomNodeConfig(), Up: true}
+	other := &Node{Config: adapters.RandomNodeConfig(), Up: true}
+	network.events.Send(ControlEvent(one))
+	network.events.Send(ControlEvent(&Conn{One: one.ID(), Other: other.ID(), Up: true}))
+	network.events.Send(ControlEvent(&Msg{One: one.ID(), Other: other.ID(), Protocol: "test", Code: 1}))
+
+	// the node event should be dropped
+	for _, expected := range []EventType{EventTypeConn, EventTypeMsg} {
+		select {
+		case event := <-events:
+			if event.Type != expected {
+				t.Fatalf("expected %q event, got %q", expected, event.Type)
+			}
+		case err := <-sub.Err():
+			t.Fatalf("error receiving network events: %s", err)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for %q event", expected)
+		}
+	}
+
+	if _, err := client.SubscribeNetworkWS(events, "peer"); err == nil {
+		t.Fatal("expected an error subscribing to an invalid event type")
+	}
+}
+
+// TestHTTPStats tests getting network statistics over the HTTP API and that
+// closing the server stops the collector GET /stats started
+func TestHTTPStats(t *testing.T) {
+	network := NewNetwork(adapters.NewSimAdapter(testServices), &NetworkConfig{
+		DefaultService: "test",
+	})
+	defer network.Shutdown()
+	server := NewServer(network)
+	s := httptest.NewServer(server)
+	defer s.Close()
+	client := NewClient(s.URL)
+
+	for i := 0; i < 2; i++ {
+		node, err := client.CreateNode(nil)
+		if err != nil {
+			t.Fatalf("error creating node: %s", err)
+		}
+		if i == 0 {
+			if err := client.StartNode(node.ID); err != nil {
+				t.Fatalf("error starting node: %s", err)
+			}
+		}
+	}
+	stats, err := client.GetStats()
+	if err != nil {
+		t.Fatalf("error getting stats: %s", err)
+	}
+	if stats.Nodes != 2 || stats.UpNodes != 1 {
+		t.Fatalf("expected 1 of 2 nodes up, got %d of %d", stats.UpNodes, stats.Nodes)
+	}
+
+	collector := server.stats
+	server.Close()
+	select {
+	case <-collector.done:
+	default:
+		t.Fatal("expected closing the server to stop the stats collector")
+	}
+	if _, err := client.GetStats(); err != nil {
+		t.Fatalf("error getting stats after closing the server: %s", err)
+	}
+	server.Close()
+}
@@ -0,0 +1,356 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package simulations
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/event"
+	"github.com/ethereum/go-ethereum/log"
+)
+
+// JournalEntry is a node or connection state change recorded by a Journal
+type JournalEntry struct {
+	// Time is the time the event happened relative to the start of the
+	// journal
+	Time time.Duration `json:"time"`
+
+	// Event is the recorded event, a control event which holds the node or
+	// connection involved and the state it ended up in
+	Event *Event `json:"event"`
+}
+
+// Journal records the changes to the state of the nodes and connections
+// reported by the events sent on a network event feed, so that they can
+// later be inspected, visualised or replayed to drive a network.
+//
+// A network sends control events when an action is requested and live
+// events once it has happened (e.g. a node started or a connection was
+// established), and the control events for connections hold the state from
+// before the action. So rather than recording the control events as they
+// are, the journal records a control event each time a node or connection
+// ends up in a different state: nodes are recorded when they are created
+// (as down), started and stopped, and connections from their live events.
+type Journal struct {
+	start   time.Time
+	entries []JournalEntry
+	mtx     sync.Mutex
+
+	// file and enc are set if the journal is persisted to disk
+	file *os.File
+	enc  *json.Encoder
+
+	sub  event.Subscription
+	done chan struct{}
+}
+
+// NewJournal returns a Journal which records the node and connection state
+// changes reported on the given feed until it is closed
+func NewJournal(feed *event.Feed) *Journal {
+	j := &Journal{
+		start: time.Now(),
+		done:  make(chan struct{}),
+	}
+	j.subscribe(feed)
+	return j
+}
+
+// OpenJournal returns a Journal like NewJournal which also appends each
+// entry it records to the file at the given path as a line of JSON, creating
+// the file if it doesn't exist.
+//
+// Entries already in the file are loaded into the journal and entries
+// recorded from now on are timestamped to follow on from the last of them,
+// so the file can be replayed as a single run.
+func OpenJournal(feed *event.Feed, path string) (*Journal, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := ReadJournal(file)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	j := &Journal{
+		start:   time.Now(),
+		entries: entries,
+		file:    file,
+		enc:     json.NewEncoder(file),
+		done:    make(chan struct{}),
+	}
+	if len(entries) > 0 {
+		j.start = j.start.Add(-entries[len(entries)-1].Time)
+	}
+	j.subscribe(feed)
+	return j, nil
+}
+
+// ReadJournal reads journal entries written by a Journal returned from
+// OpenJournal
+func ReadJournal(r io.Reader) ([]JournalEntry, error) {
+	var entries []JournalEntry
+	dec := json.NewDecoder(r)
+	for {
+		var entry JournalEntry
+		if err := dec.Decode(&entry); err == io.EOF {
+			return entries, nil
+		} else if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+}
+
+// WriteJournal writes journal entries as lines of JSON which can be read
+// back with ReadJournal, for example to store the result of CompactJournal
+func WriteJournal(w io.Writer, entries []JournalEntry) error {
+	enc := json.NewEncoder(w)
+	for i := range entries {
+		if err := enc.Encode(&entries[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CompactJournal compresses journal entries into at most one entry per node
+// and per connection in each epoch, so that long simulations produce
+// journals which are feasible to store and replay.
+//
+// Only the last entry for a node or connection in an epoch is kept, at its
+// original time, and it is dropped altogether if it is the same as the last
+// entry kept for that node or connection in an earlier epoch, since
+// replaying it then doesn't change the network (e.g. a node which was
+// stopped and restarted within an epoch).
+func CompactJournal(entries []JournalEntry, epoch time.Duration) []JournalEntry {
+	if epoch <= 0 {
+		return entries
+	}
+	var (
+		compacted []JournalEntry
+		kept      = make(map[string]*Event) // the last entry kept for each key
+	)
+	for start := 0; start < len(entries); {
+		// find the entries in the epoch of the first remaining entry
+		end := start
+		deadline := (entries[start].Time/epoch + 1) * epoch
+		for end < len(entries) && entries[end].Time < deadline {
+			end++
+		}
+		// keep the last entry for each node and connection
+		last := make(map[string]int)
+		for i := start; i < end; i++ {
+			last[journalKey(entries[i].Event)] = i
+		}
+		for i := start; i < end; i++ {
+			key := journalKey(entries[i].Event)
+			if last[key] != i {
+				continue
+			}
+			if prev, ok := kept[key]; ok && sameTransition(prev, entries[i].Event) {
+				continue
+			}
+			kept[key] = entries[i].Event
+			compacted = append(compacted, entries[i])
+		}
+		start = end
+	}
+	return compacted
+}
+
+// journalKey identifies the node or connection an event is about
+func journalKey(event *Event) string {
+	switch event.Type {
+	case EventTypeNode:
+		return "node:" + event.Node.ID().String()
+	case EventTypeConn:
+		return "conn:" + ConnLabel(event.Conn.One, event.Conn.Other)
+	default:
+		return fmt.Sprintf("%s:%p", event.Type, event)
+	}
+}
+
+// sameTransition returns whether two events about the same node or
+// connection move it to the same state
+func sameTransition(a, b *Event) bool {
+	switch a.Type {
+	case EventTypeNode:
+		return a.Node.Up == b.Node.Up
+	case EventTypeConn:
+		return a.Conn.Up == b.Conn.Up
+	default:
+		return false
+	}
+}
+
+func (j *Journal) subscribe(feed *event.Feed) {
+	events := make(chan *Event)
+	j.sub = feed.Subscribe(events)
+	go j.loop(events)
+}
+
+func (j *Journal) loop(events chan *Event) {
+	defer close(j.done)
+	// start from the state recorded by any entries loaded from a file
+	recorded := make(map[string]bool)
+	for _, entry := range j.Events() {
+		if up, ok := eventState(entry.Event); ok {
+			recorded[journalKey(entry.Event)] = up
+		}
+	}
+	for {
+		select {
+		case event := <-events:
+			if event = journalEvent(event, recorded); event != nil {
+				j.add(event)
+			}
+		case <-j.sub.Err():
+			return
+		}
+	}
+}
+
+// journalEvent returns a control event to record if the event changes the
+// state of a node or connection from the state last recorded for it, or
+// nil if it doesn't
+func journalEvent(event *Event, recorded map[string]bool) *Event {
+	up, ok := eventState(event)
+	if !ok {
+		return nil
+	}
+	// the control event is sent before connecting or disconnecting and
+	// holds the previous state
+	if event.Type == EventTypeConn && event.Control {
+		return nil
+	}
+	key := journalKey(event)
+	last, seen := recorded[key]
+	// nodes are recorded when they are created, whereas connections start
+	// down
+	if last == up && (seen || event.Type == EventTypeConn) {
+		return nil
+	}
+	recorded[key] = up
+	e := *event
+	e.Control = true
+	return &e
+}
+
+// eventState returns whether the node or connection a node or connection
+// event is about is up
+func eventState(event *Event) (up bool, ok bool) {
+	switch event.Type {
+	case EventTypeNode:
+		return event.Node.Up, true
+	case EventTypeConn:
+		return event.Conn.Up, true
+	default:
+		return false, false
+	}
+}
+
+// add records the event, timestamped relative to the start of the journal
+func (j *Journal) add(event *Event) {
+	j.mtx.Lock()
+	defer j.mtx.Unlock()
+	offset := event.Time.Sub(j.start)
+	if offset < 0 {
+		offset = 0
+	}
+	entry := JournalEntry{Time: offset, Event: event}
+	j.entries = append(j.entries, entry)
+	if j.enc != nil {
+		if err := j.enc.Encode(&entry); err != nil {
+			log.Error("error writing journal entry", "err", err)
+		}
+	}
+}
+
+// Close stops recording events and closes the journal's file if it has one
+func (j *Journal) Close() {
+	j.sub.Unsubscribe()
+	<-j.done
+	if j.file != nil {
+		if err := j.file.Close(); err != nil {
+			log.Error("error closing journal", "err", err)
+		}
+	}
+}
+
+// Events returns the recorded entries in the order they were received
+func (j *Journal) Events() []JournalEntry {
+	j.mtx.Lock()
+	defer j.mtx.Unlock()
+	entries := make([]JournalEntry, len(j.entries))
+	copy(entries, j.entries)
+	return entries
+}
+
+// ReplayJournal sends the events of the given journal entries on the feed,
+// preserving the relative time between them scaled by speed (so a speed of
+// 2 replays the events twice as fast as they were recorded).
+//
+// Each entry is sent at its scaled offset from the start of the replay
+// rather than after a delay from the previous entry, so entries are always
+// sent in order and those which are already due (e.g. because of a very
+// high speed) are sent immediately.
+//
+// The replayed events are copies of the recorded ones with new IDs,
+// timestamped with the time they are replayed. Sending them on a channel
+// passed to Network.Subscribe moves each node and connection to the state it
+// was recorded in, creating nodes as they are first seen, so a journal
+// recorded from one network drives a fresh network through the same changes.
+func ReplayJournal(ctx context.Context, feed *event.Feed, entries []JournalEntry, speed float64) error {
+	if speed <= 0 {
+		return errors.New("replay speed must be positive")
+	}
+	start := time.Now()
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for _, entry := range entries {
+		due := start.Add(time.Duration(float64(entry.Time) / speed))
+		if wait := time.Until(due); wait > 0 {
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+			timer.Reset(wait)
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		} else if err := ctx.Err(); err != nil {
+			return err
+		}
+		event := *entry.Event
+		event.ID = nextEventID()
+		event.Time = time.Now()
+		feed.Send(&event)
+	}
+	return nil
+}
@@ -0,0 +1,502 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package simulations
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/event"
+	"github.com/ethereum/go-ethereum/node"
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/p2p/discover"
+	"github.com/ethereum/go-ethereum/p2p/simulations/adapters"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+func TestJournal(t *testing.T) {
+	var feed event.Feed
+	journal := NewJournal(&feed)
+
+	one := &Node{Config: adapters.RandomNodeConfig(), Up: true}
+	other := &Node{Config: adapters.RandomNodeConfig(), Up: true}
+	conn := &Conn{One: one.ID(), Other: other.ID()}
+	feed.Send(ControlEvent(one))
+	feed.Send(ControlEvent(other))
+	time.Sleep(10 * time.Millisecond)
+
+	// the control event sent when connecting holds the connection's
+	// previous state so should not be recorded, unlike the live event
+	// sent once it is up
+	feed.Send(ControlEvent(conn))
+	conn.Up = true
+	feed.Send(NewEvent(conn))
+
+	// events which don't change a node or connection's state, and message
+	// events, should not be recorded
+	feed.Send(NewEvent(one))
+	feed.Send(NewEvent(conn))
+	feed.Send(ControlEvent(&Msg{One: one.ID(), Other: other.ID()}))
+
+	conn.Up = false
+	feed.Send(NewEvent(conn))
+	journal.Close()
+
+	// events sent after closing should not be recorded
+	feed.Send(ControlEvent(one))
+
+	entries := journal.Events()
+	if len(entries) != 4 {
+		t.Fatalf("expected 4 journal entries, got %d", len(entries))
+	}
+	for i, expected := range []struct {
+		typ EventType
+		up  bool
+	}{
+		{EventTypeNode, true},
+		{EventTypeNode, true},
+		{EventTypeConn, true},
+		{EventTypeConn, false},
+	} {
+		event := entries[i].Event
+		if !event.Control {
+			t.Fatalf("expected entry %d to be a control event", i)
+		}
+		if event.Type != expected.typ {
+			t.Fatalf("expected entry %d to have type %q, got %q", i, expected.typ, event.Type)
+		}
+		if up := event.Type == EventTypeNode && event.Node.Up || event.Type == EventTypeConn && event.Conn.Up; up != expected.up {
+			t.Fatalf("expected entry %d to have up %t, got %t", i, expected.up, up)
+		}
+		if i > 0 && entries[i].Time < entries[i-1].Time {
+			t.Fatalf("expected entry %d to be after entry %d", i, i-1)
+		}
+	}
+	if entries[2].Time-entries[1].Time < 10*time.Millisecond {
+		t.Fatalf("expected entries to be timestamped relative to the start of the journal")
+	}
+}
+
+// TestJournalNetwork checks that a journal records the state changes of a
+// network's nodes and connections
+func TestJournalNetwork(t *testing.T) {
+	network := newJournalTestNetwork()
+	defer network.Shutdown()
+	journal := NewJournal(network.Events())
+
+	ids := make([]discover.NodeID, 2)
+	for i := range ids {
+		node, err := network.NewNode()
+		if err != nil {
+			t.Fatalf("error creating node: %s", err)
+		}
+		ids[i] = node.ID()
+	}
+	for _, id := range ids {
+		if err := network.Start(id); err != nil {
+			t.Fatalf("error starting node: %s", err)
+		}
+	}
+	// Connect and Stop send control events before they return
+	events := make(chan *Event, 10)
+	sub := network.Events().Subscribe(events)
+	defer sub.Unsubscribe()
+	waitConn := func(up bool) {
+		timeout := time.After(10 * time.Second)
+		for {
+			select {
+			case event := <-events:
+				if event.Type == EventTypeConn && !event.Control && event.Conn.Up == up {
+					return
+				}
+			case <-timeout:
+				t.Fatalf("timed out waiting for the connection to be up: %t", up)
+			}
+		}
+	}
+	if err := network.Connect(ids[0], ids[1]); err != nil {
+		t.Fatalf("error connecting nodes: %s", err)
+	}
+	waitConn(true)
+	if err := network.Stop(ids[1]); err != nil {
+		t.Fatalf("error stopping node: %s", err)
+	}
+	waitConn(false)
+	journal.Close()
+
+	// the nodes go through their states in order, although whether the
+	// stopped node or its connection is recorded first depends on which of
+	// them the network reports first
+	states := make(map[string][]bool)
+	for i, entry := range journal.Events() {
+		if !entry.Event.Control {
+			t.Fatalf("expected entry %d to be a control event", i)
+		}
+		up, ok := eventState(entry.Event)
+		if !ok {
+			t.Fatalf("unexpected entry %d: %s", i, entry.Event)
+		}
+		key := journalKey(entry.Event)
+		states[key] = append(states[key], up)
+	}
+	for key, expected := range map[string][]bool{
+		journalKey(ControlEvent(&Node{Config: &adapters.NodeConfig{ID: ids[0]}})): {false, true},
+		journalKey(ControlEvent(&Node{Config: &adapters.NodeConfig{ID: ids[1]}})): {false, true, false},
+	} {
+		if fmt.Sprint(states[key]) != fmt.Sprint(expected) {
+			t.Fatalf("expected %s to be recorded with states %v, got %v", key, expected, states[key])
+		}
+	}
+
+	// the first node may redial the stopped node while it is shutting
+	// down, but the connection is recorded going up and ending down
+	conn := states[journalKey(ControlEvent(&Conn{One: ids[0], Other: ids[1]}))]
+	if len(conn) < 2 || !conn[0] || conn[len(conn)-1] {
+		t.Fatalf("expected the connection to be recorded going up and then down, got %v", conn)
+	}
+	if len(states) != 3 {
+		t.Fatalf("expected 3 nodes and connections to be recorded, got %d", len(states))
+	}
+}
+
+// newJournalTestNetwork returns a network of nodes running a no-op service
+func newJournalTestNetwork() *Network {
+	adapter := adapters.NewSimAdapter(adapters.Services{
+		"noop": func(ctx *adapters.ServiceContext) (node.Service, error) {
+			return noopService{}, nil
+		},
+	})
+	return NewNetwork(adapter, &NetworkConfig{DefaultService: "noop"})
+}
+
+// TestReplayJournalNetwork checks that replaying a journal recorded from one
+// network into a fresh network leaves its nodes and connections in the same
+// state
+func TestReplayJournalNetwork(t *testing.T) {
+	recorded := newJournalTestNetwork()
+	defer recorded.Shutdown()
+	journal := NewJournal(recorded.Events())
+
+	// create four nodes, start three of them in a line and then stop the
+	// last one
+	ids := make([]discover.NodeID, 4)
+	for i := range ids {
+		node, err := recorded.NewNode()
+		if err != nil {
+			t.Fatalf("error creating node: %s", err)
+		}
+		ids[i] = node.ID()
+	}
+	for _, id := range ids[:3] {
+		if err := recorded.Start(id); err != nil {
+			t.Fatalf("error starting node: %s", err)
+		}
+	}
+	for i := 0; i < 2; i++ {
+		if err := recorded.Connect(ids[i], ids[i+1]); err != nil {
+			t.Fatalf("error connecting nodes: %s", err)
+		}
+	}
+	if err := waitNetworkState(recorded, 3, 2); err != nil {
+		t.Fatal(err)
+	}
+	if err := recorded.Stop(ids[2]); err != nil {
+		t.Fatalf("error stopping node: %s", err)
+	}
+	if err := waitNetworkState(recorded, 2, 1); err != nil {
+		t.Fatal(err)
+	}
+	journal.Close()
+
+	replayed := newJournalTestNetwork()
+	defer replayed.Shutdown()
+	var feed event.Feed
+	events := make(chan *Event)
+	sub := feed.Subscribe(events)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		replayed.Subscribe(events)
+	}()
+	err := ReplayJournal(context.Background(), &feed, journal.Events(), 1e3)
+	sub.Unsubscribe()
+	close(events)
+	<-done
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := waitNetworkState(replayed, 2, 1); err != nil {
+		t.Fatal(err)
+	}
+	if expected, got := describeNetwork(recorded), describeNetwork(replayed); got != expected {
+		t.Fatalf("expected the replayed network to be in state %s, got %s", expected, got)
+	}
+}
+
+// describeNetwork returns which of the network's nodes and connections are
+// up, ordered so that networks in the same state have the same description
+func describeNetwork(net *Network) string {
+	net.lock.RLock()
+	defer net.lock.RUnlock()
+	var up, down, conns []string
+	for _, node := range net.Nodes {
+		if node.Up {
+			up = append(up, node.ID().String())
+		} else {
+			down = append(down, node.ID().String())
+		}
+	}
+	for _, conn := range net.Conns {
+		if conn.Up {
+			conns = append(conns, ConnLabel(conn.One, conn.Other))
+		}
+	}
+	sort.Strings(up)
+	sort.Strings(down)
+	sort.Strings(conns)
+	return fmt.Sprintf("up: %v, down: %v, conns: %v", up, down, conns)
+}
+
+// waitNetworkState waits for the given number of nodes and connections in
+// the network to be up
+func waitNetworkState(net *Network, nodesUp, connsUp int) error {
+	count := func() (nodes, conns int) {
+		net.lock.RLock()
+		defer net.lock.RUnlock()
+		for _, node := range net.Nodes {
+			if node.Up {
+				nodes++
+			}
+		}
+		for _, conn := range net.Conns {
+			if conn.Up {
+				conns++
+			}
+		}
+		return nodes, conns
+	}
+	timeout := time.After(10 * time.Second)
+	for {
+		nodes, conns := count()
+		if nodes == nodesUp && conns == connsUp {
+			return nil
+		}
+		select {
+		case <-time.After(10 * time.Millisecond):
+		case <-timeout:
+			return fmt.Errorf("timed out waiting for %d nodes and %d connections to be up, got %d and %d", nodesUp, connsUp, nodes, conns)
+		}
+	}
+}
+
+// noopService is a service without any protocols, used to test connecting
+// nodes without having to wait for protocol handshakes
+type noopService struct{}
+
+func (noopService) Protocols() []p2p.Protocol      { return nil }
+func (noopService) APIs() []rpc.API                { return nil }
+func (noopService) Start(server *p2p.Server) error { return nil }
+func (noopService) Stop() error                    { return nil }
+func TestReplayJournal(t *testing.T) {
+	var entries []JournalEntry
+	for i, offset := range []time.Duration{0, 20, 20, 40} {
+		node := &Node{Config: adapters.RandomNodeConfig(), Up: true}
+		node.Config.Name = fmt.Sprintf("node%02d", i)
+		entries = append(entries, JournalEntry{
+			Time:  offset * time.Millisecond,
+			Event: ControlEvent(node),
+		})
+	}
+
+	replay := func(ctx context.Context, speed float64) ([]*Event, error) {
+		var feed event.Feed
+		events := make(chan *Event, len(entries))
+		sub := feed.Subscribe(events)
+		defer sub.Unsubscribe()
+		err := ReplayJournal(ctx, &feed, entries, speed)
+		close(events)
+		var replayed []*Event
+		for event := range events {
+			replayed = append(replayed, event)
+		}
+		return replayed, err
+	}
+	checkOrder := func(replayed []*Event) {
+		if len(replayed) != len(entries) {
+			t.Fatalf("expected %d replayed events, got %d", len(entries), len(replayed))
+		}
+		for i, event := range replayed {
+			if event.Node.Config.Name != entries[i].Event.Node.Config.Name {
+				t.Fatalf("expected event %d to be %s, got %s", i, entries[i].Event.Node.Config.Name, event.Node.Config.Name)
+			}
+			// replayed events are new events with increasing IDs
+			if event.ID <= entries[len(entries)-1].Event.ID || (i > 0 && event.ID <= replayed[i-1].ID) {
+				t.Fatalf("expected event %d to have a new increasing ID, got %d", i, event.ID)
+			}
+		}
+	}
+
+	// replaying at double speed should take half the recorded time
+	start := time.Now()
+	replayed, err := replay(context.Background(), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 20*time.Millisecond || elapsed > time.Second {
+		t.Fatalf("expected replay at double speed to take about 20ms, took %s", elapsed)
+	}
+	checkOrder(replayed)
+
+	// a very high speed should still send the events in order
+	replayed, err = replay(context.Background(), 1e9)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkOrder(replayed)
+
+	for _, speed := range []float64{0, -1} {
+		if _, err := replay(context.Background(), speed); err == nil {
+			t.Fatalf("expected an error replaying at speed %v", speed)
+		}
+	}
+
+	// a cancelled replay should stop sending events
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	replayed, err = replay(ctx, 1)
+	if err != context.DeadlineExceeded {
+		t.Fatalf("expected error %q, got %v", context.DeadlineExceeded, err)
+	}
+	if len(replayed) != 1 {
+		t.Fatalf("expected 1 replayed event before cancelling, got %d", len(replayed))
+	}
+}
+
+func TestOpenJournal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "p2p-sim-journal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "journal")
+
+	record := func(names ...string) {
+		var feed event.Feed
+		journal, err := OpenJournal(&feed, path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, name := range names {
+			node := &Node{Config: adapters.RandomNodeConfig(), Up: true}
+			node.Config.Name = name
+			feed.Send(ControlEvent(node))
+			time.Sleep(5 * time.Millisecond)
+		}
+		journal.Close()
+	}
+	record("node01", "node02")
+	record("node03")
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	entries, err := ReadJournal(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("expected 3 journal entries, got %d", len(entries))
+	}
+	for i, entry := range entries {
+		if name := fmt.Sprintf("node%02d", i+1); entry.Event.Node.Config.Name != name {
+			t.Fatalf("expected entry %d to be %s, got %s", i, name, entry.Event.Node.Config.Name)
+		}
+		if entry.Event.Type != EventTypeNode || !entry.Event.Node.Up {
+			t.Fatalf("expected entry %d to be a node up event", i)
+		}
+		if i > 0 && entry.Time < entries[i-1].Time {
+			t.Fatalf("expected entry %d to be after entry %d", i, i-1)
+		}
+	}
+}
+
+func TestCompactJournal(t *testing.T) {
+	nodes := testGraphNodes(3)
+	nodeEntry := func(ms time.Duration, node *Node, up bool) JournalEntry {
+		n := *node
+		n.Up = up
+		return JournalEntry{Time: ms * time.Millisecond, Event: ControlEvent(&n)}
+	}
+	connEntry := func(ms time.Duration, one, other *Node, up bool) JournalEntry {
+		conn := &Conn{One: one.ID(), Other: other.ID(), Up: up}
+		return JournalEntry{Time: ms * time.Millisecond, Event: ControlEvent(conn)}
+	}
+	entries := []JournalEntry{
+		// epoch 0: node 0 starts, stops and starts again, node 1 starts
+		nodeEntry(0, nodes[0], true),
+		nodeEntry(10, nodes[1], true),
+		nodeEntry(20, nodes[0], false),
+		nodeEntry(30, nodes[0], true),
+		// epoch 1: node 0 stops and restarts, which is a no-op, and the
+		// connection flaps, recorded from either end
+		nodeEntry(100, nodes[0], false),
+		connEntry(110, nodes[0], nodes[1], true),
+		connEntry(120, nodes[1], nodes[0], false),
+		nodeEntry(130, nodes[0], true),
+		// epoch 3: node 2 starts
+		nodeEntry(350, nodes[2], true),
+	}
+	compacted := CompactJournal(entries, 100*time.Millisecond)
+	expected := []JournalEntry{entries[1], entries[3], entries[6], entries[8]}
+	if len(compacted) != len(expected) {
+		t.Fatalf("expected %d compacted entries, got %d", len(expected), len(compacted))
+	}
+	for i, entry := range compacted {
+		if entry.Time != expected[i].Time || entry.Event != expected[i].Event {
+			t.Fatalf("expected entry %d to be recorded at %s, got %s", i, expected[i].Time, entry.Time)
+		}
+	}
+
+	// a single epoch covering the whole journal leaves one entry per node
+	// and connection
+	if n := len(CompactJournal(entries, time.Second)); n != 4 {
+		t.Fatalf("expected 4 compacted entries, got %d", n)
+	}
+
+	// compacted entries can be stored and read back
+	var buf bytes.Buffer
+	if err := WriteJournal(&buf, compacted); err != nil {
+		t.Fatal(err)
+	}
+	read, err := ReadJournal(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(read) != len(compacted) || read[2].Time != compacted[2].Time || read[2].Event.Conn.Up {
+		t.Fatalf("expected the compacted entries to be read back, got %v", read)
+	}
+}
@@ -0,0 +1,360 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package simulations
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/node"
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/p2p/discover"
+	"github.com/ethereum/go-ethereum/p2p/simulations/adapters"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// LoadService is a node.Service which runs the "load" protocol, used by a
+// LoadGenerator to send traffic between nodes and time its delivery. It
+// should be registered with the node adapter, for example:
+//
+//	adapters.NewSimAdapter(adapters.Services{"load": simulations.NewLoadService})
+type LoadService struct {
+	mtx    sync.Mutex
+	peers  map[discover.NodeID]p2p.MsgReadWriter
+	record func(latency time.Duration, size int)
+}
+
+// NewLoadService is an adapters.ServiceFunc which creates a LoadService
+func NewLoadService(ctx *adapters.ServiceContext) (node.Service, error) {
+	return &LoadService{peers: make(map[discover.NodeID]p2p.MsgReadWriter)}, nil
+}
+
+// loadMsg is the message sent by the load protocol, carrying the time it
+// was sent so that the receiver can measure its latency
+type loadMsg struct {
+	Sent uint64 // nanoseconds since the Unix epoch
+	Data []byte
+}
+
+func (s *LoadService) Protocols() []p2p.Protocol {
+	return []p2p.Protocol{{
+		Name:    "load",
+		Version: 1,
+		Length:  1,
+		Run:     s.Run,
+	}}
+}
+
+func (s *LoadService) APIs() []rpc.API {
+	return nil
+}
+
+func (s *LoadService) Start(server *p2p.Server) error {
+	return nil
+}
+
+func (s *LoadService) Stop() error {
+	return nil
+}
+
+// Run runs the load protocol with the peer, recording the latency of each
+// message received
+func (s *LoadService) Run(peer *p2p.Peer, rw p2p.MsgReadWriter) error {
+	s.mtx.Lock()
+	s.peers[peer.ID()] = rw
+	s.mtx.Unlock()
+	defer func() {
+		s.mtx.Lock()
+		delete(s.peers, peer.ID())
+		s.mtx.Unlock()
+	}()
+	for {
+		msg, err := rw.ReadMsg()
+		if err != nil {
+			return err
+		}
+		var m loadMsg
+		if err := msg.Decode(&m); err != nil {
+			return err
+		}
+		latency := time.Since(time.Unix(0, int64(m.Sent)))
+		s.mtx.Lock()
+		record := s.record
+		s.mtx.Unlock()
+		if record != nil {
+			record(latency, len(m.Data))
+		}
+	}
+}
+
+// Send sends a message with a payload of the given size to fanOut randomly
+// chosen peers (or all peers if fanOut is 0), returning the number of peers
+// the message was sent to
+func (s *LoadService) Send(size, fanOut int, r *rand.Rand) int {
+	s.mtx.Lock()
+	peers := make([]p2p.MsgReadWriter, 0, len(s.peers))
+	for _, rw := range s.peers {
+		peers = append(peers, rw)
+	}
+	s.mtx.Unlock()
+	if fanOut > 0 && fanOut < len(peers) {
+		chosen := make([]p2p.MsgReadWriter, 0, fanOut)
+		for _, i := range r.Perm(len(peers))[:fanOut] {
+			chosen = append(chosen, peers[i])
+		}
+		peers = chosen
+	}
+	sent := 0
+	for _, rw := range peers {
+		msg := &loadMsg{Sent: uint64(time.Now().UnixNano()), Data: make([]byte, size)}
+		// the peer may disconnect at any time, which isn't an error
+		// when generating load
+		if err := p2p.Send(rw, 0, msg); err == nil {
+			sent++
+		}
+	}
+	return sent
+}
+
+// setRecorder sets the function called with the latency and payload size of
+// each message received
+func (s *LoadService) setRecorder(record func(latency time.Duration, size int)) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.record = record
+}
+
+// SizeDistribution samples a random message payload size in bytes
+type SizeDistribution func(r *rand.Rand) int
+
+// FixedSize always samples the given size
+func FixedSize(size int) SizeDistribution {
+	return func(r *rand.Rand) int {
+		return size
+	}
+}
+
+// UniformSize samples sizes uniformly between min and max inclusive
+func UniformSize(min, max int) SizeDistribution {
+	return func(r *rand.Rand) int {
+		return min + r.Intn(max-min+1)
+	}
+}
+
+// LoadConfig configures the traffic a LoadGenerator drives through a network
+type LoadConfig struct {
+	// Size samples the payload size of each message
+	Size SizeDistribution
+
+	// Rate is the mean number of messages each node sends per second, the
+	// time between a node's messages being exponentially distributed
+	Rate float64
+
+	// FanOut is the number of randomly chosen peers each message is sent
+	// to, 0 meaning all of the sending node's peers
+	FanOut int
+}
+
+// DefaultLoadConfig sends 1KB messages to all peers ten times a second
+var DefaultLoadConfig = &LoadConfig{
+	Size: FixedSize(1024),
+	Rate: 10,
+}
+
+// LoadGenerator drives traffic through a network by having the nodes which
+// run the LoadService send messages to their peers, and measures the
+// latency and throughput of their delivery. Only nodes whose adapter runs
+// services in-process (i.e. SimNodes) can be driven.
+type LoadGenerator struct {
+	network *Network
+	config  *LoadConfig
+
+	mtx       sync.Mutex
+	start     time.Time
+	end       time.Time
+	sent      int
+	received  int
+	bytes     int64
+	latencies []time.Duration
+}
+
+// NewLoadGenerator returns a generator which drives traffic through the
+// network according to the given config
+func NewLoadGenerator(network *Network, config *LoadConfig) *LoadGenerator {
+	return &LoadGenerator{network: network, config: config}
+}
+
+// Run drives traffic from the nodes which are up and running the
+// LoadService until the context is done. Only one generator should be run
+// on a network at a time.
+func (g *LoadGenerator) Run(ctx context.Context) error {
+	if g.config.Rate <= 0 {
+		return errors.New("load rate must be positive")
+	}
+	services := g.services()
+	if len(services) == 0 {
+		return errors.New("no nodes running the load service")
+	}
+	g.mtx.Lock()
+	g.start, g.end = time.Now(), time.Time{}
+	g.mtx.Unlock()
+
+	var wg sync.WaitGroup
+	for i, s := range services {
+		s.setRecorder(g.record)
+		wg.Add(1)
+		go func(s *LoadService, seed int64) {
+			defer wg.Done()
+			g.drive(ctx, s, rand.New(rand.NewSource(seed)))
+		}(s, time.Now().UnixNano()+int64(i))
+	}
+	wg.Wait()
+	for _, s := range services {
+		s.setRecorder(nil)
+	}
+
+	g.mtx.Lock()
+	g.end = time.Now()
+	g.mtx.Unlock()
+	return nil
+}
+
+// services returns the LoadServices of the nodes which are up
+func (g *LoadGenerator) services() []*LoadService {
+	var services []*LoadService
+	for _, n := range g.network.GetNodes() {
+		if !g.network.isUp(n) {
+			continue
+		}
+		running, ok := n.Node.(interface {
+			Services() []node.Service
+		})
+		if !ok {
+			continue
+		}
+		for _, service := range running.Services() {
+			if s, ok := service.(*LoadService); ok {
+				services = append(services, s)
+			}
+		}
+	}
+	return services
+}
+
+// drive sends messages from the service at the configured rate until the
+// context is done
+func (g *LoadGenerator) drive(ctx context.Context, s *LoadService, r *rand.Rand) {
+	for {
+		wait := time.Duration(r.ExpFloat64() / g.config.Rate * float64(time.Second))
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+		sent := s.Send(g.config.Size(r), g.config.FanOut, r)
+		g.mtx.Lock()
+		g.sent += sent
+		g.mtx.Unlock()
+	}
+}
+
+func (g *LoadGenerator) record(latency time.Duration, size int) {
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+	g.received++
+	g.bytes += int64(size)
+	g.latencies = append(g.latencies, latency)
+}
+
+// LoadReport summarises the traffic driven by a LoadGenerator
+type LoadReport struct {
+	// Sent and Received are the number of messages sent and received,
+	// with a message sent to several peers counted once per peer
+	Sent     int `json:"sent"`
+	Received int `json:"received"`
+
+	// Bytes is the total payload size of the messages received
+	Bytes int64 `json:"bytes"`
+
+	// Duration is how long the generator has been running for
+	Duration time.Duration `json:"duration"`
+
+	// Throughput and BytesPerSecond are the number of messages and
+	// payload bytes received per second
+	Throughput     float64 `json:"throughput"`
+	BytesPerSecond float64 `json:"bytes_per_second"`
+
+	// Latency contains percentiles of the time messages took to be
+	// delivered
+	Latency LatencyPercentiles `json:"latency"`
+}
+
+// LatencyPercentiles are percentiles of a set of latencies
+type LatencyPercentiles struct {
+	P50 time.Duration `json:"p50"`
+	P90 time.Duration `json:"p90"`
+	P99 time.Duration `json:"p99"`
+	Max time.Duration `json:"max"`
+}
+
+// Report returns a report of the traffic driven so far
+func (g *LoadGenerator) Report() *LoadReport {
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+	report := &LoadReport{
+		Sent:     g.sent,
+		Received: g.received,
+		Bytes:    g.bytes,
+	}
+	if !g.start.IsZero() {
+		end := g.end
+		if end.IsZero() {
+			end = time.Now()
+		}
+		report.Duration = end.Sub(g.start)
+	}
+	if secs := report.Duration.Seconds(); secs > 0 {
+		report.Throughput = float64(report.Received) / secs
+		report.BytesPerSecond = float64(report.Bytes) / secs
+	}
+	if len(g.latencies) > 0 {
+		latencies := make([]time.Duration, len(g.latencies))
+		copy(latencies, g.latencies)
+		sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+		report.Latency = LatencyPercentiles{
+			P50: percentile(latencies, 0.5),
+			P90: percentile(latencies, 0.9),
+			P99: percentile(latencies, 0.99),
+			Max: latencies[len(latencies)-1],
+		}
+	}
+	return report
+}
+
+// percentile returns the nearest-rank percentile p of the sorted latencies
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	i := int(math.Ceil(p*float64(len(sorted)))) - 1
+	if i < 0 {
+		i = 0
+	}
+	return sorted[i]
+}
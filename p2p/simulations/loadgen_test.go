@@ -0,0 +1,86 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package simulations
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/p2p/discover"
+	"github.com/ethereum/go-ethereum/p2p/simulations/adapters"
+)
+
+// TestLoadGenerator checks that traffic driven through a triangle of nodes
+// is delivered and reported
+func TestLoadGenerator(t *testing.T) {
+	adapter := adapters.NewSimAdapter(adapters.Services{
+		"load": NewLoadService,
+	})
+	network := NewNetwork(adapter, &NetworkConfig{
+		DefaultService: "load",
+	})
+	defer network.Shutdown()
+
+	ids := make([]discover.NodeID, 3)
+	for i := range ids {
+		node, err := network.NewNode()
+		if err != nil {
+			t.Fatalf("error creating node: %s", err)
+		}
+		if err := network.Start(node.ID()); err != nil {
+			t.Fatalf("error starting node: %s", err)
+		}
+		ids[i] = node.ID()
+	}
+	for _, pair := range [][2]int{{0, 1}, {1, 2}, {2, 0}} {
+		if err := network.Connect(ids[pair[0]], ids[pair[1]]); err != nil {
+			t.Fatalf("error connecting nodes: %s", err)
+		}
+	}
+	if err := NewNetworkExpectation().ConnsUp(3).Within(network, 10*time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	gen := NewLoadGenerator(network, &LoadConfig{
+		Size:   UniformSize(100, 200),
+		Rate:   50,
+		FanOut: 1,
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	if err := gen.Run(ctx); err != nil {
+		t.Fatal(err)
+	}
+	// wait for messages still in flight
+	time.Sleep(100 * time.Millisecond)
+
+	report := gen.Report()
+	if report.Sent == 0 || report.Received == 0 || report.Received > report.Sent {
+		t.Fatalf("expected messages to be sent and received, got %d sent and %d received", report.Sent, report.Received)
+	}
+	if report.Bytes < int64(report.Received)*100 || report.Bytes > int64(report.Received)*200 {
+		t.Fatalf("expected payloads of 100 to 200 bytes, got %d bytes in %d messages", report.Bytes, report.Received)
+	}
+	if report.Duration < 500*time.Millisecond || report.Throughput <= 0 {
+		t.Fatalf("unexpected duration %s and throughput %f", report.Duration, report.Throughput)
+	}
+	latency := report.Latency
+	if latency.P50 <= 0 || latency.P50 > latency.P90 || latency.P90 > latency.P99 || latency.P99 > latency.Max {
+		t.Fatalf("unexpected latency percentiles: %+v", latency)
+	}
+}
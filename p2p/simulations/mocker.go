@@ -19,30 +19,71 @@
 package simulations
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"math"
 	"math/rand"
 	"sync"
 	"time"
 
 	"github.com/ethereum/go-ethereum/log"
 	"github.com/ethereum/go-ethereum/p2p/discover"
+	"github.com/ethereum/go-ethereum/p2p/simulations/adapters"
+	"github.com/ethereum/go-ethereum/p2p/simulations/topology"
 )
 
+//MockerFn is a mocker which creates conf.NodeCount nodes in the network and
+//then starts, stops and connects them until quit is closed. Mockers which
+//keep changing the network call WaitWhilePaused before each change so
+//they can be paused (see PauseMocker)
+type MockerFn func(net *Network, quit chan struct{}, conf *MockerConfig)
+
 //a map of mocker names to its function
-var mockerList = map[string]func(net *Network, quit chan struct{}, nodeCount int){
+var mockerList = map[string]MockerFn{
 	"startStop":     startStop,
 	"probabilistic": probabilistic,
 	"boot":          boot,
+	"corePeriphery": corePeriphery,
+	"star":          star,
+	"fullMesh":      fullMesh,
+	"bursty":        bursty,
+	"chain":         chain,
+	"grid":          grid,
+	"smallWorld":    smallWorld,
+	"scaleFree":     scaleFree,
+	"churn":         churn,
+	"zoned":         zoned,
+}
+
+//protects mockerList from concurrent registrations and lookups
+var mockerLock sync.RWMutex
+
+//Register a mocker under the given name so that it can be selected by
+//MockerConfig.Type, returns an error if the name is already taken
+func RegisterMocker(name string, fn MockerFn) error {
+	mockerLock.Lock()
+	defer mockerLock.Unlock()
+	if _, exists := mockerList[name]; exists {
+		return fmt.Errorf("mocker %q already registered", name)
+	}
+	mockerList[name] = fn
+	return nil
 }
 
 //Lookup a mocker by its name, returns the mockerFn
-func LookupMocker(mockerType string) func(net *Network, quit chan struct{}, nodeCount int) {
+func LookupMocker(mockerType string) MockerFn {
+	mockerLock.RLock()
+	defer mockerLock.RUnlock()
 	return mockerList[mockerType]
 }
 
 //Get a list of mockers (keys of the map)
 //Useful for frontend to build available mocker selection
 func GetMockerList() []string {
+	mockerLock.RLock()
+	defer mockerLock.RUnlock()
 	list := make([]string, 0, len(mockerList))
 	for k := range mockerList {
 		list = append(list, k)
@@ -50,20 +91,292 @@ func GetMockerList() []string {
 	return list
 }
 
+//the resume channels of paused mockers, keyed by their quit channel, which
+//are closed when the mocker is resumed
+var (
+	pausedMockers   = make(map[chan struct{}]chan struct{})
+	pausedMockerMtx sync.Mutex
+)
+
+//PauseMocker pauses the mocker with the given quit channel, leaving the
+//network as it is until the mocker is resumed or stopped, returns false if
+//it is already paused
+func PauseMocker(quit chan struct{}) bool {
+	pausedMockerMtx.Lock()
+	defer pausedMockerMtx.Unlock()
+	if _, paused := pausedMockers[quit]; paused {
+		return false
+	}
+	resume := make(chan struct{})
+	pausedMockers[quit] = resume
+	//forget the mocker if it is stopped while paused
+	go func() {
+		select {
+		case <-quit:
+			ResumeMocker(quit)
+		case <-resume:
+		}
+	}()
+	return true
+}
+
+//ResumeMocker resumes the paused mocker with the given quit channel,
+//returns false if it isn't paused
+func ResumeMocker(quit chan struct{}) bool {
+	pausedMockerMtx.Lock()
+	defer pausedMockerMtx.Unlock()
+	resume, paused := pausedMockers[quit]
+	if !paused {
+		return false
+	}
+	close(resume)
+	delete(pausedMockers, quit)
+	return true
+}
+
+//WaitWhilePaused blocks while the mocker with the given quit channel is
+//paused, returns false if quit is closed
+func WaitWhilePaused(quit chan struct{}) bool {
+	pausedMockerMtx.Lock()
+	resume, paused := pausedMockers[quit]
+	pausedMockerMtx.Unlock()
+	if paused {
+		select {
+		case <-resume:
+		case <-quit:
+		}
+	}
+	//a mocker stopped while paused is also resumed so that it is forgotten
+	select {
+	case <-quit:
+		return false
+	default:
+		return true
+	}
+}
+
+//MockerConfig configures a mocker run
+type MockerConfig struct {
+	//Type is the name of the mocker to run (see GetMockerList)
+	Type string `json:"type"`
+	//NodeCount is the number of nodes the mocker creates
+	NodeCount int `json:"node_count"`
+	//Seed seeds the random source the mocker picks nodes, peers and waits
+	//with, so that runs with the same seed make the same choices
+	Seed int64 `json:"seed"`
+	//NATFraction is the fraction of the nodes the mocker creates which are
+	//behind a NAT (see adapters.NodeConfig.NAT)
+	NATFraction float64 `json:"nat_fraction,omitempty"`
+	//Bursty configures the bursty mocker, DefaultBurstyConfig is used if
+	//it is not set
+	Bursty *BurstyConfig `json:"bursty,omitempty"`
+	//CorePeriphery configures the corePeriphery mocker,
+	//DefaultCorePeripheryConfig is used if it is not set
+	CorePeriphery *CorePeripheryConfig `json:"core_periphery,omitempty"`
+	//Churn configures the churn mocker, DefaultChurnConfig is used if it
+	//is not set
+	Churn *ChurnConfig `json:"churn,omitempty"`
+	//Zoned configures the zoned mocker, DefaultZonedConfig is used if it
+	//is not set
+	Zoned *ZonedConfig `json:"zoned,omitempty"`
+	//Converge, if set, stops the mocker once the network has converged
+	//(see ConvergenceMonitor)
+	Converge *ConvergenceConfig `json:"converge,omitempty"`
+}
+
+//DefaultMockerConfig returns the config used for fields which are not set
+//when parsing a MockerConfig, with a seed taken from the current time
+func DefaultMockerConfig() *MockerConfig {
+	return &MockerConfig{
+		Type:      "probabilistic",
+		NodeCount: 10,
+		Seed:      time.Now().UnixNano(),
+	}
+}
+
+//ParseMockerConfig decodes a JSON encoded MockerConfig, using the default
+//value for any fields which are omitted
+func ParseMockerConfig(r io.Reader) (*MockerConfig, error) {
+	conf := DefaultMockerConfig()
+	if err := json.NewDecoder(r).Decode(conf); err != nil && err != io.EOF {
+		return nil, err
+	}
+	if err := conf.Validate(); err != nil {
+		return nil, err
+	}
+	return conf, nil
+}
+
+//Validate checks the config refers to a known mocker and has enough nodes
+//for the mockers to pick pairs of nodes from
+func (c *MockerConfig) Validate() error {
+	if LookupMocker(c.Type) == nil {
+		return fmt.Errorf("unknown mocker type %q", c.Type)
+	}
+	if c.NodeCount < 2 {
+		return fmt.Errorf("node count must be at least 2, got %d", c.NodeCount)
+	}
+	if c.NATFraction < 0 || c.NATFraction > 1 {
+		return fmt.Errorf("NAT fraction must be between 0 and 1, got %v", c.NATFraction)
+	}
+	if c.Bursty != nil && c.Bursty.Interval <= 0 {
+		return fmt.Errorf("bursty interval must be positive, got %v", c.Bursty.Interval)
+	}
+	if c.Churn != nil {
+		if err := c.Churn.Session.Validate(); err != nil {
+			return fmt.Errorf("churn session: %v", err)
+		}
+		if err := c.Churn.Downtime.Validate(); err != nil {
+			return fmt.Errorf("churn downtime: %v", err)
+		}
+		if p := c.Churn.CrashProbability; p < 0 || p > 1 {
+			return fmt.Errorf("churn crash probability must be between 0 and 1, got %v", p)
+		}
+	}
+	if c.Zoned != nil {
+		if len(c.Zoned.Zones) == 0 {
+			return errors.New("zoned config must have at least one zone")
+		}
+		if c.Zoned.Degree < 1 {
+			return fmt.Errorf("zoned degree must be at least 1, got %d", c.Zoned.Degree)
+		}
+		if p := c.Zoned.IntraZoneBias; p < 0 || p > 1 {
+			return fmt.Errorf("zoned intra-zone bias must be between 0 and 1, got %v", p)
+		}
+	}
+	if c.Converge != nil {
+		if err := c.Converge.Validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//bursty returns the config for the bursty mocker
+func (c *MockerConfig) bursty() *BurstyConfig {
+	if c.Bursty != nil {
+		return c.Bursty
+	}
+	return DefaultBurstyConfig
+}
+
+//corePeriphery returns the config for the corePeriphery mocker
+func (c *MockerConfig) corePeriphery() *CorePeripheryConfig {
+	if c.CorePeriphery != nil {
+		return c.CorePeriphery
+	}
+	return DefaultCorePeripheryConfig
+}
+
+//churn returns the config for the churn mocker
+func (c *MockerConfig) churn() *ChurnConfig {
+	if c.Churn != nil {
+		return c.Churn
+	}
+	return DefaultChurnConfig
+}
+
+//zoned returns the config for the zoned mocker
+func (c *MockerConfig) zoned() *ZonedConfig {
+	if c.Zoned != nil {
+		return c.Zoned
+	}
+	return DefaultZonedConfig
+}
+
+//rand returns a random source seeded with the config's seed
+func (c *MockerConfig) rand() *rand.Rand {
+	return rand.New(rand.NewSource(c.Seed))
+}
+
 //The boot mockerFn only connects the node in a ring and doesn't do anything else
-func boot(net *Network, quit chan struct{}, nodeCount int) {
-	_, err := connectNodesInRing(net, nodeCount)
+func boot(net *Network, quit chan struct{}, conf *MockerConfig) {
+	_, err := connectNodesInRing(net, conf)
+	if err != nil {
+		panic("Could not startup node network for mocker")
+	}
+}
+
+//The corePeriphery mockerFn connects the nodes in a two-tier topology
+//using conf.CorePeriphery and doesn't do anything else
+func corePeriphery(net *Network, quit chan struct{}, conf *MockerConfig) {
+	_, err := connectNodesInCorePeriphery(net, conf)
+	if err != nil {
+		panic("Could not startup node network for mocker")
+	}
+}
+
+//The star mockerFn connects every node to the first node and doesn't do
+//anything else
+func star(net *Network, quit chan struct{}, conf *MockerConfig) {
+	_, err := connectNodes(net, conf, topology.Star)
+	if err != nil {
+		panic("Could not startup node network for mocker")
+	}
+}
+
+//The fullMesh mockerFn connects every node to every other node and doesn't
+//do anything else
+func fullMesh(net *Network, quit chan struct{}, conf *MockerConfig) {
+	_, err := connectNodes(net, conf, topology.FullMesh)
+	if err != nil {
+		panic("Could not startup node network for mocker")
+	}
+}
+
+//The chain mockerFn connects each node to its neighbours in a line and
+//doesn't do anything else
+func chain(net *Network, quit chan struct{}, conf *MockerConfig) {
+	_, err := connectNodes(net, conf, topology.Chain)
+	if err != nil {
+		panic("Could not startup node network for mocker")
+	}
+}
+
+//The grid mockerFn connects the nodes in a square 2D grid and doesn't do
+//anything else
+func grid(net *Network, quit chan struct{}, conf *MockerConfig) {
+	_, err := connectNodes(net, conf, func(ids []discover.NodeID) [][2]discover.NodeID {
+		return topology.Grid(ids, topology.SquareGridWidth(len(ids)))
+	})
+	if err != nil {
+		panic("Could not startup node network for mocker")
+	}
+}
+
+//The smallWorld mockerFn connects the nodes in a Watts-Strogatz small-world
+//network with degree 4 where a fifth of the connections are rewired, and
+//doesn't do anything else
+func smallWorld(net *Network, quit chan struct{}, conf *MockerConfig) {
+	r := conf.rand()
+	_, err := connectNodes(net, conf, func(ids []discover.NodeID) [][2]discover.NodeID {
+		return topology.SmallWorld(ids, 4, 0.2, r)
+	})
+	if err != nil {
+		panic("Could not startup node network for mocker")
+	}
+}
+
+//The scaleFree mockerFn connects the nodes in a Barabási-Albert scale-free
+//network where each node joins with two connections, and doesn't do
+//anything else
+func scaleFree(net *Network, quit chan struct{}, conf *MockerConfig) {
+	r := conf.rand()
+	_, err := connectNodes(net, conf, func(ids []discover.NodeID) [][2]discover.NodeID {
+		return topology.ScaleFree(ids, 2, r)
+	})
 	if err != nil {
 		panic("Could not startup node network for mocker")
 	}
 }
 
 //The startStop mockerFn stops and starts nodes in a defined period (ticker)
-func startStop(net *Network, quit chan struct{}, nodeCount int) {
-	nodes, err := connectNodesInRing(net, nodeCount)
+func startStop(net *Network, quit chan struct{}, conf *MockerConfig) {
+	nodes, err := connectNodesInRing(net, conf)
 	if err != nil {
 		panic("Could not startup node network for mocker")
 	}
+	r := conf.rand()
 	tick := time.NewTicker(10 * time.Second)
 	defer tick.Stop()
 	for {
@@ -72,7 +385,11 @@ func startStop(net *Network, quit chan struct{}, nodeCount int) {
 			log.Info("Terminating simulation loop")
 			return
 		case <-tick.C:
-			id := nodes[rand.Intn(len(nodes))]
+			if !WaitWhilePaused(quit) {
+				log.Info("Terminating simulation loop")
+				return
+			}
+			id := nodes[r.Intn(len(nodes))]
 			log.Info("stopping node", "id", id)
 			if err := net.Stop(id); err != nil {
 				log.Error("error stopping node", "id", id, "err", err)
@@ -85,6 +402,10 @@ func startStop(net *Network, quit chan struct{}, nodeCount int) {
 				return
 			case <-time.After(3 * time.Second):
 			}
+			if !WaitWhilePaused(quit) {
+				log.Info("Terminating simulation loop")
+				return
+			}
 
 			log.Debug("starting node", "id", id)
 			if err := net.Start(id); err != nil {
@@ -99,11 +420,13 @@ func startStop(net *Network, quit chan struct{}, nodeCount int) {
 //(the implementation could probably be improved):
 //nodes are connected in a ring, then a varying number of random nodes is selected,
 //mocker then stops and starts them in random intervals, and continues the loop
-func probabilistic(net *Network, quit chan struct{}, nodeCount int) {
-	nodes, err := connectNodesInRing(net, nodeCount)
+func probabilistic(net *Network, quit chan struct{}, conf *MockerConfig) {
+	nodeCount := conf.NodeCount
+	nodes, err := connectNodesInRing(net, conf)
 	if err != nil {
 		panic("Could not startup node network for mocker")
 	}
+	r := conf.rand()
 	for {
 		select {
 		case <-quit:
@@ -111,26 +434,9 @@ func probabilistic(net *Network, quit chan struct{}, nodeCount int) {
 			return
 		default:
 		}
-		var lowid, highid int
 		var wg sync.WaitGroup
-		randWait := time.Duration(rand.Intn(5000)+1000) * time.Millisecond
-		rand1 := rand.Intn(nodeCount - 1)
-		rand2 := rand.Intn(nodeCount - 1)
-		if rand1 < rand2 {
-			lowid = rand1
-			highid = rand2
-		} else if rand1 > rand2 {
-			highid = rand1
-			lowid = rand2
-		} else {
-			if rand1 == 0 {
-				rand2 = 9
-			} else if rand1 == 9 {
-				rand1 = 0
-			}
-			lowid = rand1
-			highid = rand2
-		}
+		randWait := time.Duration(r.Intn(5000)+1000) * time.Millisecond
+		lowid, highid := probabilisticRange(r, nodeCount)
 		var steps = highid - lowid
 		wg.Add(steps)
 		for i := lowid; i < highid; i++ {
@@ -140,6 +446,10 @@ func probabilistic(net *Network, quit chan struct{}, nodeCount int) {
 				return
 			case <-time.After(randWait):
 			}
+			if !WaitWhilePaused(quit) {
+				log.Info("Terminating simulation loop")
+				return
+			}
 			log.Debug(fmt.Sprintf("node %v shutting down", nodes[i]))
 			err := net.Stop(nodes[i])
 			if err != nil {
@@ -148,12 +458,20 @@ func probabilistic(net *Network, quit chan struct{}, nodeCount int) {
 				continue
 			}
 			go func(id discover.NodeID) {
-				time.Sleep(randWait)
+				defer wg.Done()
+				//don't restart nodes once the mocker has been stopped
+				select {
+				case <-quit:
+					return
+				case <-time.After(randWait):
+				}
+				if !WaitWhilePaused(quit) {
+					return
+				}
 				err := net.Start(id)
 				if err != nil {
 					log.Error(fmt.Sprintf("Error starting node %s", id))
 				}
-				wg.Done()
 			}(nodes[i])
 		}
 		wg.Wait()
@@ -161,32 +479,473 @@ func probabilistic(net *Network, quit chan struct{}, nodeCount int) {
 
 }
 
-//connect nodeCount number of nodes in a ring
-func connectNodesInRing(net *Network, nodeCount int) ([]discover.NodeID, error) {
-	ids := make([]discover.NodeID, nodeCount)
-	for i := 0; i < nodeCount; i++ {
-		node, err := net.NewNode()
-		if err != nil {
-			log.Error("Error creating a node! %s", err)
-			return nil, err
+//pick the range of nodes the probabilistic mocker stops and starts next
+func probabilisticRange(r *rand.Rand, nodeCount int) (lowid, highid int) {
+	rand1 := r.Intn(nodeCount - 1)
+	rand2 := r.Intn(nodeCount - 1)
+	if rand1 < rand2 {
+		lowid = rand1
+		highid = rand2
+	} else if rand1 > rand2 {
+		highid = rand1
+		lowid = rand2
+	} else {
+		if rand1 == 0 {
+			rand2 = nodeCount - 1
+		} else if rand1 == nodeCount-1 {
+			rand1 = 0
 		}
-		ids[i] = node.ID()
+		lowid = rand1
+		highid = rand2
 	}
+	return lowid, highid
+}
 
-	for _, id := range ids {
+//BurstyConfig configures correlated node failures, where a large fraction
+//of the network drops at once and stays down for a number of ticks
+type BurstyConfig struct {
+	//Interval is the time between ticks
+	Interval time.Duration `json:"interval"`
+	//BurstSize is the fraction of up nodes which are stopped in a burst
+	BurstSize float64 `json:"burst_size"`
+	//BurstProbability is the probability that a burst happens on a tick
+	BurstProbability float64 `json:"burst_probability"`
+	//DownTicks is the number of ticks stopped nodes stay down before they
+	//are started again
+	DownTicks int `json:"down_ticks"`
+}
+
+//DefaultBurstyConfig is the configuration used by the bursty mocker when
+//MockerConfig.Bursty is not set
+var DefaultBurstyConfig = &BurstyConfig{
+	Interval:         time.Second,
+	BurstSize:        0.5,
+	BurstProbability: 0.2,
+	DownTicks:        3,
+}
+
+//The bursty mockerFn connects the nodes in a ring, then on each tick with
+//probability conf.Bursty.BurstProbability stops a large fraction of the
+//nodes at once, restarting them after conf.Bursty.DownTicks ticks. No
+//further bursts happen while nodes are down, so the network always
+//recovers to conf.NodeCount up nodes between bursts
+func bursty(net *Network, quit chan struct{}, mockerConf *MockerConfig) {
+	nodes, err := connectNodesInRing(net, mockerConf)
+	if err != nil {
+		panic("Could not startup node network for mocker")
+	}
+	conf := mockerConf.bursty()
+	r := mockerConf.rand()
+	tick := time.NewTicker(conf.Interval)
+	defer tick.Stop()
+	var down []discover.NodeID
+	var downTicks int
+	for {
+		select {
+		case <-quit:
+			log.Info("Terminating simulation loop")
+			return
+		case <-tick.C:
+		}
+		if !WaitWhilePaused(quit) {
+			log.Info("Terminating simulation loop")
+			return
+		}
+		if len(down) > 0 {
+			downTicks++
+			if downTicks < conf.DownTicks {
+				continue
+			}
+			log.Debug("ending burst", "nodes", len(down))
+			for _, id := range down {
+				if err := net.Start(id); err != nil {
+					log.Error("error starting node", "id", id, "err", err)
+				}
+			}
+			down, downTicks = nil, 0
+			continue
+		}
+		down = burstNodes(nodes, conf, r)
+		if len(down) > 0 {
+			log.Debug("starting burst", "nodes", len(down))
+		}
+		for _, id := range down {
+			if err := net.Stop(id); err != nil {
+				log.Error("error stopping node", "id", id, "err", err)
+			}
+		}
+	}
+}
+
+//return the nodes to stop on a tick of the bursty mocker, which is either
+//none or a random conf.BurstSize fraction of ids (at least one node)
+func burstNodes(ids []discover.NodeID, conf *BurstyConfig, r *rand.Rand) []discover.NodeID {
+	if len(ids) == 0 || r.Float64() >= conf.BurstProbability {
+		return nil
+	}
+	size := int(conf.BurstSize * float64(len(ids)))
+	if size < 1 {
+		size = 1
+	}
+	if size > len(ids) {
+		size = len(ids)
+	}
+	down := make([]discover.NodeID, size)
+	for i, j := range r.Perm(len(ids))[:size] {
+		down[i] = ids[j]
+	}
+	return down
+}
+
+//Distribution samples a random duration, such as the length of a node's
+//session or of its downtime
+type Distribution func(r *rand.Rand) time.Duration
+
+//ExponentialDistribution samples memoryless durations with the given mean
+func ExponentialDistribution(mean time.Duration) Distribution {
+	return func(r *rand.Rand) time.Duration {
+		return time.Duration(r.ExpFloat64() * float64(mean))
+	}
+}
+
+//ParetoDistribution samples heavy-tailed durations of at least min, where
+//a smaller shape alpha gives a heavier tail (the mean is infinite for
+//alpha <= 1)
+func ParetoDistribution(min time.Duration, alpha float64) Distribution {
+	return func(r *rand.Rand) time.Duration {
+		u := 1 - r.Float64()
+		return time.Duration(float64(min) / math.Pow(u, 1/alpha))
+	}
+}
+
+//WeibullDistribution samples durations with the given scale and shape,
+//where a shape below 1 gives a heavier tail than the exponential
+//distribution and a shape of 1 is the exponential distribution
+func WeibullDistribution(scale time.Duration, shape float64) Distribution {
+	return func(r *rand.Rand) time.Duration {
+		u := 1 - r.Float64()
+		return time.Duration(float64(scale) * math.Pow(-math.Log(u), 1/shape))
+	}
+}
+
+//TraceDistribution samples durations uniformly from a list of measured
+//durations, e.g. the session lengths recorded in a real network
+func TraceDistribution(trace []time.Duration) Distribution {
+	return func(r *rand.Rand) time.Duration {
+		if len(trace) == 0 {
+			return 0
+		}
+		return trace[r.Intn(len(trace))]
+	}
+}
+
+//DistributionConfig selects a Distribution and its parameters so that it
+//can be given in a JSON MockerConfig
+type DistributionConfig struct {
+	//Type is one of "exponential", "pareto", "weibull" or "trace"
+	Type string `json:"type"`
+	//Scale is the mean of an exponential distribution, the minimum of a
+	//Pareto distribution or the scale of a Weibull distribution
+	Scale time.Duration `json:"scale,omitempty"`
+	//Shape is the shape of a Pareto or Weibull distribution
+	Shape float64 `json:"shape,omitempty"`
+	//Trace is the list of durations a trace distribution samples from
+	Trace []time.Duration `json:"trace,omitempty"`
+}
+
+//Validate checks the distribution type is known and has the parameters
+//it needs
+func (c *DistributionConfig) Validate() error {
+	switch c.Type {
+	case "exponential":
+	case "pareto", "weibull":
+		if c.Shape <= 0 {
+			return fmt.Errorf("%s shape must be positive, got %v", c.Type, c.Shape)
+		}
+	case "trace":
+		if len(c.Trace) == 0 {
+			return errors.New("trace must not be empty")
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown distribution type %q", c.Type)
+	}
+	if c.Scale <= 0 {
+		return fmt.Errorf("%s scale must be positive, got %v", c.Type, c.Scale)
+	}
+	return nil
+}
+
+//Distribution returns the configured distribution, which must be valid
+func (c *DistributionConfig) Distribution() Distribution {
+	switch c.Type {
+	case "pareto":
+		return ParetoDistribution(c.Scale, c.Shape)
+	case "weibull":
+		return WeibullDistribution(c.Scale, c.Shape)
+	case "trace":
+		return TraceDistribution(c.Trace)
+	default:
+		return ExponentialDistribution(c.Scale)
+	}
+}
+
+//ChurnConfig configures per-node churn, where each node stays up for a
+//sampled session length, then stays down for a sampled downtime before it
+//is started again
+type ChurnConfig struct {
+	//Session samples how long a node stays up
+	Session DistributionConfig `json:"session"`
+	//Downtime samples how long a node stays down
+	Downtime DistributionConfig `json:"downtime"`
+	//CrashProbability is the probability that a session ends with the node
+	//crashing rather than stopping gracefully (see Network.Crash)
+	CrashProbability float64 `json:"crash_probability,omitempty"`
+}
+
+//DefaultChurnConfig is the configuration used by the churn mocker when
+//MockerConfig.Churn is not set
+var DefaultChurnConfig = &ChurnConfig{
+	Session:  DistributionConfig{Type: "pareto", Scale: 5 * time.Second, Shape: 1.5},
+	Downtime: DistributionConfig{Type: "exponential", Scale: 3 * time.Second},
+}
+
+//The churn mockerFn connects the nodes in a ring, then stops and starts
+//each node independently, with session and downtime lengths sampled from
+//the distributions in conf.Churn
+func churn(net *Network, quit chan struct{}, mockerConf *MockerConfig) {
+	nodes, err := connectNodesInRing(net, mockerConf)
+	if err != nil {
+		panic("Could not startup node network for mocker")
+	}
+	conf := mockerConf.churn()
+	r := mockerConf.rand()
+	var wg sync.WaitGroup
+	wg.Add(len(nodes))
+	for _, id := range nodes {
+		//rand.Rand isn't safe for concurrent use, so give each node its own
+		go func(id discover.NodeID, r *rand.Rand) {
+			defer wg.Done()
+			churnNode(net, quit, id, conf, r)
+		}(id, rand.New(rand.NewSource(r.Int63())))
+	}
+	wg.Wait()
+	log.Info("Terminating simulation loop")
+}
+
+//stop and start a node at sampled intervals until quit is closed
+func churnNode(net *Network, quit chan struct{}, id discover.NodeID, conf *ChurnConfig, r *rand.Rand) {
+	session, downtime := conf.Session.Distribution(), conf.Downtime.Distribution()
+	for {
+		select {
+		case <-quit:
+			return
+		case <-time.After(session(r)):
+		}
+		if !WaitWhilePaused(quit) {
+			return
+		}
+		log.Debug("node session ended", "id", id)
+		if r.Float64() < conf.CrashProbability {
+			if err := net.Crash(id); err != nil {
+				log.Error("error crashing node", "id", id, "err", err)
+			}
+		} else if err := net.Stop(id); err != nil {
+			log.Error("error stopping node", "id", id, "err", err)
+		}
+		select {
+		case <-quit:
+			return
+		case <-time.After(downtime(r)):
+		}
+		if !WaitWhilePaused(quit) {
+			return
+		}
 		if err := net.Start(id); err != nil {
-			log.Error("Error starting a node! %s", err)
-			return nil, err
+			log.Error("error starting node", "id", id, "err", err)
+		}
+	}
+}
+
+//ZonedConfig configures the zoned mocker, which spreads the nodes over
+//zones and prefers connecting nodes in the same zone
+type ZonedConfig struct {
+	//Zones are the labels of the zones the nodes are assigned to in turn
+	//(see adapters.NodeConfig.Zone)
+	Zones []string `json:"zones"`
+	//Degree is the number of peers each node picks to connect to
+	Degree int `json:"degree"`
+	//IntraZoneBias is the probability that a node picks a peer from its
+	//own zone rather than from the whole network
+	IntraZoneBias float64 `json:"intra_zone_bias"`
+}
+
+//DefaultZonedConfig is the configuration used by the zoned mocker when
+//MockerConfig.Zoned is not set
+var DefaultZonedConfig = &ZonedConfig{
+	Zones:         []string{"eu", "us", "asia"},
+	Degree:        3,
+	IntraZoneBias: 0.8,
+}
+
+//The zoned mockerFn creates nodes spread over the zones of conf.Zoned and
+//connects each to peers picked with a bias toward its own zone, then
+//doesn't do anything else. The latencies between zones are set on the
+//adapter (see adapters.SimAdapter.SetZoneLatencies)
+func zoned(net *Network, quit chan struct{}, mockerConf *MockerConfig) {
+	conf := mockerConf.zoned()
+	r := mockerConf.rand()
+	nodeConfs, err := mockerNodeConfigs(mockerConf, r)
+	if err != nil {
+		panic("Could not startup node network for mocker")
+	}
+	zones := make(map[discover.NodeID]string, len(nodeConfs))
+	for i, nodeConf := range nodeConfs {
+		nodeConf.Zone = conf.Zones[i%len(conf.Zones)]
+		zones[nodeConf.ID] = nodeConf.Zone
+	}
+	ids, err := startNodeConfigs(net, nodeConfs)
+	if err != nil {
+		panic("Could not startup node network for mocker")
+	}
+	if err := net.ConnectNodes(dialablePairs(net, zonedPairs(ids, zones, conf, r))); err != nil {
+		log.Error("error connecting nodes", "err", err)
+	}
+}
+
+//pick conf.Degree peers for each node, each being picked from the node's
+//own zone with probability conf.IntraZoneBias and from all nodes otherwise,
+//returning each pair of nodes once
+func zonedPairs(ids []discover.NodeID, zones map[discover.NodeID]string, conf *ZonedConfig, r *rand.Rand) [][2]discover.NodeID {
+	byZone := make(map[string][]discover.NodeID)
+	for _, id := range ids {
+		byZone[zones[id]] = append(byZone[zones[id]], id)
+	}
+	seen := make(map[string]bool)
+	var pairs [][2]discover.NodeID
+	for _, id := range ids {
+		for i := 0; i < conf.Degree; i++ {
+			candidates := ids
+			if r.Float64() < conf.IntraZoneBias {
+				candidates = byZone[zones[id]]
+			}
+			peer := candidates[r.Intn(len(candidates))]
+			label := ConnLabel(id, peer)
+			if peer == id || seen[label] {
+				continue
+			}
+			seen[label] = true
+			pairs = append(pairs, [2]discover.NodeID{id, peer})
+		}
+	}
+	return pairs
+}
+
+//connect conf.NodeCount number of nodes in a ring
+func connectNodesInRing(net *Network, conf *MockerConfig) ([]discover.NodeID, error) {
+	return connectNodes(net, conf, topology.Ring)
+}
+
+//create and start conf.NodeCount number of nodes and connect the pairs of
+//nodes returned by the topology function, skipping pairs which are both
+//behind a NAT since neither can dial the other
+func connectNodes(net *Network, conf *MockerConfig, topology func([]discover.NodeID) [][2]discover.NodeID) ([]discover.NodeID, error) {
+	ids, err := startNodes(net, conf)
+	if err != nil {
+		return nil, err
+	}
+	if err := net.ConnectNodes(dialablePairs(net, topology(ids))); err != nil {
+		log.Error("Error connecting a node to a peer! %s", err)
+		return nil, err
+	}
+
+	return ids, nil
+}
+
+//return the pairs of nodes where one can dial the other, leaving out pairs
+//which are both behind a NAT
+func dialablePairs(net *Network, pairs [][2]discover.NodeID) [][2]discover.NodeID {
+	var dialable [][2]discover.NodeID
+	for _, pair := range pairs {
+		if net.GetNode(pair[0]).Config.NAT && net.GetNode(pair[1]).Config.NAT {
+			log.Debug(fmt.Sprintf("not connecting %v and %v behind NATs", pair[0], pair[1]))
+			continue
 		}
-		log.Debug(fmt.Sprintf("node %v starting up", id))
+		dialable = append(dialable, pair)
 	}
-	for i, id := range ids {
-		peerID := ids[(i+1)%len(ids)]
-		if err := net.Connect(id, peerID); err != nil {
-			log.Error("Error connecting a node to a peer! %s", err)
+	return dialable
+}
+
+//CorePeripheryConfig configures a two-tier topology consisting of a fully
+//connected core of stable nodes and a periphery of nodes which only connect
+//to core nodes
+type CorePeripheryConfig struct {
+	//CoreSize is the number of nodes in the fully connected core
+	CoreSize int `json:"core_size"`
+	//PeripheryPeers is the number of random core nodes each periphery
+	//node connects to
+	PeripheryPeers int `json:"periphery_peers"`
+}
+
+//DefaultCorePeripheryConfig is the configuration used by the corePeriphery
+//mocker when MockerConfig.CorePeriphery is not set
+var DefaultCorePeripheryConfig = &CorePeripheryConfig{
+	CoreSize:       4,
+	PeripheryPeers: 2,
+}
+
+//connect conf.NodeCount number of nodes in a core-periphery topology
+func connectNodesInCorePeriphery(net *Network, conf *MockerConfig) ([]discover.NodeID, error) {
+	r := conf.rand()
+	cp := conf.corePeriphery()
+	return connectNodes(net, conf, func(ids []discover.NodeID) [][2]discover.NodeID {
+		return topology.CorePeriphery(ids, cp.CoreSize, cp.PeripheryPeers, r)
+	})
+}
+
+//create and start conf.NodeCount number of nodes, with IDs derived from
+//conf.Seed so that the same seed always creates the same nodes, putting
+//conf.NATFraction of them behind a NAT
+func startNodes(net *Network, conf *MockerConfig) ([]discover.NodeID, error) {
+	nodeConfs, err := mockerNodeConfigs(conf, conf.rand())
+	if err != nil {
+		return nil, err
+	}
+	return startNodeConfigs(net, nodeConfs)
+}
+
+//generate the configs of conf.NodeCount nodes with keys taken from r,
+//putting conf.NATFraction of them behind a NAT
+func mockerNodeConfigs(conf *MockerConfig, r *rand.Rand) ([]*adapters.NodeConfig, error) {
+	nodeConfs, err := adapters.SeededNodeConfigs(conf.NodeCount, r)
+	if err != nil {
+		log.Error("Error generating node keys! %s", err)
+		return nil, err
+	}
+	if nat := int(conf.NATFraction * float64(len(nodeConfs))); nat > 0 {
+		for _, i := range r.Perm(len(nodeConfs))[:nat] {
+			nodeConfs[i].NAT = true
+		}
+	}
+	return nodeConfs, nil
+}
+
+//create and start a node with each of the configs
+func startNodeConfigs(net *Network, nodeConfs []*adapters.NodeConfig) ([]discover.NodeID, error) {
+	ids := make([]discover.NodeID, len(nodeConfs))
+	for i, nodeConf := range nodeConfs {
+		node, err := net.NewNodeWithConfig(nodeConf)
+		if err != nil {
+			log.Error("Error creating a node! %s", err)
 			return nil, err
 		}
+		ids[i] = node.ID()
 	}
 
+	if err := net.StartNodes(ids); err != nil {
+		log.Error("Error starting a node! %s", err)
+		return nil, err
+	}
+	log.Debug(fmt.Sprintf("%d nodes starting up", len(ids)))
 	return ids, nil
 }
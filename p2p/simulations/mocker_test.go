@@ -20,14 +20,20 @@ package simulations
 
 import (
 	"encoding/json"
+	"math/rand"
 	"net/http"
 	"net/url"
+	"reflect"
 	"strconv"
+	"strings"
 	"sync"
 	"testing"
 	"time"
 
+	"github.com/ethereum/go-ethereum/node"
 	"github.com/ethereum/go-ethereum/p2p/discover"
+	"github.com/ethereum/go-ethereum/p2p/simulations/adapters"
+	"github.com/ethereum/go-ethereum/p2p/simulations/topology"
 )
 
 func TestMocker(t *testing.T) {
@@ -169,3 +175,495 @@ func TestMocker(t *testing.T) {
 		t.Fatalf("Expected empty list of nodes, got: %d", len(nodes_info))
 	}
 }
+
+func TestMockerSeed(t *testing.T) {
+	nodes := testGraphNodes(10)
+	ids := make([]discover.NodeID, len(nodes))
+	for i, node := range nodes {
+		ids[i] = node.ID()
+	}
+
+	//mockers using sources with the same seed should make the same choices
+	one, other := rand.New(rand.NewSource(42)), rand.New(rand.NewSource(42))
+	if pairs, otherPairs := topology.CorePeriphery(ids, 4, 2, one), topology.CorePeriphery(ids, 4, 2, other); !reflect.DeepEqual(pairs, otherPairs) {
+		t.Fatalf("Expected the same core-periphery connections, got %v and %v", pairs, otherPairs)
+	}
+	for i := 0; i < 100; i++ {
+		low, high := probabilisticRange(one, len(ids))
+		otherLow, otherHigh := probabilisticRange(other, len(ids))
+		if low != otherLow || high != otherHigh {
+			t.Fatalf("Expected the same nodes to be restarted, got [%d, %d) and [%d, %d)", low, high, otherLow, otherHigh)
+		}
+	}
+}
+
+//mockers with the same seed should create nodes with the same IDs
+func TestMockerSeedNodeIds(t *testing.T) {
+	nodeIds := func(seed int64) []discover.NodeID {
+		conf := &MockerConfig{NodeCount: 5, Seed: seed}
+		confs, err := adapters.SeededNodeConfigs(conf.NodeCount, conf.rand())
+		if err != nil {
+			t.Fatalf("Could not generate node configs: %s", err)
+		}
+		ids := make([]discover.NodeID, len(confs))
+		for i, conf := range confs {
+			ids[i] = conf.ID
+		}
+		return ids
+	}
+	if ids, otherIds := nodeIds(42), nodeIds(42); !reflect.DeepEqual(ids, otherIds) {
+		t.Fatalf("Expected the same node IDs, got %v and %v", ids, otherIds)
+	}
+	if ids, otherIds := nodeIds(42), nodeIds(43); reflect.DeepEqual(ids, otherIds) {
+		t.Fatalf("Expected different node IDs for different seeds, got %v", ids)
+	}
+}
+
+//mockers should put conf.NATFraction of the nodes behind a NAT and only
+//connect pairs of nodes where one can dial the other
+func TestMockerNAT(t *testing.T) {
+	adapter := adapters.NewSimAdapter(adapters.Services{
+		"noop": func(ctx *adapters.ServiceContext) (node.Service, error) {
+			return noopService{}, nil
+		},
+	})
+	network := NewNetwork(adapter, &NetworkConfig{
+		DefaultService: "noop",
+	})
+	defer network.Shutdown()
+
+	conf := &MockerConfig{NodeCount: 8, Seed: 42, NATFraction: 0.5}
+	ids, err := connectNodesInRing(network, conf)
+	if err != nil {
+		t.Fatalf("Could not connect nodes: %s", err)
+	}
+	nat := make(map[discover.NodeID]bool)
+	for _, id := range ids {
+		if network.GetNode(id).Config.NAT {
+			nat[id] = true
+		}
+	}
+	if len(nat) != 4 {
+		t.Fatalf("Expected 4 nodes behind a NAT, got %d", len(nat))
+	}
+	var expected int
+	for _, pair := range topology.Ring(ids) {
+		if !nat[pair[0]] || !nat[pair[1]] {
+			expected++
+		}
+	}
+	if err := NewNetworkExpectation().ConnsUp(expected).Within(network, 10*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	for _, pair := range topology.FullMesh(ids) {
+		if nat[pair[0]] && nat[pair[1]] && network.GetConn(pair[0], pair[1]) != nil {
+			t.Fatalf("Expected no connection between nodes behind a NAT, got %s", ConnLabel(pair[0], pair[1]))
+		}
+	}
+}
+
+func TestMockerInvalidNodeCount(t *testing.T) {
+	_, s := testHTTPServer(t)
+	defer s.Close()
+
+	for _, nodeCount := range []string{"", "abc", "-1", "0", "1"} {
+		resp, err := http.PostForm(s.URL+"/mocker/start", url.Values{"mocker-type": {"boot"}, "node-count": {nodeCount}})
+		if err != nil {
+			t.Fatalf("Could not start mocker: %s", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Fatalf("Invalid Status Code received for node-count %q, expected %d, got %d", nodeCount, http.StatusBadRequest, resp.StatusCode)
+		}
+	}
+}
+
+//the probabilistic mocker stops and restarts nodes within the node count
+//rather than assuming there are 10 nodes
+func TestProbabilisticRange(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for nodeCount := 2; nodeCount <= 10; nodeCount++ {
+		for i := 0; i < 1000; i++ {
+			low, high := probabilisticRange(r, nodeCount)
+			if low < 0 || high > nodeCount || low > high {
+				t.Fatalf("Invalid range for %d nodes: [%d, %d)", nodeCount, low, high)
+			}
+		}
+	}
+}
+
+func TestParseMockerConfig(t *testing.T) {
+	//omitted fields should keep their defaults
+	conf, err := ParseMockerConfig(strings.NewReader(`{"node_count": 20}`))
+	if err != nil {
+		t.Fatalf("Could not parse mocker config: %s", err)
+	}
+	if conf.Type != DefaultMockerConfig().Type {
+		t.Fatalf("Expected default mocker type %q, got %q", DefaultMockerConfig().Type, conf.Type)
+	}
+	if conf.NodeCount != 20 {
+		t.Fatalf("Expected node count 20, got %d", conf.NodeCount)
+	}
+
+	//the seed should be decoded rather than taken from the current time
+	conf, err = ParseMockerConfig(strings.NewReader(`{"seed": 42}`))
+	if err != nil {
+		t.Fatalf("Could not parse mocker config: %s", err)
+	}
+	if conf.Seed != 42 {
+		t.Fatalf("Expected seed 42, got %d", conf.Seed)
+	}
+
+	//an empty body should result in the default config
+	conf, err = ParseMockerConfig(strings.NewReader(""))
+	if err != nil {
+		t.Fatalf("Could not parse mocker config: %s", err)
+	}
+	if conf.Type != DefaultMockerConfig().Type || conf.NodeCount != DefaultMockerConfig().NodeCount {
+		t.Fatalf("Expected default mocker config %v, got %v", DefaultMockerConfig(), conf)
+	}
+
+	for _, invalid := range []string{
+		`{"type": "unknown"}`,
+		`{"node_count": 1}`,
+		`{"node_count": "10"}`,
+	} {
+		if _, err := ParseMockerConfig(strings.NewReader(invalid)); err == nil {
+			t.Fatalf("Expected an error parsing mocker config %s", invalid)
+		}
+	}
+
+	//the HTTP API should reject invalid JSON configs
+	_, s := testHTTPServer(t)
+	defer s.Close()
+	err = NewClient(s.URL).StartMocker(&MockerConfig{Type: "boot", NodeCount: 1})
+	if err == nil || !strings.Contains(err.Error(), "400") {
+		t.Fatalf("Expected a 400 error starting an invalid mocker, got %v", err)
+	}
+}
+
+func TestBurstNodes(t *testing.T) {
+	nodes := testGraphNodes(10)
+	ids := make([]discover.NodeID, len(nodes))
+	for i, node := range nodes {
+		ids[i] = node.ID()
+	}
+	r := rand.New(rand.NewSource(1))
+
+	//a burst which always happens stops BurstSize of the nodes, each once
+	conf := &BurstyConfig{BurstSize: 0.3, BurstProbability: 1}
+	down := burstNodes(ids, conf, r)
+	if len(down) != 3 {
+		t.Fatalf("Expected 3 nodes to be stopped, got %d", len(down))
+	}
+	seen := make(map[discover.NodeID]bool)
+	for _, id := range down {
+		if seen[id] {
+			t.Fatalf("Expected node %s to be stopped once", id.TerminalString())
+		}
+		seen[id] = true
+	}
+
+	//small bursts still stop a node
+	conf = &BurstyConfig{BurstSize: 0.01, BurstProbability: 1}
+	if down := burstNodes(ids, conf, r); len(down) != 1 {
+		t.Fatalf("Expected 1 node to be stopped, got %d", len(down))
+	}
+
+	//a burst which never happens stops nothing
+	conf = &BurstyConfig{BurstSize: 0.3, BurstProbability: 0}
+	if down := burstNodes(ids, conf, r); len(down) != 0 {
+		t.Fatalf("Expected no nodes to be stopped, got %d", len(down))
+	}
+}
+
+func TestMockerTypeConfig(t *testing.T) {
+	//mockers should use the defaults unless their config is set
+	conf := DefaultMockerConfig()
+	if conf.bursty() != DefaultBurstyConfig || conf.corePeriphery() != DefaultCorePeripheryConfig || conf.churn() != DefaultChurnConfig || conf.zoned() != DefaultZonedConfig {
+		t.Fatal("Expected the default bursty, core-periphery, churn and zoned configs")
+	}
+
+	conf, err := ParseMockerConfig(strings.NewReader(`{
+		"type": "bursty",
+		"bursty": {"interval": 1000000, "burst_size": 0.1, "burst_probability": 1, "down_ticks": 2},
+		"core_periphery": {"core_size": 3, "periphery_peers": 1},
+		"churn": {
+			"session": {"type": "weibull", "scale": 2000000000, "shape": 0.5},
+			"downtime": {"type": "trace", "trace": [1000000000, 60000000000]}
+		},
+		"zoned": {"zones": ["a", "b"], "degree": 2, "intra_zone_bias": 0.5}
+	}`))
+	if err != nil {
+		t.Fatalf("Could not parse mocker config: %s", err)
+	}
+	if bursty := conf.bursty(); *bursty != (BurstyConfig{Interval: time.Millisecond, BurstSize: 0.1, BurstProbability: 1, DownTicks: 2}) {
+		t.Fatalf("Unexpected bursty config %+v", bursty)
+	}
+	if corePeriphery := conf.corePeriphery(); *corePeriphery != (CorePeripheryConfig{CoreSize: 3, PeripheryPeers: 1}) {
+		t.Fatalf("Unexpected core-periphery config %+v", corePeriphery)
+	}
+
+	churn := conf.churn()
+	if churn.Session.Type != "weibull" || churn.Session.Scale != 2*time.Second || churn.Session.Shape != 0.5 {
+		t.Fatalf("Unexpected churn session config %+v", churn.Session)
+	}
+	if !reflect.DeepEqual(churn.Downtime.Trace, []time.Duration{time.Second, time.Minute}) {
+		t.Fatalf("Unexpected churn downtime config %+v", churn.Downtime)
+	}
+	if zoned := conf.zoned(); !reflect.DeepEqual(zoned, &ZonedConfig{Zones: []string{"a", "b"}, Degree: 2, IntraZoneBias: 0.5}) {
+		t.Fatalf("Unexpected zoned config %+v", zoned)
+	}
+
+	//the bursty mocker can't tick without an interval
+	if _, err := ParseMockerConfig(strings.NewReader(`{"bursty": {"burst_size": 0.1}}`)); err == nil {
+		t.Fatal("Expected an error parsing a bursty config without an interval")
+	}
+	if _, err := ParseMockerConfig(strings.NewReader(`{"nat_fraction": 1.5}`)); err == nil {
+		t.Fatal("Expected an error parsing a NAT fraction above 1")
+	}
+	for _, invalid := range []string{
+		`{"zoned": {"degree": 2, "intra_zone_bias": 0.5}}`,
+		`{"zoned": {"zones": ["a"], "intra_zone_bias": 0.5}}`,
+		`{"zoned": {"zones": ["a"], "degree": 2, "intra_zone_bias": 2}}`,
+	} {
+		if _, err := ParseMockerConfig(strings.NewReader(invalid)); err == nil {
+			t.Fatalf("Expected an error parsing invalid zoned config %s", invalid)
+		}
+	}
+	for _, invalid := range []string{
+		`{"churn": {"session": {"type": "normal", "scale": 1}, "downtime": {"type": "exponential", "scale": 1}}}`,
+		`{"churn": {"session": {"type": "pareto", "scale": 1}, "downtime": {"type": "exponential", "scale": 1}}}`,
+		`{"churn": {"session": {"type": "exponential", "scale": 1}, "downtime": {"type": "trace"}}}`,
+		`{"churn": {"session": {"type": "exponential", "scale": 1}, "downtime": {"type": "exponential", "scale": 1}, "crash_probability": 2}}`,
+	} {
+		if _, err := ParseMockerConfig(strings.NewReader(invalid)); err == nil {
+			t.Fatalf("Expected an error parsing invalid churn config %s", invalid)
+		}
+	}
+}
+
+func TestZonedPairs(t *testing.T) {
+	nodes := testGraphNodes(12)
+	ids := make([]discover.NodeID, len(nodes))
+	zones := make(map[discover.NodeID]string)
+	for i, node := range nodes {
+		ids[i] = node.ID()
+		zones[ids[i]] = []string{"a", "b", "c"}[i%3]
+	}
+	r := rand.New(rand.NewSource(1))
+
+	//a full bias only connects nodes in the same zone, each pair once
+	conf := &ZonedConfig{Degree: 3, IntraZoneBias: 1}
+	pairs := zonedPairs(ids, zones, conf, r)
+	if len(pairs) == 0 {
+		t.Fatal("Expected nodes to be paired")
+	}
+	seen := make(map[string]bool)
+	for _, pair := range pairs {
+		if zones[pair[0]] != zones[pair[1]] {
+			t.Fatalf("Expected nodes in the same zone, got %s and %s", zones[pair[0]], zones[pair[1]])
+		}
+		if pair[0] == pair[1] || seen[ConnLabel(pair[0], pair[1])] {
+			t.Fatalf("Expected distinct nodes paired once, got %v", pair)
+		}
+		seen[ConnLabel(pair[0], pair[1])] = true
+	}
+
+	//no bias connects nodes across zones
+	conf = &ZonedConfig{Degree: 3, IntraZoneBias: 0}
+	var across bool
+	for _, pair := range zonedPairs(ids, zones, conf, r) {
+		across = across || zones[pair[0]] != zones[pair[1]]
+	}
+	if !across {
+		t.Fatal("Expected nodes in different zones to be paired")
+	}
+}
+
+func TestDistributions(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	const samples = 10000
+	mean := func(d Distribution) time.Duration {
+		var total time.Duration
+		for i := 0; i < samples; i++ {
+			total += d(r)
+		}
+		return total / samples
+	}
+	near := func(got, want time.Duration) bool {
+		return got > want*9/10 && got < want*11/10
+	}
+
+	if got := mean(ExponentialDistribution(time.Second)); !near(got, time.Second) {
+		t.Fatalf("Expected exponential mean near 1s, got %s", got)
+	}
+	//a Weibull distribution with shape 1 is exponential
+	if got := mean(WeibullDistribution(time.Second, 1)); !near(got, time.Second) {
+		t.Fatalf("Expected Weibull mean near 1s, got %s", got)
+	}
+	//the Pareto mean is alpha*min/(alpha-1)
+	if got := mean(ParetoDistribution(time.Second, 3)); !near(got, 1500*time.Millisecond) {
+		t.Fatalf("Expected Pareto mean near 1.5s, got %s", got)
+	}
+	pareto := ParetoDistribution(time.Second, 1.5)
+	for i := 0; i < samples; i++ {
+		if d := pareto(r); d < time.Second {
+			t.Fatalf("Expected Pareto samples of at least 1s, got %s", d)
+		}
+	}
+
+	trace := []time.Duration{time.Second, time.Minute}
+	seen := make(map[time.Duration]bool)
+	sample := TraceDistribution(trace)
+	for i := 0; i < 100; i++ {
+		seen[sample(r)] = true
+	}
+	if len(seen) != 2 || !seen[time.Second] || !seen[time.Minute] {
+		t.Fatalf("Expected samples from the trace only, got %v", seen)
+	}
+}
+
+func TestRegisterMocker(t *testing.T) {
+	var calls int
+	fn := func(net *Network, quit chan struct{}, conf *MockerConfig) {
+		calls += conf.NodeCount
+	}
+	if err := RegisterMocker("testRegister", fn); err != nil {
+		t.Fatal(err)
+	}
+	defer unregisterMocker("testRegister")
+	if err := RegisterMocker("testRegister", fn); err == nil {
+		t.Fatal("Expected registering a mocker twice to fail")
+	}
+	if err := RegisterMocker("boot", fn); err == nil {
+		t.Fatal("Expected registering a built-in mocker name to fail")
+	}
+
+	conf := &MockerConfig{Type: "testRegister", NodeCount: 3}
+	if err := conf.Validate(); err != nil {
+		t.Fatalf("Expected registered mocker to be valid, got %v", err)
+	}
+	LookupMocker(conf.Type)(nil, nil, conf)
+	if calls != 3 {
+		t.Fatalf("Expected registered mocker to be called with 3 nodes, got %d", calls)
+	}
+	var found bool
+	for _, name := range GetMockerList() {
+		if name == "testRegister" {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatal("Expected registered mocker to be listed")
+	}
+}
+
+func TestPauseMocker(t *testing.T) {
+	quit := make(chan struct{})
+	if !WaitWhilePaused(quit) {
+		t.Fatal("Expected a running mocker not to wait")
+	}
+	if !PauseMocker(quit) {
+		t.Fatal("Expected the mocker to be paused")
+	}
+	if PauseMocker(quit) {
+		t.Fatal("Expected pausing a paused mocker to fail")
+	}
+	resumed := make(chan bool)
+	go func() { resumed <- WaitWhilePaused(quit) }()
+	select {
+	case <-resumed:
+		t.Fatal("Expected a paused mocker to wait")
+	case <-time.After(50 * time.Millisecond):
+	}
+	if !ResumeMocker(quit) {
+		t.Fatal("Expected the mocker to be resumed")
+	}
+	if !<-resumed {
+		t.Fatal("Expected a resumed mocker to carry on")
+	}
+	if ResumeMocker(quit) {
+		t.Fatal("Expected resuming a running mocker to fail")
+	}
+
+	//stopping a paused mocker should stop it waiting and forget it
+	PauseMocker(quit)
+	go func() { resumed <- WaitWhilePaused(quit) }()
+	close(quit)
+	if <-resumed {
+		t.Fatal("Expected a stopped mocker not to carry on")
+	}
+	for i := 0; ; i++ {
+		pausedMockerMtx.Lock()
+		_, paused := pausedMockers[quit]
+		pausedMockerMtx.Unlock()
+		if !paused {
+			break
+		}
+		if i == 100 {
+			t.Fatal("Expected a stopped mocker to be forgotten")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestHTTPPauseMocker(t *testing.T) {
+	//a mocker which takes a step each time it isn't paused
+	steps := make(chan struct{})
+	err := RegisterMocker("testPause", func(net *Network, quit chan struct{}, conf *MockerConfig) {
+		for WaitWhilePaused(quit) {
+			select {
+			case steps <- struct{}{}:
+			case <-quit:
+				return
+			}
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unregisterMocker("testPause")
+
+	_, s := testHTTPServer(t)
+	defer s.Close()
+	client := NewClient(s.URL)
+	if err := client.PauseMocker(); err == nil {
+		t.Fatal("Expected an error pausing a mocker which isn't running")
+	}
+	if err := client.StartMocker(&MockerConfig{Type: "testPause", NodeCount: 2}); err != nil {
+		t.Fatalf("Could not start mocker: %s", err)
+	}
+	defer client.StopMocker()
+	<-steps
+
+	if err := client.PauseMocker(); err != nil {
+		t.Fatalf("Could not pause mocker: %s", err)
+	}
+	//the mocker may have passed WaitWhilePaused before being paused
+	select {
+	case <-steps:
+	case <-time.After(50 * time.Millisecond):
+	}
+	select {
+	case <-steps:
+		t.Fatal("Expected a paused mocker not to take steps")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	if err := client.ResumeMocker(); err != nil {
+		t.Fatalf("Could not resume mocker: %s", err)
+	}
+	select {
+	case <-steps:
+	case <-time.After(time.Second):
+		t.Fatal("Expected a resumed mocker to take steps")
+	}
+}
+
+//remove a mocker registered by a test so the test can be run again
+func unregisterMocker(name string) {
+	mockerLock.Lock()
+	defer mockerLock.Unlock()
+	delete(mockerList, name)
+}
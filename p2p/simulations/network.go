@@ -21,6 +21,8 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
+	"os"
 	"sync"
 	"time"
 
@@ -56,6 +58,10 @@ type Network struct {
 	Conns   []*Conn `json:"conns"`
 	connMap map[string]int
 
+	// partition maps partitioned nodes to their group, connections between
+	// nodes in different groups being refused until the partition is healed
+	partition map[discover.NodeID]int
+
 	nodeAdapter adapters.NodeAdapter
 	events      event.Feed
 	lock        sync.RWMutex
@@ -73,6 +79,28 @@ func NewNetwork(nodeAdapter adapters.NodeAdapter, conf *NetworkConfig) *Network
 	}
 }
 
+// MarshalJSON implements the json.Marshaler interface, encoding copies of
+// the nodes and connections taken with the lock held since they are updated
+// concurrently by the nodes' peer events
+func (self *Network) MarshalJSON() ([]byte, error) {
+	self.lock.RLock()
+	network := struct {
+		NetworkConfig
+		Nodes []*Node `json:"nodes"`
+		Conns []*Conn `json:"conns"`
+	}{NetworkConfig: self.NetworkConfig}
+	for _, node := range self.Nodes {
+		n := *node
+		network.Nodes = append(network.Nodes, &n)
+	}
+	for _, conn := range self.Conns {
+		c := *conn
+		network.Conns = append(network.Conns, &c)
+	}
+	self.lock.RUnlock()
+	return json.Marshal(network)
+}
+
 // Events returns the output event feed of the Network.
 func (self *Network) Events() *event.Feed {
 	return &self.events
@@ -80,7 +108,10 @@ func (self *Network) Events() *event.Feed {
 
 // NewNode adds a new node to the network with a random ID
 func (self *Network) NewNode() (*Node, error) {
-	conf := adapters.RandomNodeConfig()
+	conf, err := adapters.GenerateNodeConfig()
+	if err != nil {
+		return nil, err
+	}
 	conf.Services = []string{self.DefaultService}
 	return self.NewNodeWithConfig(conf)
 }
@@ -93,7 +124,10 @@ func (self *Network) NewNodeWithConfig(conf *adapters.NodeConfig) (*Node, error)
 
 	// create a random ID and PrivateKey if not set
 	if conf.ID == (discover.NodeID{}) {
-		c := adapters.RandomNodeConfig()
+		c, err := adapters.GenerateNodeConfig()
+		if err != nil {
+			return nil, err
+		}
 		conf.ID = c.ID
 		conf.PrivateKey = c.PrivateKey
 	}
@@ -149,8 +183,8 @@ func (self *Network) Config() *NetworkConfig {
 
 // StartAll starts all nodes in the network
 func (self *Network) StartAll() error {
-	for _, node := range self.Nodes {
-		if node.Up {
+	for _, node := range self.GetNodes() {
+		if self.isUp(node) {
 			continue
 		}
 		if err := self.Start(node.ID()); err != nil {
@@ -162,8 +196,8 @@ func (self *Network) StartAll() error {
 
 // StopAll stops all nodes in the network
 func (self *Network) StopAll() error {
-	for _, node := range self.Nodes {
-		if !node.Up {
+	for _, node := range self.GetNodes() {
+		if !self.isUp(node) {
 			continue
 		}
 		if err := self.Stop(node.ID()); err != nil {
@@ -173,6 +207,63 @@ func (self *Network) StopAll() error {
 	return nil
 }
 
+// batchConcurrency is the number of operations a batch applies at once
+var batchConcurrency = 32
+
+// StartNodes starts the nodes with the given IDs concurrently, which is
+// much faster than starting them one by one in large networks. All the
+// nodes are started even if some fail to.
+func (self *Network) StartNodes(ids []discover.NodeID) error {
+	return runBatch(len(ids), func(i int) error {
+		return self.Start(ids[i])
+	})
+}
+
+// StopNodes stops the nodes with the given IDs concurrently
+func (self *Network) StopNodes(ids []discover.NodeID) error {
+	return runBatch(len(ids), func(i int) error {
+		return self.Stop(ids[i])
+	})
+}
+
+// ConnectNodes connects the given pairs of nodes concurrently
+func (self *Network) ConnectNodes(pairs [][2]discover.NodeID) error {
+	return runBatch(len(pairs), func(i int) error {
+		return self.Connect(pairs[i][0], pairs[i][1])
+	})
+}
+
+// runBatch calls f for each of n operations, batchConcurrency at a time,
+// returning an error reporting how many failed
+func runBatch(n int, f func(i int) error) error {
+	var (
+		wg     sync.WaitGroup
+		mtx    sync.Mutex
+		failed int
+		first  error
+	)
+	sem := make(chan struct{}, batchConcurrency)
+	for i := 0; i < n; i++ {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(i int) {
+			defer func() { <-sem; wg.Done() }()
+			if err := f(i); err != nil {
+				mtx.Lock()
+				if failed++; first == nil {
+					first = err
+				}
+				mtx.Unlock()
+			}
+		}(i)
+	}
+	wg.Wait()
+	if failed > 0 {
+		return fmt.Errorf("%d of %d operations failed, first error: %v", failed, n, first)
+	}
+	return nil
+}
+
 // Start starts the node with the given ID
 func (self *Network) Start(id discover.NodeID) error {
 	return self.startWithSnapshots(id, nil)
@@ -185,18 +276,21 @@ func (self *Network) startWithSnapshots(id discover.NodeID, snapshots map[string
 	if node == nil {
 		return fmt.Errorf("node %v does not exist", id)
 	}
-	if node.Up {
+	if self.isUp(node) {
 		return fmt.Errorf("node %v already up", id)
 	}
-	log.Trace(fmt.Sprintf("starting node %v: %v using %v", id, node.Up, self.nodeAdapter.Name()))
+	log.Trace(fmt.Sprintf("starting node %v using %v", id, self.nodeAdapter.Name()))
 	if err := node.Start(snapshots); err != nil {
 		log.Warn(fmt.Sprintf("start up failed: %v", err))
 		return err
 	}
+	self.lock.Lock()
 	node.Up = true
-	log.Info(fmt.Sprintf("started node %v: %v", id, node.Up))
+	nodeEvent := NewEvent(node)
+	self.lock.Unlock()
+	log.Info(fmt.Sprintf("started node %v", id))
 
-	self.events.Send(NewEvent(node))
+	self.events.Send(nodeEvent)
 
 	// subscribe to peer events
 	client, err := node.Client()
@@ -208,22 +302,44 @@ func (self *Network) startWithSnapshots(id discover.NodeID, snapshots map[string
 	if err != nil {
 		return fmt.Errorf("error getting peer events for node %v: %s", id, err)
 	}
-	go self.watchPeerEvents(id, events, sub)
+	self.lock.Lock()
+	node.peerEvents = sub
+	self.lock.Unlock()
+
+	// subscribe to the faults injected into the node's messages, if any
+	var faults chan *adapters.MsgFault
+	var faultSub event.Subscription
+	if reporter, ok := node.Node.(adapters.FaultReporter); ok {
+		faults = make(chan *adapters.MsgFault)
+		faultSub = reporter.SubscribeFaults(faults)
+	}
+	go self.watchPeerEvents(id, events, sub, faults, faultSub)
 	return nil
 }
 
-// watchPeerEvents reads peer events from the given channel and emits
-// corresponding network events
-func (self *Network) watchPeerEvents(id discover.NodeID, events chan *p2p.PeerEvent, sub event.Subscription) {
+// watchPeerEvents reads peer events and message faults from the given
+// channels and emits corresponding network events
+func (self *Network) watchPeerEvents(id discover.NodeID, events chan *p2p.PeerEvent, sub event.Subscription, faults chan *adapters.MsgFault, faultSub event.Subscription) {
 	defer func() {
 		sub.Unsubscribe()
+		if faultSub != nil {
+			faultSub.Unsubscribe()
+		}
 
-		// assume the node is now down
+		// assume the node is now down, unless it has been stopped or
+		// restarted since (e.g. when it is upgraded)
 		self.lock.Lock()
 		node := self.getNode(id)
+		if node == nil || node.peerEvents != sub {
+			self.lock.Unlock()
+			return
+		}
 		node.Up = false
+		events := append([]*Event{NewEvent(node)}, self.dropConns(id)...)
 		self.lock.Unlock()
-		self.events.Send(NewEvent(node))
+		for _, event := range events {
+			self.events.Send(event)
+		}
 	}()
 	for {
 		select {
@@ -231,6 +347,11 @@ func (self *Network) watchPeerEvents(id discover.NodeID, events chan *p2p.PeerEv
 			if !ok {
 				return
 			}
+			// events from before the node was stopped may arrive after it
+			// has been restarted, and mustn't mark its new connections down
+			if !self.watching(id, sub) {
+				continue
+			}
 			peer := event.Peer
 			switch event.Type {
 
@@ -241,13 +362,16 @@ func (self *Network) watchPeerEvents(id discover.NodeID, events chan *p2p.PeerEv
 				self.DidDisconnect(id, peer)
 
 			case p2p.PeerEventTypeMsgSend:
-				self.DidSend(id, peer, event.Protocol, *event.MsgCode)
+				self.DidSend(id, peer, event.Protocol, *event.MsgCode, msgSize(event))
 
 			case p2p.PeerEventTypeMsgRecv:
-				self.DidReceive(peer, id, event.Protocol, *event.MsgCode)
+				self.DidReceive(peer, id, event.Protocol, *event.MsgCode, msgSize(event))
 
 			}
 
+		case fault := <-faults:
+			self.DidFault(id, fault.Peer, fault.Protocol, fault.Code, string(fault.Type))
+
 		case err := <-sub.Err():
 			if err != nil {
 				log.Error(fmt.Sprintf("error getting peer events for node %v", id), "err", err)
@@ -259,37 +383,113 @@ func (self *Network) watchPeerEvents(id discover.NodeID, events chan *p2p.PeerEv
 
 // Stop stops the node with the given ID
 func (self *Network) Stop(id discover.NodeID) error {
+	return self.stop(id, false)
+}
+
+// Crash stops the node with the given ID abruptly, so that its peers aren't
+// told it is disconnecting and only notice it has gone once their
+// connections to it time out. The node's adapter must support crashing
+// nodes (see adapters.Crasher).
+func (self *Network) Crash(id discover.NodeID) error {
+	return self.stop(id, true)
+}
+
+func (self *Network) stop(id discover.NodeID, crash bool) error {
 	node := self.GetNode(id)
 	if node == nil {
 		return fmt.Errorf("node %v does not exist", id)
 	}
-	if !node.Up {
+	if !self.isUp(node) {
 		return fmt.Errorf("node %v already down", id)
 	}
-	if err := node.Stop(); err != nil {
+	if crash {
+		crasher, ok := node.Node.(adapters.Crasher)
+		if !ok {
+			return fmt.Errorf("node %v does not support crashing", id)
+		}
+		if err := crasher.Crash(); err != nil {
+			return err
+		}
+	} else if err := node.Stop(); err != nil {
 		return err
 	}
+	log.Info(fmt.Sprintf("stop node %v", id))
+
+	// the node's peer events stop with it, so don't wait for peer drop
+	// events to mark its connections as down
+	self.lock.Lock()
 	node.Up = false
-	log.Info(fmt.Sprintf("stop node %v: %v", id, node.Up))
+	node.peerEvents = nil
+	events := append([]*Event{ControlEvent(node)}, self.dropConns(id)...)
+	self.lock.Unlock()
 
-	self.events.Send(ControlEvent(node))
+	for _, event := range events {
+		self.events.Send(event)
+	}
 	return nil
 }
 
+// isUp returns whether the node is up, holding the lock since nodes are
+// started and stopped from other goroutines
+func (self *Network) isUp(node *Node) bool {
+	self.lock.RLock()
+	defer self.lock.RUnlock()
+	return node.Up
+}
+
+// watching returns whether sub is the current subscription to the peer
+// events of the node with the given ID
+func (self *Network) watching(id discover.NodeID, sub event.Subscription) bool {
+	self.lock.RLock()
+	defer self.lock.RUnlock()
+	node := self.getNode(id)
+	return node != nil && node.peerEvents == sub
+}
+
+// dropConns marks all connections to or from the node with the given ID as
+// down so that a restarted node starts with no active connections, returning
+// events for the connections which were up. It must be called with the lock
+// held.
+func (self *Network) dropConns(id discover.NodeID) (dropped []*Event) {
+	for _, conn := range self.Conns {
+		if conn.Up && (conn.One == id || conn.Other == id) {
+			conn.Up = false
+			conn.initiated = time.Now().Add(-dialBanTimeout)
+			dropped = append(dropped, NewEvent(conn))
+		}
+	}
+	return dropped
+}
+
 // Connect connects two nodes together by calling the "admin_addPeer" RPC
-// method on the "one" node so that it connects to the "other" node
+// method on the "one" node so that it connects to the "other" node, or the
+// other way round if the "other" node is behind a NAT
 func (self *Network) Connect(oneID, otherID discover.NodeID) error {
 	log.Debug(fmt.Sprintf("connecting %s to %s", oneID, otherID))
 	conn, err := self.InitConn(oneID, otherID)
 	if err != nil {
 		return err
 	}
-	client, err := conn.one.Client()
+	// a node behind a NAT can't be dialled, so it dials out instead
+	dialer, dialee := conn.one, conn.other
+	if dialee.Config.NAT {
+		dialer, dialee = dialee, dialer
+	}
+	return self.dial(conn, dialer, dialee)
+}
+
+// dial emits a control event for the connection and calls the
+// "admin_addPeer" RPC method on the dialer so that it connects to the dialee
+func (self *Network) dial(conn *Conn, dialer, dialee *Node) error {
+	client, err := dialer.Client()
 	if err != nil {
 		return err
 	}
-	self.events.Send(ControlEvent(conn))
-	return client.Call(nil, "admin_addPeer", string(conn.other.Addr()))
+	self.lock.RLock()
+	connEvent := ControlEvent(conn)
+	self.lock.RUnlock()
+	self.events.Send(connEvent)
+	return client.Call(nil, "admin_addPeer", string(dialee.Addr()))
 }
 
 // Disconnect disconnects two nodes by calling the "admin_removePeer" RPC
@@ -299,67 +499,194 @@ func (self *Network) Disconnect(oneID, otherID discover.NodeID) error {
 	if conn == nil {
 		return fmt.Errorf("connection between %v and %v does not exist", oneID, otherID)
 	}
-	if !conn.Up {
+	// conns are marked up and down by the nodes' peer events, so read the
+	// state with the lock held
+	self.lock.RLock()
+	connEvent := ControlEvent(conn)
+	self.lock.RUnlock()
+	if !connEvent.Conn.Up {
 		return fmt.Errorf("%v and %v already disconnected", oneID, otherID)
 	}
 	client, err := conn.one.Client()
 	if err != nil {
 		return err
 	}
-	self.events.Send(ControlEvent(conn))
+	self.events.Send(connEvent)
 	return client.Call(nil, "admin_removePeer", string(conn.other.Addr()))
 }
 
+// BreakCliques disconnects enough connections so that no clique of more than
+// maxSize mutually connected nodes remains (see CliqueBreakingConns)
+func (self *Network) BreakCliques(maxSize int) error {
+	self.lock.RLock()
+	drop := CliqueBreakingConns(self.Nodes, self.Conns, maxSize)
+	self.lock.RUnlock()
+	for _, conn := range drop {
+		if err := self.Disconnect(conn.One, conn.Other); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Partition splits the network by disconnecting all connections between
+// nodes in groupA and nodes in groupB and refusing new connections between
+// them until Heal is called. Nodes which are in neither group can still
+// connect to any node.
+func (self *Network) Partition(groupA, groupB []discover.NodeID) error {
+	partition := make(map[discover.NodeID]int)
+	for _, id := range groupA {
+		partition[id] = 1
+	}
+	for _, id := range groupB {
+		if partition[id] == 1 {
+			return fmt.Errorf("node %v is in both groups", id)
+		}
+		partition[id] = 2
+	}
+
+	self.lock.Lock()
+	self.partition = partition
+	var drop []*Conn
+	for _, conn := range self.Conns {
+		if conn.Up && self.partitioned(conn.One, conn.Other) {
+			drop = append(drop, conn)
+		}
+	}
+	self.lock.Unlock()
+
+	for _, conn := range drop {
+		if err := self.Disconnect(conn.One, conn.Other); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Heal removes the partition created by Partition so that nodes in
+// different groups can connect again
+func (self *Network) Heal() {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.partition = nil
+}
+
+// partitioned returns whether the two nodes are in different groups of the
+// current partition
+func (self *Network) partitioned(one, other discover.NodeID) bool {
+	group, ok := self.partition[one]
+	if !ok {
+		return false
+	}
+	otherGroup, ok := self.partition[other]
+	return ok && group != otherGroup
+}
+
+// HonestMaliciousMix counts the active connections in the network by whether
+// each end is a malicious node (see HonestMaliciousMix)
+func (self *Network) HonestMaliciousMix() (honestHonest, honestMalicious, maliciousMalicious int) {
+	self.lock.RLock()
+	defer self.lock.RUnlock()
+	return HonestMaliciousMix(self.Nodes, self.Conns)
+}
+
 // DidConnect tracks the fact that the "one" node connected to the "other" node
 func (self *Network) DidConnect(one, other discover.NodeID) error {
-	conn, err := self.GetOrCreateConn(one, other)
+	self.lock.Lock()
+	conn, err := self.getOrCreateConn(one, other)
 	if err != nil {
+		self.lock.Unlock()
 		return fmt.Errorf("connection between %v and %v does not exist", one, other)
 	}
 	if conn.Up {
+		self.lock.Unlock()
 		return fmt.Errorf("%v and %v already connected", one, other)
 	}
+	// a dial may complete just as one of the nodes is stopped, after its
+	// connections have been dropped
+	if err := conn.nodesUp(); err != nil {
+		self.lock.Unlock()
+		return fmt.Errorf("%v and %v connected while not up: %v", one, other, err)
+	}
 	conn.Up = true
-	self.events.Send(NewEvent(conn))
+	zones, _ := self.nodeAdapter.(adapters.ZoneLatencyReporter)
+	if latency := conn.linkLatency(zones); latency > 0 {
+		conn.Latency = latency
+	}
+	event := NewEvent(conn)
+	self.lock.Unlock()
+	self.events.Send(event)
 	return nil
 }
 
 // DidDisconnect tracks the fact that the "one" node disconnected from the
 // "other" node
 func (self *Network) DidDisconnect(one, other discover.NodeID) error {
-	conn := self.GetConn(one, other)
+	self.lock.Lock()
+	conn := self.getConn(one, other)
 	if conn == nil {
+		self.lock.Unlock()
 		return fmt.Errorf("connection between %v and %v does not exist", one, other)
 	}
 	if !conn.Up {
+		self.lock.Unlock()
 		return fmt.Errorf("%v and %v already disconnected", one, other)
 	}
 	conn.Up = false
 	conn.initiated = time.Now().Add(-dialBanTimeout)
-	self.events.Send(NewEvent(conn))
+	event := NewEvent(conn)
+	self.lock.Unlock()
+	self.events.Send(event)
 	return nil
 }
 
-// DidSend tracks the fact that "sender" sent a message to "receiver"
-func (self *Network) DidSend(sender, receiver discover.NodeID, proto string, code uint64) error {
+// msgSize returns the size of the message a peer event is about, which is
+// zero if the event doesn't carry it
+func msgSize(event *p2p.PeerEvent) uint32 {
+	if event.MsgSize == nil {
+		return 0
+	}
+	return *event.MsgSize
+}
+
+// DidSend tracks the fact that "sender" sent a message of the given size to
+// "receiver"
+func (self *Network) DidSend(sender, receiver discover.NodeID, proto string, code uint64, size uint32) error {
 	msg := &Msg{
 		One:      sender,
 		Other:    receiver,
 		Protocol: proto,
 		Code:     code,
+		Size:     size,
 		Received: false,
 	}
 	self.events.Send(NewEvent(msg))
 	return nil
 }
 
-// DidReceive tracks the fact that "receiver" received a message from "sender"
-func (self *Network) DidReceive(sender, receiver discover.NodeID, proto string, code uint64) error {
+// DidFault tracks the fact that a fault of the given type was injected into
+// a message "sender" sent to "receiver"
+func (self *Network) DidFault(sender, receiver discover.NodeID, proto string, code uint64, fault string) error {
 	msg := &Msg{
 		One:      sender,
 		Other:    receiver,
 		Protocol: proto,
 		Code:     code,
+		Fault:    fault,
+	}
+	self.events.Send(NewEvent(msg))
+	return nil
+}
+
+// DidReceive tracks the fact that "receiver" received a message of the given
+// size from "sender"
+func (self *Network) DidReceive(sender, receiver discover.NodeID, proto string, code uint64, size uint32) error {
+	msg := &Msg{
+		One:      sender,
+		Other:    receiver,
+		Protocol: proto,
+		Code:     code,
+		Size:     size,
 		Received: true,
 	}
 	self.events.Send(NewEvent(msg))
@@ -408,6 +735,36 @@ func (self *Network) GetNodes() (nodes []*Node) {
 	return nodes
 }
 
+// NetworkState is a lightweight view of a network at a single point in time
+// which, unlike a Snapshot, doesn't include the state of the nodes' services
+type NetworkState struct {
+	UpNodes     []discover.NodeID `json:"up_nodes"`
+	DownNodes   []discover.NodeID `json:"down_nodes"`
+	ActiveConns []*Conn           `json:"active_conns"`
+}
+
+// State returns which nodes are currently up and down and which connections
+// are currently active
+func (self *Network) State() *NetworkState {
+	self.lock.RLock()
+	defer self.lock.RUnlock()
+	state := &NetworkState{}
+	for _, node := range self.Nodes {
+		if node.Up {
+			state.UpNodes = append(state.UpNodes, node.ID())
+		} else {
+			state.DownNodes = append(state.DownNodes, node.ID())
+		}
+	}
+	for _, conn := range self.Conns {
+		if conn.Up {
+			c := *conn
+			state.ActiveConns = append(state.ActiveConns, &c)
+		}
+	}
+	return state
+}
+
 // GetConn returns the connection which exists between "one" and "other"
 // regardless of which node initiated the connection
 func (self *Network) GetConn(oneID, otherID discover.NodeID) *Conn {
@@ -472,6 +829,14 @@ func (self *Network) InitConn(oneID, otherID discover.NodeID) (*Conn, error) {
 	if oneID == otherID {
 		return nil, fmt.Errorf("refusing to connect to self %v", oneID)
 	}
+	if self.partitioned(oneID, otherID) {
+		return nil, fmt.Errorf("refusing to connect partitioned nodes %v and %v", oneID, otherID)
+	}
+	// check before creating the connection so that there is never one
+	// between two nodes behind a NAT
+	if one, other := self.getNode(oneID), self.getNode(otherID); one != nil && other != nil && one.Config.NAT && other.Config.NAT {
+		return nil, fmt.Errorf("refusing to connect %v and %v which are both behind a NAT", oneID, otherID)
+	}
 	conn, err := self.getOrCreateConn(oneID, otherID)
 	if err != nil {
 		return nil, err
@@ -513,6 +878,7 @@ func (self *Network) Reset() {
 
 	self.Nodes = nil
 	self.Conns = nil
+	self.partition = nil
 }
 
 // Node is a wrapper around adapters.Node which is used to track the status
@@ -525,6 +891,16 @@ type Node struct {
 
 	// Up tracks whether or not the node is running
 	Up bool `json:"up"`
+
+	// State is arbitrary caller defined state (e.g. a protocol's chain
+	// height) which is carried through events and snapshots. It is
+	// encoded as JSON, so callers wanting it decoded into a concrete type
+	// should set it to a pointer of that type before unmarshalling
+	State interface{} `json:"state,omitempty"`
+
+	// peerEvents is the subscription to the node's peer events since it
+	// was last started
+	peerEvents event.Subscription
 }
 
 // ID returns the ID of the node
@@ -555,10 +931,12 @@ func (self *Node) MarshalJSON() ([]byte, error) {
 		Info   *p2p.NodeInfo        `json:"info,omitempty"`
 		Config *adapters.NodeConfig `json:"config,omitempty"`
 		Up     bool                 `json:"up"`
+		State  interface{}          `json:"state,omitempty"`
 	}{
 		Info:   self.NodeInfo(),
 		Config: self.Config,
 		Up:     self.Up,
+		State:  self.State,
 	})
 }
 
@@ -572,6 +950,12 @@ type Conn struct {
 
 	// Up tracks whether or not the connection is active
 	Up bool `json:"up"`
+
+	// Latency is the simulated time it takes a message to travel over the
+	// connection, used when estimating propagation times. It is set from
+	// the nodes' links when the connection comes up.
+	Latency time.Duration `json:"latency,omitempty"`
+
 	// Registers when the connection was grabbed to dial
 	initiated time.Time
 
@@ -590,6 +974,27 @@ func (self *Conn) nodesUp() error {
 	return nil
 }
 
+// linkLatency returns the latency of the slower of the links the two nodes
+// send data over (see adapters.LinkConfig), including the latency between
+// their zones if zones is set, or zero if neither adds any latency
+func (self *Conn) linkLatency(zones adapters.ZoneLatencyReporter) time.Duration {
+	var latency time.Duration
+	for _, nodes := range [][2]*Node{{self.one, self.other}, {self.other, self.one}} {
+		node, peer := nodes[0], nodes[1]
+		var link time.Duration
+		if node.Config.Link != nil {
+			link = node.Config.Link.Latency
+		}
+		if zones != nil {
+			link += zones.ZoneLatency(node.Config.Zone, peer.Config.Zone)
+		}
+		if link > latency {
+			latency = link
+		}
+	}
+	return latency
+}
+
 // String returns a log-friendly string
 func (self *Conn) String() string {
 	return fmt.Sprintf("Conn %v->%v", self.One.TerminalString(), self.Other.TerminalString())
@@ -601,7 +1006,12 @@ type Msg struct {
 	Other    discover.NodeID `json:"other"`
 	Protocol string          `json:"protocol"`
 	Code     uint64          `json:"code"`
+	Size     uint32          `json:"size"`
 	Received bool            `json:"received"`
+
+	// Fault is set if the event reports a fault injected into the
+	// message rather than the message being sent or received
+	Fault string `json:"fault,omitempty"`
 }
 
 // String returns a log-friendly string
@@ -609,9 +1019,28 @@ func (self *Msg) String() string {
 	return fmt.Sprintf("Msg(%d) %v->%v", self.Code, self.One.TerminalString(), self.Other.TerminalString())
 }
 
+// Label returns the ConnLabel of the connection's two nodes
+func (self *Conn) Label() string {
+	return ConnLabel(self.One, self.Other)
+}
+
+// ActiveConns returns the connections which are up keyed by their label,
+// with each pair of nodes appearing once regardless of which node initiated
+// the connection
+func ActiveConns(conns []*Conn) map[string]*Conn {
+	active := make(map[string]*Conn)
+	for _, conn := range conns {
+		if conn.Up {
+			active[conn.Label()] = conn
+		}
+	}
+	return active
+}
+
 // ConnLabel generates a deterministic string which represents a connection
 // between two nodes, used to compare if two connections are between the same
-// nodes
+// nodes. The label doesn't depend on the order of the nodes, so
+// ConnLabel(a, b) == ConnLabel(b, a)
 func ConnLabel(source, target discover.NodeID) string {
 	var first, second discover.NodeID
 	if bytes.Compare(source.Bytes(), target.Bytes()) > 0 {
@@ -667,9 +1096,13 @@ func (self *Network) Snapshot() (*Snapshot, error) {
 // Load loads a network snapshot
 func (self *Network) Load(snap *Snapshot) error {
 	for _, n := range snap.Nodes {
-		if _, err := self.NewNodeWithConfig(n.Node.Config); err != nil {
+		node, err := self.NewNodeWithConfig(n.Node.Config)
+		if err != nil {
 			return err
 		}
+		self.lock.Lock()
+		node.State = n.Node.State
+		self.lock.Unlock()
 		if !n.Node.Up {
 			continue
 		}
@@ -687,10 +1120,46 @@ func (self *Network) Load(snap *Snapshot) error {
 		if err := self.Connect(conn.One, conn.Other); err != nil {
 			return err
 		}
+		self.lock.Lock()
+		self.getConn(conn.One, conn.Other).Latency = conn.Latency
+		self.lock.Unlock()
 	}
 	return nil
 }
 
+// SaveSnapshot writes a snapshot of the network to the given file, so that
+// it can be restored with LoadSnapshot. The file is replaced atomically so
+// that an interrupted save doesn't lose a previous snapshot.
+func (self *Network) SaveSnapshot(path string) error {
+	snap, err := self.Snapshot()
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(snap)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// LoadSnapshot loads a snapshot written by SaveSnapshot from the given file
+// (see Load)
+func (self *Network) LoadSnapshot(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	snap := &Snapshot{}
+	if err := json.Unmarshal(data, snap); err != nil {
+		return fmt.Errorf("error decoding snapshot %s: %v", path, err)
+	}
+	return self.Load(snap)
+}
+
 // Subscribe reads control events from a channel and executes them
 func (self *Network) Subscribe(events chan *Event) {
 	for {
@@ -719,23 +1188,47 @@ func (self *Network) executeControlEvent(event *Event) {
 		if err := self.executeConnEvent(event); err != nil {
 			log.Error("error executing conn event", "event", event, "err", err)
 		}
+	case EventTypeUpgrade:
+		if err := self.Upgrade(event.Node.ID(), event.Node.Config.Version); err != nil {
+			log.Error("error executing upgrade event", "event", event, "err", err)
+		}
 	case EventTypeMsg:
 		log.Warn("ignoring control msg event")
 	}
 }
 
+// executeNodeEvent moves the node to the state in the event, creating it
+// the first time it is seen (e.g. a node which is created but not started)
 func (self *Network) executeNodeEvent(e *Event) error {
+	node := self.GetNode(e.Node.ID())
+	if node == nil {
+		var err error
+		if node, err = self.NewNodeWithConfig(e.Node.Config); err != nil {
+			return err
+		}
+	}
+	self.lock.RLock()
+	up := node.Up
+	self.lock.RUnlock()
+	if up == e.Node.Up {
+		return nil
+	}
 	if !e.Node.Up {
 		return self.Stop(e.Node.ID())
 	}
-
-	if _, err := self.NewNodeWithConfig(e.Node.Config); err != nil {
-		return err
-	}
 	return self.Start(e.Node.ID())
 }
 
+// executeConnEvent moves the connection to the state in the event, which it
+// may already be in (e.g. if it was dropped when one of its nodes stopped)
 func (self *Network) executeConnEvent(e *Event) error {
+	self.lock.RLock()
+	conn := self.getConn(e.Conn.One, e.Conn.Other)
+	up := conn != nil && conn.Up
+	self.lock.RUnlock()
+	if up == e.Conn.Up {
+		return nil
+	}
 	if e.Conn.Up {
 		return self.Connect(e.Conn.One, e.Conn.Other)
 	} else {
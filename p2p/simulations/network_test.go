@@ -18,12 +18,20 @@ package simulations
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/ethereum/go-ethereum/event"
+	"github.com/ethereum/go-ethereum/node"
 	"github.com/ethereum/go-ethereum/p2p/discover"
 	"github.com/ethereum/go-ethereum/p2p/simulations/adapters"
+	"github.com/ethereum/go-ethereum/p2p/simulations/topology"
 )
 
 // TestNetworkSimulation creates a multi-node simulation network with each node
@@ -157,3 +165,510 @@ func triggerChecks(ctx context.Context, ids []discover.NodeID, trigger chan disc
 		}
 	}
 }
+
+// TestNodeState checks that custom node state is carried through events and
+// JSON encoded snapshots
+func TestNodeState(t *testing.T) {
+	type chainState struct {
+		Height uint64 `json:"height"`
+	}
+	node := &Node{
+		Config: adapters.RandomNodeConfig(),
+		State:  &chainState{Height: 42},
+	}
+
+	var feed event.Feed
+	events := make(chan *Event, 1)
+	sub := feed.Subscribe(events)
+	defer sub.Unsubscribe()
+	feed.Send(ControlEvent(node))
+	e := <-events
+	if state, ok := e.Node.State.(*chainState); !ok || state.Height != 42 {
+		t.Fatalf("expected event to carry state %v, got %v", node.State, e.Node.State)
+	}
+
+	data, err := json.Marshal(&Snapshot{Nodes: []NodeSnapshot{{Node: *node}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	state := &chainState{}
+	snap := &Snapshot{Nodes: []NodeSnapshot{{Node: Node{State: state}}}}
+	if err := json.Unmarshal(data, snap); err != nil {
+		t.Fatal(err)
+	}
+	if state.Height != 42 {
+		t.Fatalf("expected decoded state to have height 42, got %d", state.Height)
+	}
+	if snap.Nodes[0].Node.ID() != node.ID() {
+		t.Fatalf("expected decoded node to have ID %s, got %s", node.ID(), snap.Nodes[0].Node.ID())
+	}
+}
+
+func TestSequentialNodeConfigs(t *testing.T) {
+	confs := adapters.SequentialNodeConfigs(5)
+	again := adapters.SequentialNodeConfigs(3)
+	ids := make(map[discover.NodeID]bool)
+	for i, conf := range confs {
+		if ids[conf.ID] {
+			t.Fatalf("expected node %d to have a unique ID, got %s", i, conf.ID.TerminalString())
+		}
+		ids[conf.ID] = true
+		if i < len(again) && again[i].ID != conf.ID {
+			t.Fatalf("expected node %d to have ID %s, got %s", i, conf.ID.TerminalString(), again[i].ID.TerminalString())
+		}
+	}
+
+	// check the configs can be used to start nodes
+	adapter := adapters.NewSimAdapter(adapters.Services{
+		"test": newTestService,
+	})
+	network := NewNetwork(adapter, &NetworkConfig{
+		DefaultService: "test",
+	})
+	defer network.Shutdown()
+	for _, conf := range confs {
+		conf.Services = []string{"test"}
+		if _, err := network.NewNodeWithConfig(conf); err != nil {
+			t.Fatal(err)
+		}
+		if err := network.Start(conf.ID); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// TestNetworkStopDropsConns checks that stopping a node marks its connections
+// as down so that a restarted node has to be connected again
+func TestNetworkStopDropsConns(t *testing.T) {
+	adapter := adapters.NewSimAdapter(adapters.Services{
+		"test": newTestService,
+	})
+	network := NewNetwork(adapter, &NetworkConfig{
+		DefaultService: "test",
+	})
+	defer network.Shutdown()
+	ids := make([]discover.NodeID, 2)
+	for i := range ids {
+		node, err := network.NewNode()
+		if err != nil {
+			t.Fatalf("error creating node: %s", err)
+		}
+		if err := network.Start(node.ID()); err != nil {
+			t.Fatalf("error starting node: %s", err)
+		}
+		ids[i] = node.ID()
+	}
+
+	if err := network.Connect(ids[0], ids[1]); err != nil {
+		t.Fatalf("error connecting nodes: %s", err)
+	}
+	timeout := time.After(10 * time.Second)
+	for !connUp(network, ids[0], ids[1]) {
+		select {
+		case <-time.After(10 * time.Millisecond):
+		case <-timeout:
+			t.Fatal("timed out waiting for nodes to connect")
+		}
+	}
+
+	if err := network.Stop(ids[1]); err != nil {
+		t.Fatalf("error stopping node: %s", err)
+	}
+	if connUp(network, ids[0], ids[1]) {
+		t.Fatal("expected connection to be down after stopping node")
+	}
+	if err := network.Start(ids[1]); err != nil {
+		t.Fatalf("error starting node: %s", err)
+	}
+	if connUp(network, ids[0], ids[1]) {
+		t.Fatal("expected connection to be down after restarting node")
+	}
+}
+
+func TestConnLabel(t *testing.T) {
+	nodes := testGraphNodes(3)
+	a, b, c := nodes[0].ID(), nodes[1].ID(), nodes[2].ID()
+	if ConnLabel(a, b) != ConnLabel(b, a) {
+		t.Fatalf("expected labels to be symmetric, got %s and %s", ConnLabel(a, b), ConnLabel(b, a))
+	}
+	if ConnLabel(a, b) == ConnLabel(a, c) {
+		t.Fatalf("expected different connections to have different labels")
+	}
+
+	conns := []*Conn{
+		{One: a, Other: b, Up: true},
+		{One: b, Other: a, Up: true},
+		{One: b, Other: c, Up: true},
+		{One: a, Other: c},
+	}
+	if conns[0].Label() != conns[1].Label() {
+		t.Fatalf("expected reversed connections to have the same label")
+	}
+	active := ActiveConns(conns)
+	if len(active) != 2 {
+		t.Fatalf("expected 2 active connections, got %d", len(active))
+	}
+	if _, ok := active[ConnLabel(c, b)]; !ok {
+		t.Fatalf("expected connection between b and c to be active")
+	}
+	if _, ok := active[ConnLabel(a, c)]; ok {
+		t.Fatalf("expected connection between a and c to be inactive")
+	}
+}
+
+// TestNetworkPartition checks that partitioning a network drops the
+// connections between the groups and refuses new ones until it is healed
+func TestNetworkPartition(t *testing.T) {
+	adapter := adapters.NewSimAdapter(adapters.Services{
+		"test": newTestService,
+	})
+	network := NewNetwork(adapter, &NetworkConfig{
+		DefaultService: "test",
+	})
+	defer network.Shutdown()
+	ids := make([]discover.NodeID, 3)
+	for i := range ids {
+		node, err := network.NewNode()
+		if err != nil {
+			t.Fatalf("error creating node: %s", err)
+		}
+		if err := network.Start(node.ID()); err != nil {
+			t.Fatalf("error starting node: %s", err)
+		}
+		ids[i] = node.ID()
+	}
+	waitConn := func(one, other discover.NodeID, up bool) {
+		timeout := time.After(10 * time.Second)
+		for connUp(network, one, other) != up {
+			select {
+			case <-time.After(10 * time.Millisecond):
+			case <-timeout:
+				t.Fatalf("timed out waiting for connection to have up %t", up)
+			}
+		}
+	}
+	for _, id := range ids[1:] {
+		if err := network.Connect(ids[0], id); err != nil {
+			t.Fatalf("error connecting nodes: %s", err)
+		}
+		waitConn(ids[0], id, true)
+	}
+
+	if err := network.Partition(ids[:1], ids[1:2]); err != nil {
+		t.Fatalf("error partitioning network: %s", err)
+	}
+	waitConn(ids[0], ids[1], false)
+	if !connUp(network, ids[0], ids[2]) {
+		t.Fatal("expected connection to a node outside the partition to stay up")
+	}
+	if err := network.Connect(ids[1], ids[0]); err == nil {
+		t.Fatal("expected an error connecting partitioned nodes")
+	}
+
+	// the test service doesn't support reconnecting peers, so just check
+	// the connection is no longer refused
+	network.Heal()
+	if _, err := network.InitConn(ids[1], ids[0]); err != nil {
+		t.Fatalf("error connecting nodes after healing: %s", err)
+	}
+
+	if err := network.Partition(ids[:2], ids[1:]); err == nil {
+		t.Fatal("expected an error partitioning with a node in both groups")
+	}
+}
+
+// TestNetworkLinks checks that nodes with slow links can still connect
+func TestNetworkLinks(t *testing.T) {
+	adapter := adapters.NewSimAdapter(adapters.Services{
+		"noop": func(ctx *adapters.ServiceContext) (node.Service, error) {
+			return noopService{}, nil
+		},
+	})
+	zones := []string{"eu", "us"}
+	adapter.SetZoneLatencies(adapters.ZoneLatencies{"eu": {"us": 30 * time.Millisecond}})
+	network := NewNetwork(adapter, &NetworkConfig{
+		DefaultService: "noop",
+	})
+	defer network.Shutdown()
+	ids := make([]discover.NodeID, 2)
+	for i := range ids {
+		conf := adapters.RandomNodeConfig()
+		conf.Link = &adapters.LinkConfig{Latency: 50 * time.Millisecond, Bandwidth: 1 << 20}
+		conf.Zone = zones[i]
+		conf.Services = []string{"noop"}
+		node, err := network.NewNodeWithConfig(conf)
+		if err != nil {
+			t.Fatalf("error creating node: %s", err)
+		}
+		if err := network.Start(node.ID()); err != nil {
+			t.Fatalf("error starting node: %s", err)
+		}
+		ids[i] = node.ID()
+	}
+
+	start := time.Now()
+	if err := network.Connect(ids[0], ids[1]); err != nil {
+		t.Fatalf("error connecting nodes: %s", err)
+	}
+	timeout := time.After(10 * time.Second)
+	for !connUp(network, ids[0], ids[1]) {
+		select {
+		case <-time.After(10 * time.Millisecond):
+		case <-timeout:
+			t.Fatal("timed out waiting for nodes to connect")
+		}
+	}
+	// the devp2p handshake takes several round trips over the slow links
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Fatalf("expected connecting to be slowed down by the links, took %s", elapsed)
+	}
+	network.lock.RLock()
+	latency := network.getConn(ids[0], ids[1]).Latency
+	network.lock.RUnlock()
+	if latency != 80*time.Millisecond {
+		t.Fatalf("expected the connection to have the links' and zones' latency, got %s", latency)
+	}
+}
+
+// connUp returns whether the connection between the two nodes is up,
+// holding the network's lock since connections are updated by the nodes'
+// peer events
+func connUp(network *Network, one, other discover.NodeID) bool {
+	network.lock.RLock()
+	defer network.lock.RUnlock()
+	conn := network.getConn(one, other)
+	return conn != nil && conn.Up
+}
+
+// TestNetworkSaveSnapshot checks that a network restored from a snapshot file
+// has the same nodes up and the same connections
+func TestNetworkSaveSnapshot(t *testing.T) {
+	dir, err := ioutil.TempDir("", "p2p-simulations-snapshot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "snapshot.json")
+
+	newNetwork := func() *Network {
+		adapter := adapters.NewSimAdapter(adapters.Services{
+			"noop": func(ctx *adapters.ServiceContext) (node.Service, error) {
+				return noopService{}, nil
+			},
+		})
+		return NewNetwork(adapter, &NetworkConfig{DefaultService: "noop"})
+	}
+	network := newNetwork()
+	defer network.Shutdown()
+	ids := make([]discover.NodeID, 4)
+	for i := range ids {
+		node, err := network.NewNode()
+		if err != nil {
+			t.Fatalf("error creating node: %s", err)
+		}
+		ids[i] = node.ID()
+		// leave the last node down
+		if i == len(ids)-1 {
+			continue
+		}
+		if err := network.Start(node.ID()); err != nil {
+			t.Fatalf("error starting node: %s", err)
+		}
+	}
+	for _, pair := range [][2]int{{0, 1}, {1, 2}} {
+		if err := network.Connect(ids[pair[0]], ids[pair[1]]); err != nil {
+			t.Fatalf("error connecting nodes: %s", err)
+		}
+	}
+	if err := NewNetworkExpectation().ConnsUp(2).Within(network, 10*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if err := network.SaveSnapshot(path); err != nil {
+		t.Fatalf("error saving snapshot: %s", err)
+	}
+
+	restored := newNetwork()
+	defer restored.Shutdown()
+	if err := restored.LoadSnapshot(path); err != nil {
+		t.Fatalf("error loading snapshot: %s", err)
+	}
+	if err := NewNetworkExpectation().NodesUp(3).ConnsUp(2).Within(restored, 10*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if node := restored.GetNode(ids[3]); node == nil || node.Up {
+		t.Fatalf("expected node %s to be restored down", ids[3].TerminalString())
+	}
+	for _, pair := range [][2]int{{0, 1}, {1, 2}} {
+		if conn := restored.GetConn(ids[pair[0]], ids[pair[1]]); conn == nil || !conn.Up {
+			t.Fatalf("expected the connection between nodes %d and %d to be restored", pair[0], pair[1])
+		}
+	}
+}
+
+// TestNetworkCrash checks that the peers of a crashed node aren't told it has
+// disconnected, unlike the peers of a node which is stopped
+func TestNetworkCrash(t *testing.T) {
+	adapter := adapters.NewSimAdapter(adapters.Services{
+		"noop": func(ctx *adapters.ServiceContext) (node.Service, error) {
+			return noopService{}, nil
+		},
+	})
+	network := NewNetwork(adapter, &NetworkConfig{
+		DefaultService: "noop",
+	})
+	defer network.Shutdown()
+	ids := make([]discover.NodeID, 3)
+	for i := range ids {
+		node, err := network.NewNode()
+		if err != nil {
+			t.Fatalf("error creating node: %s", err)
+		}
+		if err := network.Start(node.ID()); err != nil {
+			t.Fatalf("error starting node: %s", err)
+		}
+		ids[i] = node.ID()
+	}
+	// connect the first node to the other two
+	for _, id := range ids[1:] {
+		if err := network.Connect(ids[0], id); err != nil {
+			t.Fatalf("error connecting nodes: %s", err)
+		}
+	}
+	if err := NewNetworkExpectation().ConnsUp(2).Within(network, 10*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	hub, _ := adapter.GetNode(ids[0])
+	hasPeer := func(id discover.NodeID) bool {
+		for _, peer := range hub.Server().PeersInfo() {
+			if peer.ID == id.String() {
+				return true
+			}
+		}
+		return false
+	}
+
+	if err := network.Stop(ids[1]); err != nil {
+		t.Fatalf("error stopping node: %s", err)
+	}
+	if err := network.Crash(ids[2]); err != nil {
+		t.Fatalf("error crashing node: %s", err)
+	}
+	if node := network.GetNode(ids[2]); node.Up {
+		t.Fatal("expected the crashed node to be down")
+	}
+	if err := NewNetworkExpectation().NodesUp(1).ConnsUp(0).Within(network, time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	// the stopped node disconnects gracefully, the crashed one doesn't
+	timeout := time.After(5 * time.Second)
+	for hasPeer(ids[1]) {
+		select {
+		case <-time.After(10 * time.Millisecond):
+		case <-timeout:
+			t.Fatal("timed out waiting for the stopped node to disconnect")
+		}
+	}
+	if !hasPeer(ids[2]) {
+		t.Fatal("expected the crashed node to still be a peer until the connection times out")
+	}
+
+	// a crashed node can be started again
+	if err := network.Start(ids[2]); err != nil {
+		t.Fatalf("error restarting crashed node: %s", err)
+	}
+}
+
+// TestNetworkNAT checks that nodes behind a NAT can only be connected to by
+// them dialling out
+func TestNetworkNAT(t *testing.T) {
+	adapter := adapters.NewSimAdapter(adapters.Services{
+		"noop": func(ctx *adapters.ServiceContext) (node.Service, error) {
+			return noopService{}, nil
+		},
+	})
+	network := NewNetwork(adapter, &NetworkConfig{
+		DefaultService: "noop",
+	})
+	defer network.Shutdown()
+	// the first node is public, the others are behind a NAT
+	ids := make([]discover.NodeID, 3)
+	for i := range ids {
+		conf := adapters.RandomNodeConfig()
+		conf.Services = []string{"noop"}
+		conf.NAT = i > 0
+		node, err := network.NewNodeWithConfig(conf)
+		if err != nil {
+			t.Fatalf("error creating node: %s", err)
+		}
+		if err := network.Start(node.ID()); err != nil {
+			t.Fatalf("error starting node: %s", err)
+		}
+		ids[i] = node.ID()
+	}
+
+	nat, _ := adapter.GetNode(ids[1])
+	if _, err := adapter.Dial(nat.Node()); err == nil {
+		t.Fatal("expected dialling a node behind a NAT to fail")
+	}
+
+	// connecting to a node behind a NAT makes it dial out instead
+	if err := network.Connect(ids[0], ids[1]); err != nil {
+		t.Fatalf("error connecting nodes: %s", err)
+	}
+	if err := NewNetworkExpectation().ConnsUp(1).Within(network, 10*time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	// two nodes behind a NAT can't connect
+	if err := network.Connect(ids[1], ids[2]); err == nil {
+		t.Fatal("expected connecting two nodes behind a NAT to fail")
+	}
+	if network.GetConn(ids[1], ids[2]) != nil {
+		t.Fatal("expected no connection between two nodes behind a NAT")
+	}
+}
+
+// TestNetworkBatch checks starting, connecting and stopping nodes in batches
+func TestNetworkBatch(t *testing.T) {
+	adapter := adapters.NewSimAdapter(adapters.Services{
+		"noop": func(ctx *adapters.ServiceContext) (node.Service, error) {
+			return noopService{}, nil
+		},
+	})
+	network := NewNetwork(adapter, &NetworkConfig{
+		DefaultService: "noop",
+	})
+	defer network.Shutdown()
+	ids := make([]discover.NodeID, 20)
+	for i := range ids {
+		node, err := network.NewNode()
+		if err != nil {
+			t.Fatalf("error creating node: %s", err)
+		}
+		ids[i] = node.ID()
+	}
+	if err := network.StartNodes(ids); err != nil {
+		t.Fatalf("error starting nodes: %s", err)
+	}
+	if err := network.ConnectNodes(topology.Ring(ids)); err != nil {
+		t.Fatalf("error connecting nodes: %s", err)
+	}
+	if err := NewNetworkExpectation().NodesUp(20).ConnsUp(20).Within(network, 10*time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	// every operation is attempted even if some fail
+	err := network.StopNodes(append([]discover.NodeID{{1}}, ids[:10]...))
+	if err == nil {
+		t.Fatal("expected an error stopping an unknown node")
+	}
+	if !strings.HasPrefix(err.Error(), "1 of 11 operations failed") {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	for _, id := range ids[:10] {
+		if network.GetNode(id).Up {
+			t.Fatalf("expected node %s to be stopped", id.TerminalString())
+		}
+	}
+}
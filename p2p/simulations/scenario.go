@@ -0,0 +1,350 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package simulations
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/ethereum/go-ethereum/p2p/discover"
+	"github.com/ethereum/go-ethereum/p2p/simulations/adapters"
+)
+
+// Scenario describes a simulation declaratively so that it can be loaded
+// from a JSON file, for example:
+//
+//	{
+//	  "adapter": "sim",
+//	  "nodes": 10,
+//	  "actions": [
+//	    {"at": "0s", "type": "connect", "nodes": [0], "peers": [1, 2]},
+//	    {"at": "30s", "type": "stop", "nodes": [5]},
+//	    {"at": "60s", "type": "partition", "nodes": [0, 1], "peers": [2, 3]},
+//	    {"at": "90s", "type": "heal"}
+//	  ],
+//	  "expect": {"nodes_up": 9, "connected": true, "within": "30s"}
+//	}
+type Scenario struct {
+	// Adapter is the node adapter the scenario runs with, one of "sim",
+	// "exec" or "docker" (empty means any adapter)
+	Adapter string `json:"adapter,omitempty"`
+
+	// Nodes is the number of nodes to create and start before running
+	// the actions
+	Nodes int `json:"nodes"`
+
+	// Mocker is an optional mocker to run while the actions are performed.
+	// Its nodes are created after the scenario's nodes, and the actions are
+	// performed once they have all been started.
+	Mocker *MockerConfig `json:"mocker,omitempty"`
+
+	// Actions are the actions to perform, in the order of their times
+	Actions []ScenarioAction `json:"actions"`
+
+	// Expect is an optional expectation the network has to meet once the
+	// actions have been performed
+	Expect *ScenarioExpectation `json:"expect,omitempty"`
+}
+
+// ScenarioAction is an action to perform on the network at a given time
+// from the start of a scenario.
+//
+// Nodes and peers are given as indexes into the network's nodes at the time
+// the action is performed, in the order the nodes were created.
+type ScenarioAction struct {
+	// At is the time to perform the action as a duration string like "30s"
+	At string `json:"at"`
+
+	// Type is one of "start", "stop", "connect", "disconnect", "partition"
+	// or "heal"
+	Type string `json:"type"`
+
+	// Nodes are the nodes to start or stop, the nodes to connect to or
+	// disconnect from each of the peers, or the first group of a partition
+	Nodes []int `json:"nodes,omitempty"`
+
+	// Peers are the peers to connect or disconnect, or the second group of
+	// a partition
+	Peers []int `json:"peers,omitempty"`
+
+	at time.Duration
+}
+
+// ScenarioExpectation describes a NetworkExpectation which the network has
+// to meet within a given time once a scenario's actions have been performed
+type ScenarioExpectation struct {
+	// NodesUp is the number of nodes which should be up (see
+	// NetworkExpectation.NodesUp)
+	NodesUp *int `json:"nodes_up,omitempty"`
+
+	// ConnsUp is the number of connections which should be up (see
+	// NetworkExpectation.ConnsUp)
+	ConnsUp *int `json:"conns_up,omitempty"`
+
+	// FullMesh expects the nodes which are up to form a full mesh
+	FullMesh bool `json:"full_mesh,omitempty"`
+
+	// Connected expects the nodes which are up to form a connected network
+	Connected bool `json:"connected,omitempty"`
+
+	// Within is how long to wait for the network to meet the expectation as
+	// a duration string like "30s"
+	Within string `json:"within"`
+
+	within time.Duration
+}
+
+// NetworkExpectation returns the expectation's conditions as a
+// NetworkExpectation
+func (e *ScenarioExpectation) NetworkExpectation() *NetworkExpectation {
+	expect := NewNetworkExpectation()
+	if e.NodesUp != nil {
+		expect.NodesUp(*e.NodesUp)
+	}
+	if e.ConnsUp != nil {
+		expect.ConnsUp(*e.ConnsUp)
+	}
+	if e.FullMesh {
+		expect.FullMesh()
+	}
+	if e.Connected {
+		expect.Connected()
+	}
+	return expect
+}
+
+// LoadScenario decodes and validates a JSON encoded Scenario
+func LoadScenario(r io.Reader) (*Scenario, error) {
+	scenario := &Scenario{}
+	if err := json.NewDecoder(r).Decode(scenario); err != nil {
+		return nil, err
+	}
+	if err := scenario.Validate(); err != nil {
+		return nil, err
+	}
+	return scenario, nil
+}
+
+// Validate checks the scenario's mocker and actions, parsing the action
+// times
+func (s *Scenario) Validate() error {
+	switch s.Adapter {
+	case "", "sim", "exec", "docker":
+	default:
+		return fmt.Errorf("unknown adapter %q", s.Adapter)
+	}
+	if s.Nodes < 0 {
+		return fmt.Errorf("invalid node count %d", s.Nodes)
+	}
+	if s.Mocker != nil {
+		if err := s.Mocker.Validate(); err != nil {
+			return err
+		}
+	}
+	for i := range s.Actions {
+		action := &s.Actions[i]
+		at, err := time.ParseDuration(action.At)
+		if err != nil {
+			return fmt.Errorf("action %d: invalid time %q: %v", i, action.At, err)
+		}
+		action.at = at
+		switch action.Type {
+		case "start", "stop", "connect", "disconnect", "partition", "heal":
+		default:
+			return fmt.Errorf("action %d: unknown type %q", i, action.Type)
+		}
+	}
+	if s.Expect != nil {
+		within, err := time.ParseDuration(s.Expect.Within)
+		if err != nil {
+			return fmt.Errorf("expectation: invalid time %q: %v", s.Expect.Within, err)
+		}
+		s.Expect.within = within
+	}
+	return nil
+}
+
+// NewAdapter returns a node adapter of the scenario's type, or a sim adapter
+// if it doesn't have one. Sim nodes run the given services and exec nodes
+// keep their data in baseDir.
+func (s *Scenario) NewAdapter(services adapters.Services, baseDir string) (adapters.NodeAdapter, error) {
+	switch s.Adapter {
+	case "", "sim":
+		return adapters.NewSimAdapter(services), nil
+	case "exec":
+		return adapters.NewExecAdapter(baseDir), nil
+	case "docker":
+		return adapters.NewDockerAdapter()
+	default:
+		return nil, fmt.Errorf("unknown adapter %q", s.Adapter)
+	}
+}
+
+// RunScenario creates and starts the scenario's nodes, starts its mocker and
+// waits for the mocker's nodes to start, then performs its actions at their
+// times and waits for the network to meet the scenario's expectation. It
+// returns once the expectation is met (stopping the mocker), or with an
+// error if an action fails or the expectation isn't met in time.
+func RunScenario(ctx context.Context, network *Network, scenario *Scenario) error {
+	if err := scenario.Validate(); err != nil {
+		return err
+	}
+	if scenario.Adapter != "" && network.nodeAdapter.Name() != scenario.Adapter+"-adapter" {
+		return fmt.Errorf("scenario needs the %s adapter, network uses %s", scenario.Adapter, network.nodeAdapter.Name())
+	}
+	actions := make([]ScenarioAction, len(scenario.Actions))
+	copy(actions, scenario.Actions)
+	sort.SliceStable(actions, func(i, j int) bool { return actions[i].at < actions[j].at })
+
+	for i := 0; i < scenario.Nodes; i++ {
+		node, err := network.NewNode()
+		if err != nil {
+			return err
+		}
+		if err := network.Start(node.ID()); err != nil {
+			return err
+		}
+	}
+	if scenario.Mocker != nil {
+		quit := make(chan struct{})
+		defer close(quit)
+		// actions refer to the mocker's nodes by index, so wait for them to
+		// exist before starting the clock
+		err := waitForStartedNodes(ctx, network, scenario.Mocker.NodeCount, func() {
+			go LookupMocker(scenario.Mocker.Type)(network, quit, scenario.Mocker)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	start := time.Now()
+	for i, action := range actions {
+		select {
+		case <-time.After(time.Until(start.Add(action.at))):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		if err := runScenarioAction(network, &action); err != nil {
+			return fmt.Errorf("action %d (%s at %s): %v", i, action.Type, action.At, err)
+		}
+	}
+	if scenario.Expect != nil {
+		ctx, cancel := context.WithTimeout(ctx, scenario.Expect.within)
+		defer cancel()
+		if err := scenario.Expect.NetworkExpectation().Wait(ctx, network); err != nil {
+			return fmt.Errorf("expectation not met: %v", err)
+		}
+	}
+	return nil
+}
+
+// waitForStartedNodes calls start and then waits until count nodes which
+// were not up beforehand have been started
+func waitForStartedNodes(ctx context.Context, network *Network, count int, start func()) error {
+	events := make(chan *Event)
+	sub := network.Events().Subscribe(events)
+	defer sub.Unsubscribe()
+
+	up := make(map[discover.NodeID]bool)
+	network.lock.RLock()
+	for _, node := range network.Nodes {
+		up[node.ID()] = node.Up
+	}
+	network.lock.RUnlock()
+
+	start()
+	started := make(map[discover.NodeID]bool)
+	for len(started) < count {
+		select {
+		case event := <-events:
+			if event.Type == EventTypeNode && event.Node.Up && !up[event.Node.ID()] {
+				started[event.Node.ID()] = true
+			}
+		case err := <-sub.Err():
+			return err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// runScenarioAction performs the action on the network
+func runScenarioAction(network *Network, action *ScenarioAction) error {
+	nodes, err := scenarioNodeIDs(network, action.Nodes)
+	if err != nil {
+		return err
+	}
+	peers, err := scenarioNodeIDs(network, action.Peers)
+	if err != nil {
+		return err
+	}
+	switch action.Type {
+	case "start":
+		for _, id := range nodes {
+			if err := network.Start(id); err != nil {
+				return err
+			}
+		}
+	case "stop":
+		for _, id := range nodes {
+			if err := network.Stop(id); err != nil {
+				return err
+			}
+		}
+	case "connect":
+		for _, id := range nodes {
+			for _, peer := range peers {
+				if err := network.Connect(id, peer); err != nil {
+					return err
+				}
+			}
+		}
+	case "disconnect":
+		for _, id := range nodes {
+			for _, peer := range peers {
+				if err := network.Disconnect(id, peer); err != nil {
+					return err
+				}
+			}
+		}
+	case "partition":
+		return network.Partition(nodes, peers)
+	case "heal":
+		network.Heal()
+	}
+	return nil
+}
+
+// scenarioNodeIDs returns the IDs of the network's nodes with the given
+// indexes
+func scenarioNodeIDs(network *Network, indexes []int) ([]discover.NodeID, error) {
+	nodes := network.GetNodes()
+	ids := make([]discover.NodeID, len(indexes))
+	for i, index := range indexes {
+		if index < 0 || index >= len(nodes) {
+			return nil, fmt.Errorf("node %d does not exist", index)
+		}
+		ids[i] = nodes[index].ID()
+	}
+	return ids, nil
+}
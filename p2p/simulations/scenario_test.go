@@ -0,0 +1,130 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package simulations
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/node"
+	"github.com/ethereum/go-ethereum/p2p/simulations/adapters"
+)
+
+func TestScenario(t *testing.T) {
+	for _, invalid := range []string{
+		`{"nodes": -1}`,
+		`{"actions": [{"at": "soon", "type": "stop"}]}`,
+		`{"actions": [{"at": "1s", "type": "explode"}]}`,
+		`{"mocker": {"type": "unknown", "node_count": 2}}`,
+		`{"adapter": "vm"}`,
+		`{"expect": {"nodes_up": 1}}`,
+	} {
+		if _, err := LoadScenario(strings.NewReader(invalid)); err == nil {
+			t.Fatalf("expected an error loading scenario %s", invalid)
+		}
+	}
+
+	scenario, err := LoadScenario(strings.NewReader(`{
+		"nodes": 3,
+		"actions": [
+			{"at": "20ms", "type": "stop", "nodes": [2]},
+			{"at": "0s", "type": "connect", "nodes": [0], "peers": [1]},
+			{"at": "40ms", "type": "partition", "nodes": [0], "peers": [1]}
+		],
+		"expect": {"nodes_up": 2, "conns_up": 0, "within": "5s"}
+	}`))
+	if err != nil {
+		t.Fatalf("error loading scenario: %s", err)
+	}
+
+	adapter := adapters.NewSimAdapter(adapters.Services{
+		"noop": func(ctx *adapters.ServiceContext) (node.Service, error) {
+			return noopService{}, nil
+		},
+	})
+	network := NewNetwork(adapter, &NetworkConfig{
+		DefaultService: "noop",
+	})
+	defer network.Shutdown()
+	start := time.Now()
+	if err := RunScenario(context.Background(), network, scenario); err != nil {
+		t.Fatalf("error running scenario: %s", err)
+	}
+	if elapsed := time.Since(start); elapsed < 40*time.Millisecond {
+		t.Fatalf("expected the scenario to take at least 40ms, took %s", elapsed)
+	}
+	nodes := network.GetNodes()
+	if len(nodes) != 3 {
+		t.Fatalf("expected 3 nodes, got %d", len(nodes))
+	}
+	if !nodes[0].Up || !nodes[1].Up || nodes[2].Up {
+		t.Fatalf("expected only node 2 to be stopped")
+	}
+	if network.GetConn(nodes[0].ID(), nodes[1].ID()) == nil {
+		t.Fatalf("expected nodes 0 and 1 to have been connected")
+	}
+	if _, err := network.InitConn(nodes[0].ID(), nodes[1].ID()); err == nil {
+		t.Fatalf("expected nodes 0 and 1 to be partitioned")
+	}
+
+	// actions referring to missing nodes should fail
+	scenario = &Scenario{Actions: []ScenarioAction{{At: "0s", Type: "stop", Nodes: []int{3}}}}
+	if err := RunScenario(context.Background(), network, scenario); err == nil {
+		t.Fatal("expected an error stopping a node which doesn't exist")
+	}
+
+	// expectations which aren't met in time should fail
+	scenario, err = LoadScenario(strings.NewReader(`{"expect": {"nodes_up": 3, "within": "50ms"}}`))
+	if err != nil {
+		t.Fatalf("error loading scenario: %s", err)
+	}
+	if err := RunScenario(context.Background(), network, scenario); err == nil {
+		t.Fatal("expected an error waiting for a stopped node to be up")
+	}
+
+	// scenarios for other adapters should fail
+	if err := RunScenario(context.Background(), network, &Scenario{Adapter: "exec"}); err == nil {
+		t.Fatal("expected an error running an exec scenario on a sim network")
+	}
+
+	// actions can refer to the mocker's nodes, which are created after the
+	// scenario's nodes
+	err = RegisterMocker("testScenario", func(net *Network, quit chan struct{}, conf *MockerConfig) {
+		startNodes(net, conf)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unregisterMocker("testScenario")
+	scenario = &Scenario{
+		Adapter: "sim",
+		Mocker:  &MockerConfig{Type: "testScenario", NodeCount: 2},
+		Actions: []ScenarioAction{{At: "0s", Type: "stop", Nodes: []int{4}}},
+	}
+	if err := RunScenario(context.Background(), network, scenario); err != nil {
+		t.Fatalf("error running scenario: %s", err)
+	}
+	nodes = network.GetNodes()
+	if len(nodes) != 5 {
+		t.Fatalf("expected 5 nodes, got %d", len(nodes))
+	}
+	if !nodes[3].Up || nodes[4].Up {
+		t.Fatalf("expected only the mocker's second node to be stopped")
+	}
+}
@@ -0,0 +1,233 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package simulations
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/event"
+	"github.com/ethereum/go-ethereum/metrics"
+	"github.com/ethereum/go-ethereum/p2p/simulations/adapters"
+)
+
+// Stats is a summary of the topology and churn of a network
+type Stats struct {
+	// Nodes is the number of nodes in the network
+	Nodes int `json:"nodes"`
+
+	// UpNodes is the number of nodes which are up
+	UpNodes int `json:"up_nodes"`
+
+	// OnlineRatio is the fraction of nodes which are up
+	OnlineRatio float64 `json:"online_ratio"`
+
+	// Conns is the number of connections which are up
+	Conns int `json:"conns"`
+
+	// MeanDegree and MedianDegree are the mean and median number of peers
+	// of the nodes which are up
+	MeanDegree   float64 `json:"mean_degree"`
+	MedianDegree float64 `json:"median_degree"`
+
+	// Clustering is the average local clustering coefficient of the nodes
+	// which are up (see ClusteringCoefficient)
+	Clustering float64 `json:"clustering"`
+
+	// ConnChurn is the number of connections which were established or
+	// dropped per second, averaged over the collector's window
+	ConnChurn float64 `json:"conn_churn"`
+
+	// Resources contains the resources used by each node which is up,
+	// keyed by node ID, for nodes which account for them (see
+	// adapters.ResourceReporter)
+	Resources map[string]*adapters.NodeResources `json:"resources,omitempty"`
+}
+
+// StatsCollector subscribes to a network's events to maintain rolling
+// statistics about the network
+type StatsCollector struct {
+	network *Network
+	window  time.Duration
+	start   time.Time
+
+	mtx   sync.Mutex
+	churn []time.Time // times of the conn events within the window
+
+	quit chan struct{}
+	done chan struct{}
+}
+
+// NewStatsCollector returns a collector which averages the connection churn
+// over the given window. It must be closed to stop it from consuming the
+// network's events.
+func NewStatsCollector(network *Network, window time.Duration) *StatsCollector {
+	s := &StatsCollector{
+		network: network,
+		window:  window,
+		start:   time.Now(),
+		quit:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	events := make(chan *Event)
+	sub := network.Events().Subscribe(events)
+	go s.loop(sub, events)
+	return s
+}
+
+func (s *StatsCollector) loop(sub event.Subscription, events chan *Event) {
+	defer close(s.done)
+	defer sub.Unsubscribe()
+	for {
+		select {
+		case event := <-events:
+			// control events are requests to connect or disconnect
+			// rather than changes to the connection
+			if event.Type != EventTypeConn || event.Control {
+				continue
+			}
+			s.mtx.Lock()
+			s.churn = append(s.churn, event.Time)
+			s.expire(time.Now())
+			s.mtx.Unlock()
+		case <-sub.Err():
+			return
+		case <-s.quit:
+			return
+		}
+	}
+}
+
+// expire drops the churn which happened before the window, the caller must
+// hold the lock
+func (s *StatsCollector) expire(now time.Time) {
+	cutoff := now.Add(-s.window)
+	i := sort.Search(len(s.churn), func(i int) bool {
+		return s.churn[i].After(cutoff)
+	})
+	s.churn = s.churn[i:]
+}
+
+// Stats returns the current statistics of the network
+func (s *StatsCollector) Stats() *Stats {
+	now := time.Now()
+	stats := networkStats(s.network)
+
+	// average over the time the collector has been running if that is
+	// shorter than the window
+	window := s.window
+	if running := now.Sub(s.start); running < window {
+		window = running
+	}
+	s.mtx.Lock()
+	s.expire(now)
+	if window > 0 {
+		stats.ConnChurn = float64(len(s.churn)) / window.Seconds()
+	}
+	s.mtx.Unlock()
+	return stats
+}
+
+// networkStats returns the current statistics of the network's topology,
+// leaving out the connection churn which only a StatsCollector measures
+func networkStats(network *Network) *Stats {
+	nodes, conns := network.upNodesAndConns()
+	stats := &Stats{
+		Nodes:      len(network.GetNodes()),
+		UpNodes:    len(nodes),
+		Conns:      len(conns),
+		Clustering: ClusteringCoefficient(nodes, conns),
+	}
+	if stats.Nodes > 0 {
+		stats.OnlineRatio = float64(stats.UpNodes) / float64(stats.Nodes)
+	}
+	if len(nodes) > 0 {
+		g := newGraph(nodes, conns)
+		degrees := make([]int, 0, len(nodes))
+		total := 0
+		for _, node := range nodes {
+			degree := len(g[node.ID()])
+			degrees = append(degrees, degree)
+			total += degree
+		}
+		sort.Ints(degrees)
+		stats.MeanDegree = float64(total) / float64(len(nodes))
+		if mid := len(degrees) / 2; len(degrees)%2 == 0 {
+			stats.MedianDegree = float64(degrees[mid-1]+degrees[mid]) / 2
+		} else {
+			stats.MedianDegree = float64(degrees[mid])
+		}
+	}
+
+	for _, node := range nodes {
+		if reporter, ok := node.Node.(adapters.ResourceReporter); ok {
+			if stats.Resources == nil {
+				stats.Resources = make(map[string]*adapters.NodeResources)
+			}
+			stats.Resources[node.ID().String()] = reporter.Resources()
+		}
+	}
+	return stats
+}
+
+// metricsStatsTTL is how long the gauges registered by RegisterMetrics
+// reuse the same statistics, so that a scrape reading every gauge only
+// computes them once
+const metricsStatsTTL = time.Second
+
+// RegisterMetrics registers gauges reporting the collector's statistics
+// with the given metrics registry (the default registry if nil), prefixing
+// their names with prefix
+func (s *StatsCollector) RegisterMetrics(prefix string, r metrics.Registry) {
+	var (
+		mtx      sync.Mutex
+		cached   *Stats
+		cachedAt time.Time
+	)
+	stats := func() *Stats {
+		mtx.Lock()
+		defer mtx.Unlock()
+		if cached == nil || time.Since(cachedAt) > metricsStatsTTL {
+			cached, cachedAt = s.Stats(), time.Now()
+		}
+		return cached
+	}
+	gauge := func(name string, f func(*Stats) float64) {
+		metrics.NewRegisteredFunctionalGaugeFloat64(prefix+name, r, func() float64 {
+			return f(stats())
+		})
+	}
+	gauge("nodes", func(stats *Stats) float64 { return float64(stats.Nodes) })
+	gauge("nodes/up", func(stats *Stats) float64 { return float64(stats.UpNodes) })
+	gauge("nodes/online", func(stats *Stats) float64 { return stats.OnlineRatio })
+	gauge("conns", func(stats *Stats) float64 { return float64(stats.Conns) })
+	gauge("conns/churn", func(stats *Stats) float64 { return stats.ConnChurn })
+	gauge("degree/mean", func(stats *Stats) float64 { return stats.MeanDegree })
+	gauge("degree/median", func(stats *Stats) float64 { return stats.MedianDegree })
+	gauge("clustering", func(stats *Stats) float64 { return stats.Clustering })
+}
+
+// Close stops the collector from consuming the network's events
+func (s *StatsCollector) Close() {
+	select {
+	case <-s.quit:
+	default:
+		close(s.quit)
+	}
+	<-s.done
+}
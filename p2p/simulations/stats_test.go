@@ -0,0 +1,91 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package simulations
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/node"
+	"github.com/ethereum/go-ethereum/p2p/discover"
+	"github.com/ethereum/go-ethereum/p2p/simulations/adapters"
+)
+
+// TestStatsCollector checks the statistics of a network of a triangle and an
+// isolated node
+func TestStatsCollector(t *testing.T) {
+	adapter := adapters.NewSimAdapter(adapters.Services{
+		"noop": func(ctx *adapters.ServiceContext) (node.Service, error) {
+			return noopService{}, nil
+		},
+	})
+	network := NewNetwork(adapter, &NetworkConfig{
+		DefaultService: "noop",
+	})
+	defer network.Shutdown()
+	collector := NewStatsCollector(network, time.Minute)
+	defer collector.Close()
+
+	ids := make([]discover.NodeID, 5)
+	for i := range ids {
+		node, err := network.NewNode()
+		if err != nil {
+			t.Fatalf("error creating node: %s", err)
+		}
+		ids[i] = node.ID()
+	}
+	// leave the last node down
+	for _, id := range ids[:4] {
+		if err := network.Start(id); err != nil {
+			t.Fatalf("error starting node: %s", err)
+		}
+	}
+	for _, pair := range [][2]int{{0, 1}, {1, 2}, {2, 0}} {
+		if err := network.Connect(ids[pair[0]], ids[pair[1]]); err != nil {
+			t.Fatalf("error connecting nodes: %s", err)
+		}
+	}
+	if err := NewNetworkExpectation().ConnsUp(3).Within(network, 10*time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	stats := collector.Stats()
+	if stats.Nodes != 5 || stats.UpNodes != 4 || stats.OnlineRatio != 0.8 {
+		t.Fatalf("expected 4 of 5 nodes up, got %d of %d (%f)", stats.UpNodes, stats.Nodes, stats.OnlineRatio)
+	}
+	if stats.Conns != 3 {
+		t.Fatalf("expected 3 connections, got %d", stats.Conns)
+	}
+	if stats.MeanDegree != 1.5 || stats.MedianDegree != 2 {
+		t.Fatalf("expected mean degree 1.5 and median 2, got %f and %f", stats.MeanDegree, stats.MedianDegree)
+	}
+	if stats.Clustering != 0.75 {
+		t.Fatalf("expected clustering of 0.75, got %f", stats.Clustering)
+	}
+	if stats.ConnChurn <= 0 {
+		t.Fatalf("expected connection churn, got %f", stats.ConnChurn)
+	}
+	if len(stats.Resources) != 4 {
+		t.Fatalf("expected resources of 4 nodes, got %d", len(stats.Resources))
+	}
+	if conns := stats.Resources[ids[0].String()].Conns; conns != 2 {
+		t.Fatalf("expected node 0 to have 2 connections open, got %d", conns)
+	}
+	if conns := stats.Resources[ids[3].String()].Conns; conns != 0 {
+		t.Fatalf("expected node 3 to have no connections open, got %d", conns)
+	}
+}
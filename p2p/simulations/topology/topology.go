@@ -0,0 +1,230 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+// Package topology generates the connections needed to build common network
+// topologies from a list of node IDs.
+//
+// Each generator returns pairs of node IDs to connect, with every pair
+// appearing once, so that they can be passed to a simulation network's
+// Connect method or used as a mocker's topology.
+package topology
+
+import (
+	"math"
+	"math/rand"
+
+	"github.com/ethereum/go-ethereum/p2p/discover"
+)
+
+// Ring returns the pairs of nodes to connect so that each node is connected
+// to its two neighbours in index order, with the last node connected to the
+// first
+func Ring(ids []discover.NodeID) [][2]discover.NodeID {
+	pairs := Chain(ids)
+	// two nodes are already connected by the chain
+	if len(ids) > 2 {
+		pairs = append(pairs, [2]discover.NodeID{ids[len(ids)-1], ids[0]})
+	}
+	return pairs
+}
+
+// Chain returns the pairs of nodes to connect so that each node is connected
+// to its two neighbours in index order
+func Chain(ids []discover.NodeID) [][2]discover.NodeID {
+	var pairs [][2]discover.NodeID
+	for i := 1; i < len(ids); i++ {
+		pairs = append(pairs, [2]discover.NodeID{ids[i-1], ids[i]})
+	}
+	return pairs
+}
+
+// Star returns the pairs of nodes to connect so that every node is connected
+// to the first node
+func Star(ids []discover.NodeID) [][2]discover.NodeID {
+	var pairs [][2]discover.NodeID
+	for i := 1; i < len(ids); i++ {
+		pairs = append(pairs, [2]discover.NodeID{ids[i], ids[0]})
+	}
+	return pairs
+}
+
+// FullMesh returns the pairs of nodes to connect so that every node is
+// connected to every other node
+func FullMesh(ids []discover.NodeID) [][2]discover.NodeID {
+	var pairs [][2]discover.NodeID
+	for i := range ids {
+		for j := i + 1; j < len(ids); j++ {
+			pairs = append(pairs, [2]discover.NodeID{ids[i], ids[j]})
+		}
+	}
+	return pairs
+}
+
+// CorePeriphery returns the pairs of nodes to connect so that the first
+// coreSize nodes form a complete graph and every other node connects to
+// peripheryPeers random core nodes picked using r
+func CorePeriphery(ids []discover.NodeID, coreSize, peripheryPeers int, r *rand.Rand) [][2]discover.NodeID {
+	if coreSize > len(ids) {
+		coreSize = len(ids)
+	}
+	if peripheryPeers > coreSize {
+		peripheryPeers = coreSize
+	}
+	pairs := FullMesh(ids[:coreSize])
+	for _, id := range ids[coreSize:] {
+		for _, i := range r.Perm(coreSize)[:peripheryPeers] {
+			pairs = append(pairs, [2]discover.NodeID{id, ids[i]})
+		}
+	}
+	return pairs
+}
+
+// Grid returns the pairs of nodes to connect so that the nodes form a 2D
+// grid with the given width, filled row by row, where each node is connected
+// to the nodes next to it in its row and column
+func Grid(ids []discover.NodeID, width int) [][2]discover.NodeID {
+	if width < 1 {
+		width = 1
+	}
+	var pairs [][2]discover.NodeID
+	for i := range ids {
+		if (i+1)%width != 0 && i+1 < len(ids) {
+			pairs = append(pairs, [2]discover.NodeID{ids[i], ids[i+1]})
+		}
+		if i+width < len(ids) {
+			pairs = append(pairs, [2]discover.NodeID{ids[i], ids[i+width]})
+		}
+	}
+	return pairs
+}
+
+// SquareGridWidth returns the width of the smallest square grid which fits
+// the given number of nodes
+func SquareGridWidth(nodeCount int) int {
+	return int(math.Ceil(math.Sqrt(float64(nodeCount))))
+}
+
+// SmallWorld returns the pairs of nodes to connect to build a Watts-Strogatz
+// small-world network.
+//
+// Each node is first connected to its degree/2 nearest neighbours on either
+// side in index order, then each of those connections is rewired with
+// probability beta to a random node it isn't already connected to.
+func SmallWorld(ids []discover.NodeID, degree int, beta float64, r *rand.Rand) [][2]discover.NodeID {
+	n := len(ids)
+	k := degree / 2
+	if k > n/2 {
+		k = n / 2
+	}
+	edges := newEdgeSet(n)
+	for i := 0; i < n; i++ {
+		for j := 1; j <= k; j++ {
+			edges.add(i, (i+j)%n)
+		}
+	}
+	for j := 1; j <= k; j++ {
+		for i := 0; i < n; i++ {
+			other := (i + j) % n
+			if r.Float64() >= beta || !edges.has(i, other) || len(edges.adj[i]) >= n-1 {
+				continue
+			}
+			target := r.Intn(n)
+			for target == i || edges.has(i, target) {
+				target = r.Intn(n)
+			}
+			edges.remove(i, other)
+			edges.add(i, target)
+		}
+	}
+	return edges.pairs(ids)
+}
+
+// ScaleFree returns the pairs of nodes to connect to build a
+// Barabási-Albert scale-free network.
+//
+// The first m+1 nodes are fully connected, then each following node is
+// connected to m distinct earlier nodes chosen with a probability
+// proportional to their degree.
+func ScaleFree(ids []discover.NodeID, m int, r *rand.Rand) [][2]discover.NodeID {
+	if m < 1 {
+		m = 1
+	}
+	n := len(ids)
+	edges := newEdgeSet(n)
+	// targets holds each node once per connection it has, so picking from
+	// it uniformly picks nodes proportionally to their degree
+	var targets []int
+	for i := 0; i <= m && i < n; i++ {
+		for j := 0; j < i; j++ {
+			edges.add(i, j)
+			targets = append(targets, i, j)
+		}
+	}
+	for i := m + 1; i < n; i++ {
+		var chosen []int
+		for len(chosen) < m {
+			if target := targets[r.Intn(len(targets))]; !edges.has(i, target) {
+				edges.add(i, target)
+				chosen = append(chosen, target)
+			}
+		}
+		for _, target := range chosen {
+			targets = append(targets, i, target)
+		}
+	}
+	return edges.pairs(ids)
+}
+
+// edgeSet is a set of undirected edges between node indexes
+type edgeSet struct {
+	adj []map[int]struct{}
+}
+
+func newEdgeSet(n int) *edgeSet {
+	adj := make([]map[int]struct{}, n)
+	for i := range adj {
+		adj[i] = make(map[int]struct{})
+	}
+	return &edgeSet{adj: adj}
+}
+
+func (e *edgeSet) add(i, j int) {
+	e.adj[i][j] = struct{}{}
+	e.adj[j][i] = struct{}{}
+}
+
+func (e *edgeSet) remove(i, j int) {
+	delete(e.adj[i], j)
+	delete(e.adj[j], i)
+}
+
+func (e *edgeSet) has(i, j int) bool {
+	_, ok := e.adj[i][j]
+	return ok
+}
+
+// pairs returns the edges as pairs of node IDs in a deterministic order
+func (e *edgeSet) pairs(ids []discover.NodeID) [][2]discover.NodeID {
+	var pairs [][2]discover.NodeID
+	for i := range e.adj {
+		for j := i + 1; j < len(e.adj); j++ {
+			if e.has(i, j) {
+				pairs = append(pairs, [2]discover.NodeID{ids[i], ids[j]})
+			}
+		}
+	}
+	return pairs
+}
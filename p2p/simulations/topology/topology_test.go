@@ -0,0 +1,199 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package topology
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/p2p/discover"
+)
+
+func testIDs(n int) []discover.NodeID {
+	ids := make([]discover.NodeID, n)
+	for i := range ids {
+		ids[i][0] = byte(i >> 8)
+		ids[i][1] = byte(i)
+	}
+	return ids
+}
+
+// checkPairs checks that the pairs don't contain self connections or
+// duplicates and returns the degree of each node
+func checkPairs(t *testing.T, name string, ids []discover.NodeID, pairs [][2]discover.NodeID) []int {
+	index := make(map[discover.NodeID]int)
+	for i, id := range ids {
+		index[id] = i
+	}
+	seen := make(map[[2]int]bool)
+	degrees := make([]int, len(ids))
+	for _, pair := range pairs {
+		i, j := index[pair[0]], index[pair[1]]
+		if i == j {
+			t.Fatalf("%s: expected no self connections, got node %d", name, i)
+		}
+		if i > j {
+			i, j = j, i
+		}
+		if seen[[2]int{i, j}] {
+			t.Fatalf("%s: expected nodes %d and %d to be connected once", name, i, j)
+		}
+		seen[[2]int{i, j}] = true
+		degrees[i]++
+		degrees[j]++
+	}
+	return degrees
+}
+
+func TestFixedTopologies(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		pairs   func([]discover.NodeID) [][2]discover.NodeID
+		count   int
+		degrees []int
+	}{
+		{"ring", Ring, 5, []int{2, 2, 2, 2, 2}},
+		{"ring of two", Ring, 2, []int{1, 1}},
+		{"ring of one", Ring, 1, []int{0}},
+		{"chain", Chain, 4, []int{1, 2, 2, 1}},
+		{"star", Star, 5, []int{4, 1, 1, 1, 1}},
+		{"fullMesh", FullMesh, 5, []int{4, 4, 4, 4, 4}},
+		{"grid", func(ids []discover.NodeID) [][2]discover.NodeID { return Grid(ids, 3) }, 9, []int{2, 3, 2, 3, 4, 3, 2, 3, 2}},
+		{"partial grid", func(ids []discover.NodeID) [][2]discover.NodeID { return Grid(ids, 3) }, 5, []int{2, 3, 1, 2, 2}},
+	} {
+		ids := testIDs(test.count)
+		degrees := checkPairs(t, test.name, ids, test.pairs(ids))
+		for i := range degrees {
+			if degrees[i] != test.degrees[i] {
+				t.Fatalf("%s: expected node %d to have degree %d, got %d", test.name, i, test.degrees[i], degrees[i])
+			}
+		}
+	}
+}
+
+func TestCorePeriphery(t *testing.T) {
+	ids := testIDs(10)
+	coreSize, peripheryPeers := 4, 2
+	degrees := checkPairs(t, "core periphery", ids, CorePeriphery(ids, coreSize, peripheryPeers, rand.New(rand.NewSource(1))))
+
+	// core nodes form a complete graph, and periphery nodes only connect to
+	// core nodes
+	core := make([]int, len(ids))
+	for _, pair := range CorePeriphery(ids, coreSize, peripheryPeers, rand.New(rand.NewSource(1))) {
+		i, j := int(pair[0][1]), int(pair[1][1])
+		if i >= coreSize && j >= coreSize {
+			t.Fatalf("expected periphery nodes %d and %d not to be connected", i, j)
+		}
+		if i < coreSize {
+			core[j]++
+		}
+		if j < coreSize {
+			core[i]++
+		}
+	}
+	for i := range ids {
+		if i < coreSize && core[i] != coreSize-1 {
+			t.Fatalf("expected core node %d to be connected to the other %d core nodes, got %d", i, coreSize-1, core[i])
+		}
+		if i >= coreSize && (degrees[i] != peripheryPeers || core[i] != peripheryPeers) {
+			t.Fatalf("expected periphery node %d to have %d core peers, got %d", i, peripheryPeers, degrees[i])
+		}
+	}
+}
+
+func TestSquareGridWidth(t *testing.T) {
+	for count, width := range map[int]int{1: 1, 4: 2, 5: 3, 9: 3, 10: 4} {
+		if w := SquareGridWidth(count); w != width {
+			t.Fatalf("expected width %d for %d nodes, got %d", width, count, w)
+		}
+	}
+}
+
+func TestSmallWorld(t *testing.T) {
+	ids := testIDs(50)
+
+	// without rewiring every node has the requested degree
+	degrees := checkPairs(t, "lattice", ids, SmallWorld(ids, 4, 0, rand.New(rand.NewSource(1))))
+	for i, degree := range degrees {
+		if degree != 4 {
+			t.Fatalf("expected node %d to have degree 4, got %d", i, degree)
+		}
+	}
+
+	// rewiring keeps the number of connections and changes some of them
+	lattice := SmallWorld(ids, 4, 0, rand.New(rand.NewSource(1)))
+	rewired := SmallWorld(ids, 4, 0.5, rand.New(rand.NewSource(1)))
+	checkPairs(t, "small world", ids, rewired)
+	if len(rewired) != len(lattice) {
+		t.Fatalf("expected %d connections, got %d", len(lattice), len(rewired))
+	}
+	same := make(map[[2]discover.NodeID]bool)
+	for _, pair := range lattice {
+		same[pair] = true
+	}
+	var changed int
+	for _, pair := range rewired {
+		if !same[pair] {
+			changed++
+		}
+	}
+	if changed == 0 {
+		t.Fatal("expected some connections to be rewired")
+	}
+
+	// small networks don't have duplicate connections
+	for n := 1; n < 6; n++ {
+		ids := testIDs(n)
+		checkPairs(t, "small network", ids, SmallWorld(ids, 4, 0.5, rand.New(rand.NewSource(1))))
+	}
+}
+
+func TestScaleFree(t *testing.T) {
+	ids := testIDs(200)
+	m := 2
+	pairs := ScaleFree(ids, m, rand.New(rand.NewSource(1)))
+	degrees := checkPairs(t, "scale free", ids, pairs)
+
+	// the initial nodes form a complete graph and every later node adds m
+	// connections
+	expected := m*(m+1)/2 + (len(ids)-m-1)*m
+	if len(pairs) != expected {
+		t.Fatalf("expected %d connections, got %d", expected, len(pairs))
+	}
+	max := 0
+	for i, degree := range degrees {
+		if degree < m {
+			t.Fatalf("expected node %d to have at least %d connections, got %d", i, m, degree)
+		}
+		if degree > max {
+			max = degree
+		}
+	}
+	// preferential attachment should produce hubs much larger than the
+	// average degree
+	if max < 4*2*m {
+		t.Fatalf("expected a hub with at least %d connections, got %d", 4*2*m, max)
+	}
+
+	// the same seed generates the same network
+	again := ScaleFree(ids, m, rand.New(rand.NewSource(1)))
+	for i := range pairs {
+		if pairs[i] != again[i] {
+			t.Fatalf("expected connection %d to be the same for the same seed", i)
+		}
+	}
+}
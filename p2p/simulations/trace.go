@@ -0,0 +1,169 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package simulations
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/event"
+	"github.com/ethereum/go-ethereum/p2p/simulations/adapters"
+)
+
+// TraceSession is a period during which a node of a real network was
+// online, as recorded in a churn trace
+type TraceSession struct {
+	// Node identifies the node in the trace (e.g. an IP address or a
+	// peer ID from a crawler's dataset)
+	Node string
+
+	// Start and End are the times the node came online and went offline,
+	// relative to the start of the trace
+	Start time.Duration
+	End   time.Duration
+}
+
+// ReadChurnTrace reads the sessions of a churn trace from CSV records of the
+// form "node,start,end", with the times in (fractional) seconds from the
+// start of the trace. A header row and lines starting with '#' are ignored.
+func ReadChurnTrace(r io.Reader) ([]TraceSession, error) {
+	reader := csv.NewReader(r)
+	reader.Comment = '#'
+	reader.FieldsPerRecord = 3
+	reader.TrimLeadingSpace = true
+	var sessions []TraceSession
+	for line := 1; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		if line == 1 && strings.EqualFold(record[1], "start") {
+			continue
+		}
+		start, err := parseTraceTime(record[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid start time in record %d: %v", line, err)
+		}
+		end, err := parseTraceTime(record[2])
+		if err != nil {
+			return nil, fmt.Errorf("invalid end time in record %d: %v", line, err)
+		}
+		sessions = append(sessions, TraceSession{Node: record[0], Start: start, End: end})
+	}
+	if err := validateTrace(sessions); err != nil {
+		return nil, err
+	}
+	return sessions, nil
+}
+
+func parseTraceTime(s string) (time.Duration, error) {
+	secs, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(secs * float64(time.Second)), nil
+}
+
+// validateTrace checks that sessions end after they start and that the
+// sessions of each node don't overlap
+func validateTrace(sessions []TraceSession) error {
+	byNode := make(map[string][]TraceSession)
+	for _, s := range sessions {
+		if s.Start < 0 || s.End <= s.Start {
+			return fmt.Errorf("invalid session of node %q from %s to %s", s.Node, s.Start, s.End)
+		}
+		byNode[s.Node] = append(byNode[s.Node], s)
+	}
+	for node, sessions := range byNode {
+		sort.Slice(sessions, func(i, j int) bool { return sessions[i].Start < sessions[j].Start })
+		for i := 1; i < len(sessions); i++ {
+			if sessions[i].Start < sessions[i-1].End {
+				return fmt.Errorf("overlapping sessions of node %q at %s", node, sessions[i].Start)
+			}
+		}
+	}
+	return nil
+}
+
+// TraceJournal converts the sessions of a churn trace into journal entries
+// which start and stop simulation nodes, so that the trace can be replayed
+// (see ReplayJournal), compacted or stored like a recorded journal.
+//
+// Each node in the trace is mapped onto a node with a generated ID which is
+// named after its trace identity.
+func TraceJournal(sessions []TraceSession) ([]JournalEntry, error) {
+	configs := make(map[string]*adapters.NodeConfig)
+	var entries []JournalEntry
+	for _, s := range sessions {
+		conf, ok := configs[s.Node]
+		if !ok {
+			var err error
+			if conf, err = adapters.GenerateNodeConfig(); err != nil {
+				return nil, err
+			}
+			conf.Name = s.Node
+			configs[s.Node] = conf
+		}
+		entries = append(entries,
+			JournalEntry{Time: s.Start, Event: ControlEvent(&Node{Config: conf, Up: true})},
+			JournalEntry{Time: s.End, Event: ControlEvent(&Node{Config: conf, Up: false})},
+		)
+	}
+	// a node going offline is ordered before another coming online at the
+	// same time
+	sort.SliceStable(entries, func(i, j int) bool {
+		if entries[i].Time != entries[j].Time {
+			return entries[i].Time < entries[j].Time
+		}
+		return !entries[i].Event.Node.Up && entries[j].Event.Node.Up
+	})
+	return entries, nil
+}
+
+// RunTrace drives the network through the sessions of a churn trace at the
+// given speed (see ReplayJournal), creating a node for each node in the trace
+// when it first comes online. It returns once the last session has ended or
+// the context is done.
+func RunTrace(ctx context.Context, network *Network, sessions []TraceSession, speed float64) error {
+	entries, err := TraceJournal(sessions)
+	if err != nil {
+		return err
+	}
+	var feed event.Feed
+	events := make(chan *Event)
+	sub := feed.Subscribe(events)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		network.Subscribe(events)
+		// stop the replay blocking if the network shuts down
+		sub.Unsubscribe()
+	}()
+	err = ReplayJournal(ctx, &feed, entries, speed)
+	sub.Unsubscribe()
+	close(events)
+	<-done
+	return err
+}
@@ -0,0 +1,112 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package simulations
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/node"
+	"github.com/ethereum/go-ethereum/p2p/simulations/adapters"
+)
+
+func TestReadChurnTrace(t *testing.T) {
+	trace := `node,start,end
+# sessions of two nodes
+a,0,0.05
+b, 0.02, 0.1
+a,0.06,0.2
+`
+	sessions, err := ReadChurnTrace(strings.NewReader(trace))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []TraceSession{
+		{Node: "a", Start: 0, End: 50 * time.Millisecond},
+		{Node: "b", Start: 20 * time.Millisecond, End: 100 * time.Millisecond},
+		{Node: "a", Start: 60 * time.Millisecond, End: 200 * time.Millisecond},
+	}
+	if len(sessions) != len(expected) {
+		t.Fatalf("expected %d sessions, got %d", len(expected), len(sessions))
+	}
+	for i, s := range sessions {
+		if s != expected[i] {
+			t.Fatalf("expected session %d to be %v, got %v", i, expected[i], s)
+		}
+	}
+
+	for _, invalid := range []string{
+		"a,0,x\n",
+		"a,1,1\n",
+		"a,0,2\na,1,3\n",
+		"a,0\n",
+	} {
+		if _, err := ReadChurnTrace(strings.NewReader(invalid)); err == nil {
+			t.Fatalf("expected an error reading trace %q", invalid)
+		}
+	}
+}
+
+func TestRunTrace(t *testing.T) {
+	adapter := adapters.NewSimAdapter(adapters.Services{
+		"noop": func(ctx *adapters.ServiceContext) (node.Service, error) {
+			return noopService{}, nil
+		},
+	})
+	network := NewNetwork(adapter, &NetworkConfig{
+		DefaultService: "noop",
+	})
+	defer network.Shutdown()
+	events := make(chan *Event, 100)
+	sub := network.Events().Subscribe(events)
+	defer sub.Unsubscribe()
+
+	sessions := []TraceSession{
+		{Node: "a", Start: 0, End: 50 * time.Millisecond},
+		{Node: "b", Start: 20 * time.Millisecond, End: 100 * time.Millisecond},
+		{Node: "a", Start: 60 * time.Millisecond, End: 200 * time.Millisecond},
+	}
+	if err := RunTrace(context.Background(), network, sessions, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	// each trace node is mapped onto one simulation node, which is down
+	// once the trace has ended
+	nodes := network.GetNodes()
+	if len(nodes) != 2 {
+		t.Fatalf("expected 2 nodes, got %d", len(nodes))
+	}
+	for _, node := range nodes {
+		if node.Up {
+			t.Fatalf("expected node %s to be down", node.Config.Name)
+		}
+	}
+
+	// node a was started for each of its sessions
+	starts := make(map[string]int)
+	for len(events) > 0 {
+		event := <-events
+		if event.Type == EventTypeNode && !event.Control && event.Node.Up {
+			starts[event.Node.Config.Name]++
+		}
+	}
+	if starts["a"] != 2 || starts["b"] != 1 {
+		t.Fatalf("expected a to be started twice and b once, got %v", starts)
+	}
+}
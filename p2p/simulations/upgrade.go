@@ -0,0 +1,175 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package simulations
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/p2p/discover"
+)
+
+// Upgrade changes the version of the node with the given ID (see
+// adapters.NodeConfig.Version), restarting it if it is up so that its
+// services are created with the new version, and emits an upgrade event.
+// The connections the node had up before being restarted are reconnected.
+func (self *Network) Upgrade(id discover.NodeID, version string) error {
+	node := self.GetNode(id)
+	if node == nil {
+		return fmt.Errorf("node %v does not exist", id)
+	}
+	up := self.isUp(node)
+	var peers []discover.NodeID
+	if up {
+		// ask the node for its peers rather than using its connections,
+		// since connect events may still be on their way
+		var err error
+		if peers, err = self.peerIDs(node); err != nil {
+			return err
+		}
+		if err := self.Stop(id); err != nil {
+			return err
+		}
+		self.waitDropped(id, peers)
+	}
+	self.lock.Lock()
+	node.Config.Version = version
+	event := ControlEvent(node)
+	self.lock.Unlock()
+	log.Info(fmt.Sprintf("upgraded node %v to version %q", id, version))
+
+	event.Type = EventTypeUpgrade
+	self.events.Send(event)
+
+	if !up {
+		return nil
+	}
+	if err := self.Start(id); err != nil {
+		return err
+	}
+	for _, peer := range peers {
+		if err := self.reconnect(id, peer); err != nil {
+			log.Warn(fmt.Sprintf("error reconnecting upgraded node %v to %v: %v", id, peer, err))
+		}
+	}
+	return nil
+}
+
+// peerDropTimeout is how long Upgrade waits for the peers of a stopped node
+// to drop their connections to it
+var peerDropTimeout = time.Second
+
+// waitDropped waits until the peers no longer have a connection to the
+// stopped node with the given ID, or peerDropTimeout has passed, since they
+// reject the node's new connection while they still have the old one
+func (self *Network) waitDropped(id discover.NodeID, peers []discover.NodeID) {
+	deadline := time.Now().Add(peerDropTimeout)
+	for _, peerID := range peers {
+		client, err := self.GetNode(peerID).Client()
+		if err != nil {
+			continue
+		}
+		for time.Now().Before(deadline) {
+			var infos []*p2p.PeerInfo
+			if err := client.Call(&infos, "admin_peers"); err != nil || !hasPeer(infos, id) {
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+}
+
+// peerIDs returns the IDs of the peers the node is connected to
+func (self *Network) peerIDs(node *Node) ([]discover.NodeID, error) {
+	client, err := node.Client()
+	if err != nil {
+		return nil, fmt.Errorf("error getting rpc client for node %v: %s", node.ID(), err)
+	}
+	var infos []*p2p.PeerInfo
+	if err := client.Call(&infos, "admin_peers"); err != nil {
+		return nil, fmt.Errorf("error getting peers of node %v: %s", node.ID(), err)
+	}
+	ids := make([]discover.NodeID, 0, len(infos))
+	for _, info := range infos {
+		id, err := discover.HexID(info.ID)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// hasPeer returns whether the peer with the given ID is one of the peers
+func hasPeer(infos []*p2p.PeerInfo, id discover.NodeID) bool {
+	for _, info := range infos {
+		if info.ID == id.String() {
+			return true
+		}
+	}
+	return false
+}
+
+// reconnect connects a restarted node to a peer it was connected to. The
+// restarted node dials unless the peer is behind a NAT, since a peer which
+// dialed the node before won't dial it again for a while (see
+// p2p.dialHistoryExpiration).
+func (self *Network) reconnect(id, peerID discover.NodeID) error {
+	conn, err := self.InitConn(id, peerID)
+	if err != nil {
+		return err
+	}
+	dialer, dialee := self.GetNode(id), self.GetNode(peerID)
+	if dialee.Config.NAT {
+		dialer, dialee = dialee, dialer
+	}
+	return self.dial(conn, dialer, dialee)
+}
+
+// UpgradeWave schedules upgrading a set of nodes to a new version over time,
+// as happens when a new client release is rolled out
+type UpgradeWave struct {
+	// Version is the version the nodes are upgraded to
+	Version string `json:"version"`
+
+	// Nodes are the nodes to upgrade, in the order they are upgraded
+	Nodes []discover.NodeID `json:"nodes"`
+
+	// Interval is the time between upgrading one node and the next
+	Interval time.Duration `json:"interval"`
+}
+
+// RunUpgradeWave upgrades the wave's nodes one at a time until they have
+// all been upgraded or the context is done, returning the first error
+func RunUpgradeWave(ctx context.Context, network *Network, wave *UpgradeWave) error {
+	for i, id := range wave.Nodes {
+		if i > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(wave.Interval):
+			}
+		}
+		if err := network.Upgrade(id, wave.Version); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package simulations
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/node"
+	"github.com/ethereum/go-ethereum/p2p/discover"
+	"github.com/ethereum/go-ethereum/p2p/simulations/adapters"
+	"github.com/ethereum/go-ethereum/p2p/simulations/topology"
+)
+
+// TestUpgradeWave checks that an upgrade wave restarts each node with the
+// new version, emitting an upgrade event for each and keeping the nodes'
+// connections
+func TestUpgradeWave(t *testing.T) {
+	// record the version each node's service was last created with
+	var mtx sync.Mutex
+	versions := make(map[discover.NodeID]string)
+	adapter := adapters.NewSimAdapter(adapters.Services{
+		"noop": func(ctx *adapters.ServiceContext) (node.Service, error) {
+			mtx.Lock()
+			defer mtx.Unlock()
+			versions[ctx.Config.ID] = ctx.Config.Version
+			return noopService{}, nil
+		},
+	})
+	network := NewNetwork(adapter, &NetworkConfig{
+		DefaultService: "noop",
+	})
+	defer network.Shutdown()
+
+	ids := make([]discover.NodeID, 4)
+	for i := range ids {
+		conf := adapters.RandomNodeConfig()
+		conf.Version = "v1"
+		node, err := network.NewNodeWithConfig(conf)
+		if err != nil {
+			t.Fatalf("error creating node: %s", err)
+		}
+		ids[i] = node.ID()
+	}
+	// connect the first three nodes in a ring, leaving the last node down
+	if err := network.StartNodes(ids[:3]); err != nil {
+		t.Fatalf("error starting nodes: %s", err)
+	}
+	if err := network.ConnectNodes(topology.Ring(ids[:3])); err != nil {
+		t.Fatalf("error connecting nodes: %s", err)
+	}
+	if err := NewNetworkExpectation().NodesUp(3).ConnsUp(3).Within(network, 10*time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	events := make(chan *Event, 100)
+	sub := network.Events().Subscribe(events)
+	defer sub.Unsubscribe()
+	wave := &UpgradeWave{Version: "v2", Nodes: ids, Interval: 10 * time.Millisecond}
+	if err := RunUpgradeWave(context.Background(), network, wave); err != nil {
+		t.Fatalf("error running upgrade wave: %s", err)
+	}
+
+	var upgraded []discover.NodeID
+	for len(upgraded) < len(ids) {
+		select {
+		case event := <-events:
+			if event.Type != EventTypeUpgrade {
+				continue
+			}
+			if !event.Control || event.Node.Config.Version != "v2" {
+				t.Fatalf("unexpected upgrade event: %v", event)
+			}
+			upgraded = append(upgraded, event.Node.ID())
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for upgrade events")
+		}
+	}
+	for i, id := range ids {
+		if upgraded[i] != id {
+			t.Fatalf("expected node %d to be upgraded in order", i)
+		}
+		node := network.GetNode(id)
+		if node.Config.Version != "v2" {
+			t.Fatalf("expected node %d to have version v2, got %q", i, node.Config.Version)
+		}
+		if up := i < 3; network.isUp(node) != up {
+			t.Fatalf("expected node %d up to be %t", i, up)
+		}
+	}
+	if err := NewNetworkExpectation().NodesUp(3).ConnsUp(3).FullMesh().Within(network, 10*time.Second); err != nil {
+		t.Fatalf("expected the upgraded nodes to be reconnected: %s", err)
+	}
+
+	mtx.Lock()
+	defer mtx.Unlock()
+	for _, id := range ids[:3] {
+		if versions[id] != "v2" {
+			t.Fatalf("expected the service of a restarted node to be created with v2, got %q", versions[id])
+		}
+	}
+	if _, ok := versions[ids[3]]; ok {
+		t.Fatal("expected the service of the down node not to be created")
+	}
+}